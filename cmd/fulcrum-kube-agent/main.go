@@ -6,13 +6,18 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"fulcrumproject.org/kube-agent/internal/addons"
 	"fulcrumproject.org/kube-agent/internal/agent"
+	"fulcrumproject.org/kube-agent/internal/cloudinit"
 	"fulcrumproject.org/kube-agent/internal/config"
 	"fulcrumproject.org/kube-agent/internal/fulcrum"
+	"fulcrumproject.org/kube-agent/internal/helm"
 	"fulcrumproject.org/kube-agent/internal/httpcli"
+	"fulcrumproject.org/kube-agent/internal/ipam"
 	"fulcrumproject.org/kube-agent/internal/kamaji"
 	"fulcrumproject.org/kube-agent/internal/proxmox"
 	"fulcrumproject.org/kube-agent/internal/ssh"
@@ -24,7 +29,19 @@ func main() {
 	useMock := flag.Bool("mock", false, "Use mock implementations of clients")
 	flag.Parse()
 
-	cfg, err := config.Builder().LoadFile(configPath).WithEnv().Build()
+	builder := config.Builder().LoadFile(configPath).WithEnv()
+
+	// Commands run against Proxmox instead of the agent, and only need its connection
+	if flag.Arg(0) == buildTemplateCommand {
+		cfg, err := builder.BuildProxmox()
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		buildTemplate(cfg, flag.Args()[1:])
+		return
+	}
+
+	cfg, err := builder.Build()
 	if err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
@@ -41,8 +58,168 @@ func main() {
 	}
 	defer clients.Close()
 
+	// Configure the access to the worker nodes and the sources they join from
+	options := []agent.JobHandlerOption{
+		agent.WithNodeCredentials(agent.NodeCredentialsConfig{
+			User:           cfg.NodeUser,
+			AuthorizedKeys: splitList(cfg.NodeAuthorizedKeys),
+			PasswordLogin:  cfg.NodePasswordLogin,
+		}),
+		agent.WithBootstrap(agent.BootstrapConfig{
+			PackageRepository: cfg.NodePackageRepository,
+			RegistryMirror:    cfg.NodeRegistryMirror,
+			TokenTTL:          cfg.NodeTokenTTL,
+		}),
+	}
+
+	// Enable the optional job handler features
+	cloudInitCfg := &agent.CloudInitConfig{}
+	if cfg.CloudInitTemplatesPath != "" || cfg.CloudInitDefaultTemplate != "" || len(cfg.CloudInitSizeTemplates) > 0 {
+		var err error
+		cloudInitCfg, err = cloudInitTemplates(cfg)
+		if err != nil {
+			log.Fatalf("Invalid cloud-init templates configuration: %v", err)
+		}
+		options = append(options, agent.WithCloudInitTemplates(*cloudInitCfg))
+	}
+	if len(cfg.NodeTemplates) > 0 {
+		registry := nodeTemplates(cfg)
+		// The mock Proxmox client has no templates to check
+		if !*useMock {
+			if err := registry.Validate(clients.Proxmox); err != nil {
+				log.Fatalf("Invalid node templates configuration: %v", err)
+			}
+		}
+		if err := cloudInitCfg.ValidateNodeTemplates(registry.Templates); err != nil {
+			log.Fatalf("Invalid node templates configuration: %v", err)
+		}
+		options = append(options, agent.WithTemplateRegistry(registry))
+	}
+	if cfg.GuestAgentEnabled {
+		options = append(options, agent.WithGuestAgent(agent.GuestAgentConfig{
+			BootTimeout: cfg.GuestAgentBootTimeout,
+			LogLines:    cfg.GuestAgentLogLines,
+		}))
+	}
+	if cfg.SnippetStore == config.SnippetStoreISO {
+		store := agent.NewISOSnippetStore(clients.Proxmox, cfg.SnippetISOStorage, cfg.SnippetISODrive)
+		// The seed ISOs are uploaded through the configured host, and read by the VMs of all the hosts
+		if cfg.ProxmoxPlacement != "" && !*useMock {
+			if err := store.ValidateShared(); err != nil {
+				log.Fatalf("Invalid snippet store configuration: %v", err)
+			}
+		}
+		options = append(options, agent.WithSnippetStore(store))
+	}
+	if cfg.ProxmoxPlacement != "" {
+		policy, err := agent.NewPlacementPolicy(cfg.ProxmoxPlacement)
+		if err != nil {
+			log.Fatalf("Invalid placement configuration: %v", err)
+		}
+		options = append(options, agent.WithPlacement(agent.PlacementConfig{
+			Policy:     policy,
+			HostLabels: cfg.ProxmoxHostLabels,
+		}))
+	}
+	if cfg.ProxmoxHAGroup != "" {
+		options = append(options, agent.WithHA(agent.HAConfig{Group: cfg.ProxmoxHAGroup}))
+	}
+	options = append(options, agent.WithShutdown(agent.ShutdownConfig{
+		Timeout:  cfg.NodeShutdownTimeout,
+		HardStop: cfg.NodeHardStop,
+	}))
+	options = append(options, agent.WithParallelProvisioning(agent.ParallelConfig{
+		Nodes:  cfg.NodeConcurrency,
+		Clones: cfg.CloneConcurrency,
+	}))
+	if cfg.ProxmoxLinkedClones {
+		options = append(options, agent.WithClones(agent.CloneConfig{Linked: true, Storage: cfg.ProxmoxStorage}))
+	}
+	if len(cfg.NodePoolSizes) > 0 {
+		sizes := make(map[agent.NodeSize]int, len(cfg.NodePoolSizes))
+		for size, count := range cfg.NodePoolSizes {
+			sizes[agent.NodeSize(size)] = count
+		}
+		options = append(options, agent.WithVMPool(agent.PoolConfig{
+			Sizes:          sizes,
+			RefillInterval: cfg.NodePoolRefillInterval,
+		}))
+	}
+	if cfg.ProxmoxCapacityCheck {
+		options = append(options, agent.WithCapacityCheck(agent.CapacityConfig{
+			Storage:    cfg.ProxmoxStorage,
+			Overcommit: cfg.ProxmoxOvercommit,
+		}))
+	}
+	quotas := agent.QuotaConfig{
+		MaxNodesPerService: cfg.QuotaMaxNodesPerService,
+		MaxTenants:         cfg.QuotaMaxTenants,
+		Tenant:             agent.QuotaResources{CPU: cfg.QuotaTenantCPU, Memory: cfg.QuotaTenantMemory, Disk: cfg.QuotaTenantDisk},
+		Agent:              agent.QuotaResources{CPU: cfg.QuotaAgentCPU, Memory: cfg.QuotaAgentMemory, Disk: cfg.QuotaAgentDisk},
+	}
+	if quotas != (agent.QuotaConfig{}) {
+		options = append(options, agent.WithQuotas(quotas))
+	}
+	if cfg.AddonsPath != "" {
+		options = append(options, agent.WithAddonCatalog(addons.NewDirCatalog(cfg.AddonsPath)))
+	}
+	if cfg.ProxmoxCSIEnabled {
+		csiAPIURL := cfg.ProxmoxCSIAPIURL
+		if csiAPIURL == "" {
+			csiAPIURL = cfg.ProxmoxAPIURL
+		}
+		options = append(options, agent.WithProxmoxCSI(agent.CSIConfig{
+			Chart:    cfg.ProxmoxCSIChart,
+			Version:  cfg.ProxmoxCSIVersion,
+			APIURL:   csiAPIURL,
+			Insecure: cfg.SkipTLSVerify,
+			Region:   cfg.ProxmoxCSIRegion,
+			Storage:  cfg.ProxmoxStorage,
+		}))
+	}
+
+	// Addresses are allocated from pools persisted in the management cluster
+	subnets := nodeSubnets(cfg)
+	allocator := ipam.NewAllocator(clients.Kamaji)
+	if cfg.LoadBalancerCIDR != "" || len(subnets) > 0 {
+		options = append(options, agent.WithIPAM(allocator))
+	}
+	for _, subnet := range subnets {
+		var reserved []string
+		if subnet.Gateway != "" {
+			reserved = append(reserved, subnet.Gateway)
+		}
+		if err := allocator.AddPool(agent.SubnetPool(subnet.Name), subnet.CIDR, reserved...); err != nil {
+			log.Fatalf("Invalid subnet configuration: %v", err)
+		}
+	}
+	if len(subnets) > 0 {
+		options = append(options, agent.WithNodeSubnets(subnets...))
+	}
+	if cfg.NetworkBridge != "" || cfg.NetworkVNet != "" || cfg.NetworkFirewall {
+		options = append(options, agent.WithNetwork(agent.NetworkConfig{
+			Default: agent.Network{
+				Bridge: cfg.NetworkBridge,
+				VNet:   cfg.NetworkVNet,
+				VLAN:   cfg.NetworkVLAN,
+			},
+			Allowed:  splitList(cfg.NetworkAllowed),
+			Firewall: cfg.NetworkFirewall,
+		}))
+	}
+	if cfg.LoadBalancerCIDR != "" {
+		if err := allocator.AddPool(agent.LoadBalancerPool, cfg.LoadBalancerCIDR); err != nil {
+			log.Fatalf("Invalid load balancer configuration: %v", err)
+		}
+		options = append(options, agent.WithLoadBalancer(agent.LoadBalancerConfig{
+			Chart:     cfg.MetalLBChart,
+			Version:   cfg.MetalLBVersion,
+			RangeSize: cfg.LoadBalancerRangeSize,
+		}))
+	}
+
 	// Create and start the agent with all required clients
-	testAgent, err := agent.New(clients, cfg.ProxmoxTemplate, cfg.ProxmoxCIPath, cfg.JobPollInterval, cfg.MetricReportInterval)
+	testAgent, err := agent.New(clients, cfg.ProxmoxTemplate, cfg.ProxmoxCIPath, cfg.JobPollInterval, cfg.MetricReportInterval, options...)
 	if err != nil {
 		log.Fatalf("Failed to create agent: %v", err)
 	}
@@ -79,13 +256,79 @@ func main() {
 	log.Println("Agent shutdown succesfully.")
 }
 
+// nodeSubnets returns the configured node subnets, the default one first
+func nodeSubnets(cfg *config.Config) []agent.Subnet {
+	var subnets []agent.Subnet
+	if cfg.NodeSubnetCIDR != "" {
+		subnets = append(subnets, agent.Subnet{
+			Name:    "default",
+			CIDR:    cfg.NodeSubnetCIDR,
+			Gateway: cfg.NodeSubnetGateway,
+			DNS:     splitList(cfg.NodeSubnetDNS),
+		})
+	}
+	for _, s := range cfg.NodeSubnets {
+		subnets = append(subnets, agent.Subnet{
+			Name:    s.Name,
+			CIDR:    s.CIDR,
+			Gateway: s.Gateway,
+			DNS:     s.DNS,
+		})
+	}
+	return subnets
+}
+
+// nodeTemplates returns the registry of the configured node templates
+func nodeTemplates(cfg *config.Config) agent.TemplateRegistry {
+	registry := agent.TemplateRegistry{DefaultOS: cfg.NodeDefaultOS}
+	for _, t := range cfg.NodeTemplates {
+		registry.Templates = append(registry.Templates, agent.NodeTemplate{
+			OS:          t.OS,
+			KubeVersion: t.KubeVersion,
+			VMID:        t.VMID,
+			CloudInit:   t.CloudInit,
+		})
+	}
+	return registry
+}
+
+// cloudInitTemplates loads the named cloud-init templates and checks the ones selected by default
+func cloudInitTemplates(cfg *config.Config) (*agent.CloudInitConfig, error) {
+	cloudInitCfg := &agent.CloudInitConfig{
+		Default: cfg.CloudInitDefaultTemplate,
+		Sizes:   make(map[agent.NodeSize]string),
+	}
+	if cfg.CloudInitTemplatesPath != "" {
+		templates, err := cloudinit.LoadTemplates(cfg.CloudInitTemplatesPath)
+		if err != nil {
+			return nil, err
+		}
+		cloudInitCfg.Templates = templates
+	}
+	for size, name := range cfg.CloudInitSizeTemplates {
+		cloudInitCfg.Sizes[agent.NodeSize(size)] = name
+	}
+
+	return cloudInitCfg, cloudInitCfg.Validate()
+}
+
+// splitList splits a comma separated configuration value
+func splitList(value string) []string {
+	var items []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
 func initRealClients(cfg *config.Config) *agent.Clients {
 	// Fulcrum client for communicating with the Fulcrum Core API
 	fulcrumCli := fulcrum.NewFulcrumClient(cfg.FulcrumAPIURL, cfg.FulcrumAPIToken, httpcli.WithSkipTLSVerify(cfg.SkipTLSVerify))
 
 	// Proxmox client for VM management
-	proxmoxHttpClient := httpcli.NewHTTPClient(cfg.ProxmoxAPIURL, cfg.ProxmoxAPIToken, httpcli.WithSkipTLSVerify(cfg.SkipTLSVerify))
-	proxmoxCli := proxmox.NewProxmoxClient(cfg.ProxmoxHost, cfg.ProxmoxStorage, proxmoxHttpClient)
+	proxmoxCli := newProxmoxClient(cfg)
 
 	// Kamaji client for Kubernetes tenant control planes
 	kamajiCli, err := kamaji.NewClient(cfg.KubeAPIURL, cfg.KubeAPIToken)
@@ -93,23 +336,36 @@ func initRealClients(cfg *config.Config) *agent.Clients {
 		log.Fatalf("Failed to create Kamaji client: %v", err)
 	}
 
-	// SSH client for SCP operations (Cloud-Init templates)
-	sshOpts := ssh.Options{
-		Host:           cfg.ProxmoxCIHost,
-		Username:       cfg.ProxmoxCIUser,
-		PrivateKeyPath: cfg.ProxmoxCIPKPath,
-		Timeout:        30 * time.Second,
-	}
+	// SSH client for SCP operations (Cloud-Init templates), only needed by the ssh snippet store
+	var sshCli agent.SSHClient
+	if cfg.SnippetStore == config.SnippetStoreSSH {
+		sshOpts := ssh.Options{
+			Host:           cfg.ProxmoxCIHost,
+			Username:       cfg.ProxmoxCIUser,
+			PrivateKeyPath: cfg.ProxmoxCIPKPath,
+			Timeout:        30 * time.Second,
+		}
 
-	sshCli, err := ssh.NewClient(sshOpts)
-	if err != nil {
-		log.Fatalf("Failed to create SSH client: %v", err)
+		sshCli, err = ssh.NewClient(sshOpts)
+		if err != nil {
+			log.Fatalf("Failed to create SSH client: %v", err)
+		}
+	}
+	// Helm client for the charts installed into the tenant clusters
+	var helmCli agent.HelmClient
+	if cfg.HelmChartsPath != "" || cfg.HelmCachePath != "" {
+		helmCli = helm.NewClient(helm.Options{
+			ChartsPath: cfg.HelmChartsPath,
+			CachePath:  cfg.HelmCachePath,
+		})
 	}
+
 	return &agent.Clients{
 		Fulcrum: fulcrumCli,
 		Proxmox: proxmoxCli,
 		Kamaji:  kamajiCli,
 		SSH:     sshCli,
+		Helm:    helmCli,
 	}
 }
 
@@ -123,5 +379,12 @@ func initMockClients(cfg *config.Config) *agent.Clients {
 		Proxmox: agent.NewMockProxmoxClient("mock-node"),
 		Kamaji:  agent.NewMockKamajiClient(),
 		SSH:     agent.NewMockSSHClient(),
+		Helm:    agent.NewMockHelmClient(),
 	}
 }
+
+// newProxmoxClient creates the Proxmox API client
+func newProxmoxClient(cfg *config.Config) *proxmox.HTTPProxmoxClient {
+	proxmoxHttpClient := httpcli.NewHTTPClient(cfg.ProxmoxAPIURL, cfg.ProxmoxAPIToken, httpcli.WithSkipTLSVerify(cfg.SkipTLSVerify))
+	return proxmox.NewProxmoxClient(cfg.ProxmoxHost, cfg.ProxmoxStorage, proxmoxHttpClient)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+	"fulcrumproject.org/kube-agent/internal/config"
+)
+
+// buildTemplateCommand builds a node template from a cloud image instead of running the agent
+const buildTemplateCommand = "build-template"
+
+// buildTemplate builds a node template with the Proxmox connection of the configuration
+func buildTemplate(cfg *config.Config, args []string) {
+	bridge := cfg.NetworkBridge
+	if bridge == "" {
+		bridge = "vmbr0"
+	}
+	buildCfg := agent.TemplateBuildConfig{}
+	flags := flag.NewFlagSet(buildTemplateCommand, flag.ExitOnError)
+	flags.IntVar(&buildCfg.VMID, "vmid", 0, "VM ID of the template")
+	flags.StringVar(&buildCfg.Name, "name", "", "VM name of the template (default: derived from the OS and Kubernetes version)")
+	flags.StringVar(&buildCfg.OS, "os", agent.OSUbuntu, "OS of the cloud image: ubuntu, debian or flatcar")
+	flags.StringVar(&buildCfg.KubeVersion, "kube-version", agent.DefaultKubeVersion, "Kubernetes version the image is prepared for")
+	flags.StringVar(&buildCfg.Image, "image", "", "Volume of the cloud image, such as local:import/noble-server-cloudimg-amd64.qcow2")
+	flags.StringVar(&buildCfg.PublishedDigest, "digest", "", "Digest published with the cloud image, such as sha256:<hex>, recorded without being verified")
+	flags.StringVar(&buildCfg.Storage, "storage", cfg.ProxmoxStorage, "Storage of the template disks")
+	flags.StringVar(&buildCfg.Bridge, "bridge", bridge, "Bridge of the network device")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalf("Invalid arguments: %v", err)
+	}
+
+	if err := agent.BuildTemplate(newProxmoxClient(cfg), buildCfg); err != nil {
+		log.Fatalf("Failed to build template: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package addons
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// Catalog implements the agent.AddonCatalog interface on top of a file system
+// laid out as <addon name>/<version>.yaml, each file being a manifest template
+type Catalog struct {
+	fsys fs.FS
+}
+
+// TemplateParams contains the parameters available to the addon manifest templates
+type TemplateParams struct {
+	TenantName string
+	Name       string
+	Version    string
+	Values     map[string]any
+}
+
+// NewCatalog creates a new addon catalog reading the manifests from the given file system
+func NewCatalog(fsys fs.FS) *Catalog {
+	return &Catalog{fsys: fsys}
+}
+
+// NewDirCatalog creates a new addon catalog reading the manifests from the given directory
+func NewDirCatalog(dir string) *Catalog {
+	return NewCatalog(os.DirFS(dir))
+}
+
+// Versions returns the available versions of an addon
+func (c *Catalog) Versions(name string) ([]string, error) {
+	if !validSegment(name) {
+		return nil, fmt.Errorf("invalid addon name %q", name)
+	}
+
+	entries, err := fs.ReadDir(c.fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown addon %s: %w", name, err)
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		versions = append(versions, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(versions)
+
+	return versions, nil
+}
+
+// Render renders the manifest of an addon version for the given tenant
+func (c *Catalog) Render(addon agent.Addon, tenantName string) (string, error) {
+	if !validSegment(addon.Name) {
+		return "", fmt.Errorf("invalid addon name %q", addon.Name)
+	}
+	if !validSegment(addon.Version) {
+		return "", fmt.Errorf("invalid addon version %q", addon.Version)
+	}
+
+	content, err := fs.ReadFile(c.fsys, path.Join(addon.Name, addon.Version+".yaml"))
+	if err != nil {
+		return "", fmt.Errorf("addon %s version %s not found in catalog: %w", addon.Name, addon.Version, err)
+	}
+
+	tmpl, err := template.New(addon.Name).Funcs(funcs).Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse addon %s template: %w", addon.Name, err)
+	}
+
+	values := addon.Values
+	if values == nil {
+		values = make(map[string]any)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, TemplateParams{
+		TenantName: tenantName,
+		Name:       addon.Name,
+		Version:    addon.Version,
+		Values:     values,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render addon %s: %w", addon.Name, err)
+	}
+
+	return buf.String(), nil
+}
+
+// funcs are the functions available to the addon manifest templates
+var funcs = template.FuncMap{
+	"default": defaultValue,
+	"quote":   quote,
+}
+
+// quote returns a value as a YAML double quoted scalar, which is a JSON string, so that the values
+// declared by the services cannot break out of the manifest
+func quote(value any) string {
+	if value == nil {
+		value = ""
+	}
+	b, _ := json.Marshal(fmt.Sprint(value))
+	return string(b)
+}
+
+// defaultValue returns the value, or the default one if the value is not set
+func defaultValue(def any, value any) any {
+	if value == nil || value == "" {
+		return def
+	}
+	return value
+}
+
+// validSegment reports whether s can be used as a single catalog path element
+func validSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
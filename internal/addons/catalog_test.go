@@ -0,0 +1,48 @@
+package addons
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalog(t *testing.T) {
+	catalog := NewCatalog(fstest.MapFS{
+		"metrics-server/v0.7.1.yaml": {Data: []byte("version: {{.Version}}\ntenant: {{.TenantName}}\n")},
+		"metrics-server/v0.7.2.yaml": {Data: []byte("replicas: {{default 1 .Values.replicas}}\n")},
+		"metrics-server/v0.7.3.yaml": {Data: []byte("args: [{{quote .Values.arg}}]\n")},
+		"metrics-server/README.md":   {Data: []byte("not a manifest")},
+	})
+
+	versions, err := catalog.Versions("metrics-server")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v0.7.1", "v0.7.2", "v0.7.3"}, versions)
+
+	manifest, err := catalog.Render(agent.Addon{Name: "metrics-server", Version: "v0.7.1"}, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, "version: v0.7.1\ntenant: tenant-a\n", manifest)
+
+	manifest, err = catalog.Render(agent.Addon{Name: "metrics-server", Version: "v0.7.2"}, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, "replicas: 1\n", manifest)
+
+	manifest, err = catalog.Render(agent.Addon{Name: "metrics-server", Version: "v0.7.2", Values: map[string]any{"replicas": 3}}, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, "replicas: 3\n", manifest)
+
+	// Quoted values are rendered as a single scalar
+	manifest, err = catalog.Render(agent.Addon{Name: "metrics-server", Version: "v0.7.3", Values: map[string]any{"arg": "a]\nkind: Secret"}}, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, "args: [\"a]\\nkind: Secret\"]\n", manifest)
+	manifest, err = catalog.Render(agent.Addon{Name: "metrics-server", Version: "v0.7.3", Values: map[string]any{"arg": 3}}, "tenant-a")
+	require.NoError(t, err)
+	require.Equal(t, "args: [\"3\"]\n", manifest)
+
+	_, err = catalog.Render(agent.Addon{Name: "metrics-server", Version: "v9.9.9"}, "tenant-a")
+	require.Error(t, err)
+
+	_, err = catalog.Render(agent.Addon{Name: "../etc", Version: "passwd"}, "tenant-a")
+	require.Error(t, err)
+}
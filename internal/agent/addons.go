@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Addon represents a component installed into a tenant cluster
+type Addon struct {
+	Name    string         `json:"name"`
+	Version string         `json:"version"`
+	Values  map[string]any `json:"values,omitempty"`
+}
+
+// InstalledAddon represents an addon installed into a tenant cluster
+type InstalledAddon struct {
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"` // Checksum of the rendered manifest
+}
+
+// AddonCatalog defines the interface for the catalog of installable addons
+type AddonCatalog interface {
+	// Render renders the manifest of an addon version for the given tenant
+	Render(addon Addon, tenantName string) (string, error)
+}
+
+// addonManifestName returns the manifest name under which an addon is applied
+func addonManifestName(name string) string {
+	return fmt.Sprintf("addon-%s", name)
+}
+
+// validateAddons checks that all the addons can be rendered before any resource is created
+func (h *JobHandler) validateAddons(addons []Addon, tenantName string) error {
+	if len(addons) == 0 {
+		return nil
+	}
+	if h.addonCatalog == nil {
+		return fmt.Errorf("addons are not enabled on this agent")
+	}
+
+	seen := make(map[string]bool, len(addons))
+	for _, addon := range addons {
+		if seen[addon.Name] {
+			return fmt.Errorf("addon %s is declared more than once", addon.Name)
+		}
+		seen[addon.Name] = true
+
+		if _, err := h.addonCatalog.Render(addon, tenantName); err != nil {
+			return fmt.Errorf("invalid addon %s: %w", addon.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// syncAddons installs, upgrades and removes the tenant addons to match the target ones
+func (h *JobHandler) syncAddons(ctx context.Context, tenantClient KamajiTenantClient, tenantName string, addons []Addon) error {
+	if err := h.validateAddons(addons, tenantName); err != nil {
+		return err
+	}
+
+	installed, err := tenantClient.GetAddons(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get installed addons: %w", err)
+	}
+	if installed == nil {
+		installed = make(map[string]InstalledAddon)
+	}
+
+	// Install or upgrade the target addons
+	target := make(map[string]bool, len(addons))
+	for _, addon := range addons {
+		target[addon.Name] = true
+
+		manifest, err := h.addonCatalog.Render(addon, tenantName)
+		if err != nil {
+			return fmt.Errorf("failed to render addon %s: %w", addon.Name, err)
+		}
+		sum := sha256.Sum256([]byte(manifest))
+		checksum := hex.EncodeToString(sum[:])
+
+		if curr, ok := installed[addon.Name]; ok && curr.Version == addon.Version && curr.Checksum == checksum {
+			continue
+		}
+
+		log.Printf("Applying addon %s version %s to tenant %s", addon.Name, addon.Version, tenantName)
+		if err := tenantClient.ApplyManifest(ctx, addonManifestName(addon.Name), manifest); err != nil {
+			return fmt.Errorf("failed to apply addon %s: %w", addon.Name, err)
+		}
+
+		installed[addon.Name] = InstalledAddon{Version: addon.Version, Checksum: checksum}
+		if err := tenantClient.SetAddons(ctx, installed); err != nil {
+			return fmt.Errorf("failed to record addon %s: %w", addon.Name, err)
+		}
+	}
+
+	// Remove the addons not targeted anymore
+	var toRemove []string
+	for name := range installed {
+		if !target[name] {
+			toRemove = append(toRemove, name)
+		}
+	}
+	sort.Strings(toRemove)
+	for _, name := range toRemove {
+		log.Printf("Removing addon %s from tenant %s", name, tenantName)
+		if err := tenantClient.DeleteManifest(ctx, addonManifestName(name)); err != nil {
+			return fmt.Errorf("failed to remove addon %s: %w", name, err)
+		}
+
+		delete(installed, name)
+		if err := tenantClient.SetAddons(ctx, installed); err != nil {
+			return fmt.Errorf("failed to record addon %s removal: %w", name, err)
+		}
+	}
+
+	return nil
+}
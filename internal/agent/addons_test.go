@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testAddonCatalog renders every addon version listed in it as a fake manifest
+type testAddonCatalog map[string][]string
+
+func (c testAddonCatalog) Render(addon Addon, tenantName string) (string, error) {
+	for _, version := range c[addon.Name] {
+		if version == addon.Version {
+			return fmt.Sprintf("# %s %s %s %v", tenantName, addon.Name, addon.Version, addon.Values), nil
+		}
+	}
+	return "", fmt.Errorf("addon %s version %s not found", addon.Name, addon.Version)
+}
+
+func TestJobHandlerAddons(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	catalog := testAddonCatalog{
+		"metrics-server": {"v0.7.1", "v0.7.2"},
+		"cert-manager":   {"v1.15.0"},
+	}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(), WithAddonCatalog(catalog))
+
+	serviceID := "addons-service"
+	serviceName := "addons-cluster"
+	nodes := []Node{{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}}
+
+	t.Run("Create with unknown addon fails before provisioning", func(t *testing.T) {
+		props := &Properties{Nodes: nodes, Addons: []Addon{{Name: "ingress-nginx", Version: "v1.0.0"}}}
+		require.NoError(t, fulcrumCli.CreateService("invalid-service", "invalid-cluster", nil, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+
+		require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+		_, exists := kamajiCli.GetTenantControlPlane("invalid-cluster")
+		require.False(t, exists)
+	})
+
+	t.Run("Create, upgrade and remove addons", func(t *testing.T) {
+		props := &Properties{Nodes: nodes, Addons: []Addon{
+			{Name: "metrics-server", Version: "v0.7.1"},
+			{Name: "cert-manager", Version: "v1.15.0"},
+		}}
+		require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		tcp, exists := kamajiCli.GetTenantControlPlane(serviceName)
+		require.True(t, exists)
+		require.Equal(t, "v0.7.1", tcp.Addons["metrics-server"].Version)
+		require.Equal(t, "v1.15.0", tcp.Addons["cert-manager"].Version)
+		require.Contains(t, tcp.Manifests, "addon-metrics-server")
+		require.Contains(t, tcp.Manifests, "addon-cert-manager")
+
+		// Start the service so that the update is a hot one
+		require.NoError(t, fulcrumCli.StartService(serviceID))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		// Upgrade metrics-server and remove cert-manager
+		props = &Properties{Nodes: nodes, Addons: []Addon{
+			{Name: "metrics-server", Version: "v0.7.2", Values: map[string]any{"replicas": 2}},
+		}}
+		require.NoError(t, fulcrumCli.UpdateService(serviceID, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		require.Empty(t, fulcrumCli.PullFailedJobs())
+
+		require.Equal(t, "v0.7.2", tcp.Addons["metrics-server"].Version)
+		require.Contains(t, tcp.Manifests["addon-metrics-server"], "replicas:2")
+		require.NotContains(t, tcp.Addons, "cert-manager")
+		require.NotContains(t, tcp.Manifests, "addon-cert-manager")
+	})
+}
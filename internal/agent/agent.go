@@ -13,6 +13,7 @@ type Clients struct {
 	Proxmox ProxmoxClient
 	Kamaji  KamajiClient
 	SSH     SSHClient
+	Helm    HelmClient // Optional, Helm charts are disabled if nil
 }
 
 func (c *Clients) Close() {
@@ -36,7 +37,10 @@ type Agent struct {
 }
 
 // New creates a new agent
-func New(cli *Clients, templateID int, ciPath string, pollInterval, metricInterval time.Duration) (*Agent, error) {
+func New(cli *Clients, templateID int, ciPath string, pollInterval, metricInterval time.Duration, options ...JobHandlerOption) (*Agent, error) {
+	if cli.Helm != nil {
+		options = append([]JobHandlerOption{WithHelmClient(cli.Helm)}, options...)
+	}
 	jobHandler := NewJobHandler(
 		cli.Fulcrum,
 		cli.Proxmox,
@@ -44,11 +48,13 @@ func New(cli *Clients, templateID int, ciPath string, pollInterval, metricInterv
 		ciPath,
 		cli.Kamaji,
 		cli.SSH,
+		options...,
 	)
 	metricsReporter := NewMetricsReporter(
 		cli.Fulcrum,
 		cli.Proxmox,
 	)
+	metricsReporter.quotas = jobHandler
 
 	return &Agent{
 		fulcrumCli:      cli.Fulcrum,
@@ -100,6 +106,12 @@ func (a *Agent) Start(ctx context.Context) error {
 	a.wg.Add(1)
 	go a.pollJobs(ctx)
 
+	// Start VM pool refilling background task
+	if a.jobHandler.PoolRefillInterval() > 0 {
+		a.wg.Add(1)
+		go a.refillPool(ctx)
+	}
+
 	return nil
 }
 
@@ -169,6 +181,27 @@ func (a *Agent) pollJobs(ctx context.Context) {
 	}
 }
 
+// refillPool refills the VM pool when started and then periodically
+func (a *Agent) refillPool(ctx context.Context) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.jobHandler.PoolRefillInterval())
+	defer ticker.Stop()
+
+	for {
+		if err := a.jobHandler.RefillPool(); err != nil {
+			log.Printf("Error refilling VM pool: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-a.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // Shutdown stops the agent and releases resources
 func (a *Agent) Shutdown(ctx context.Context) error {
 	// Close the stop channel to signal all goroutines to stop
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// DefaultPackageRepository is the base URL of the Kubernetes deb repositories, one per minor version
+const DefaultPackageRepository = "https://pkgs.k8s.io/core:/stable:"
+
+// DefaultBootstrapTokenTTL is the validity of the bootstrap tokens of the nodes
+const DefaultBootstrapTokenTTL = 2 * time.Hour
+
+// joinTimeout is how long a started node has to join its cluster
+const joinTimeout = 10 * time.Minute
+
+// BootstrapConfig holds the configuration of the kubeadm join of the worker nodes
+type BootstrapConfig struct {
+	PackageRepository string        // Base URL of the Kubernetes deb repositories, such as a local mirror of pkgs.k8s.io
+	RegistryMirror    string        // Registry the container images are pulled through, such as a local pull-through cache
+	TokenTTL          time.Duration // Validity of the bootstrap token of each node
+}
+
+// BootstrapToken is the bootstrap token of a node that has not joined yet
+type BootstrapToken struct {
+	ID         string    `json:"id"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// WithBootstrap returns an option that configures the sources the worker nodes install Kubernetes from.
+// With a local package mirror and registry mirror the nodes join without internet access.
+func WithBootstrap(cfg BootstrapConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.PackageRepository == "" {
+			cfg.PackageRepository = DefaultPackageRepository
+		}
+		if cfg.TokenTTL <= 0 {
+			cfg.TokenTTL = DefaultBootstrapTokenTTL
+		}
+		h.bootstrap = cfg
+	}
+}
+
+// packageSource returns the deb repository of the Kubernetes minor version and the package version to pin
+func (h *JobHandler) packageSource(kubeVersion string) (string, string, error) {
+	v, err := version.ParseSemantic(kubeVersion)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid Kubernetes version %s: %w", kubeVersion, err)
+	}
+
+	repository := fmt.Sprintf("%s/v%d.%d/deb/", strings.TrimSuffix(h.bootstrap.PackageRepository, "/"), v.Major(), v.Minor())
+	return repository, fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch()), nil
+}
+
+// joinEndpoint returns the 'host:port' API server endpoint kubeadm discovers the cluster from
+func joinEndpoint(endpoint string) string {
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// issueJoinToken creates a bootstrap token dedicated to a node, which is revoked once the node joined
+func (h *JobHandler) issueJoinToken(ctx context.Context, tenantClient KamajiTenantClient, serviceName, nodeID string) (*JoinTokenResponse, error) {
+	description := fmt.Sprintf("Bootstrap token of node %s", vmName(serviceName, nodeID))
+	token, err := tenantClient.CreateJoinToken(ctx, description, h.bootstrap.TokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create join token: %w", err)
+	}
+	return token, nil
+}
+
+// revokeJoinToken deletes the bootstrap token of a node, if it still has one
+func (h *JobHandler) revokeJoinToken(ctx context.Context, tenantClient KamajiTenantClient, resources *Resources, nodeID string) error {
+	token, ok := resources.BootstrapTokens[nodeID]
+	if !ok {
+		return nil
+	}
+	if err := tenantClient.DeleteJoinToken(ctx, token.ID); err != nil {
+		return fmt.Errorf("failed to revoke join token: %w", err)
+	}
+	delete(resources.BootstrapTokens, nodeID)
+	return nil
+}
+
+// renewJoinToken issues a new bootstrap token to a node that has not joined yet when its token would expire
+// before the node could join, such as a node created stopped and started later, and injects it into its
+// cloud-init configuration
+func (h *JobHandler) renewJoinToken(ctx context.Context, props *Properties, resources *Resources, vmID int, serviceName string, node Node) error {
+	current, ok := resources.BootstrapTokens[node.ID]
+	if !ok || time.Until(current.Expiration) > joinTimeout {
+		return nil
+	}
+
+	tenantClient, err := h.kamajiCli.GetTenantClient(ctx, serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant client: %w", err)
+	}
+	access, err := h.prepareNodeAccess(ctx, serviceName, props, resources)
+	if err != nil {
+		return err
+	}
+	addr, err := h.lookupNodeAddress(props.Subnet, resources.NodeIPs[node.ID])
+	if err != nil {
+		return err
+	}
+
+	token, err := h.issueJoinToken(ctx, tenantClient, serviceName, node.ID)
+	if err != nil {
+		return err
+	}
+	seed, err := h.nodeSeed(ctx, props, access, serviceName, node, addr, token.FullToken)
+	if err != nil {
+		h.deleteJoinToken(ctx, tenantClient, token.TokenID)
+		return err
+	}
+	cores, memory := node.Size.Attrs()
+	drive, err := h.uploadCloudInit(vmID, vmName(serviceName, node.ID), seed, cores, memory)
+	if err != nil {
+		h.deleteJoinToken(ctx, tenantClient, token.TokenID)
+		return err
+	}
+	resources.setSnippet(node.ID, drive)
+
+	// The previous token is usually already deleted by the token cleaner of the cluster
+	h.deleteJoinToken(ctx, tenantClient, current.ID)
+	resources.setBootstrapToken(node.ID, token)
+
+	return nil
+}
+
+// deleteJoinToken deletes a bootstrap token that is not used anymore
+func (h *JobHandler) deleteJoinToken(ctx context.Context, tenantClient KamajiTenantClient, tokenID string) {
+	if err := tenantClient.DeleteJoinToken(ctx, tokenID); err != nil {
+		log.Printf("Failed to delete join token %s: %v", tokenID, err)
+	}
+}
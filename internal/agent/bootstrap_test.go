@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerBootstrap(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	sshCli := NewMockSSHClient()
+	cfg := BootstrapConfig{PackageRepository: "https://mirror.example.com/kubernetes/", RegistryMirror: "https://registry.example.com"}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), sshCli, WithBootstrap(cfg))
+
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}
+	require.NoError(t, fulcrumCli.CreateService("join-service", "join-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	// The node joins from the mirrors with a self-contained kubeadm configuration
+	content, ok := sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName("join-cluster", "node1")))
+	require.True(t, ok)
+	require.Contains(t, content, `apiServerEndpoint: "join-cluster.example.com:6443"`)
+	require.Contains(t, content, `- "sha256:test-ca-hash-for-join-cluster"`)
+	require.Contains(t, content, "https://mirror.example.com/kubernetes/v1.30/deb/ /")
+	require.Contains(t, content, "Pin: version 1.30.2-*")
+	require.Contains(t, content, `[host."https://registry.example.com"]`)
+	require.NotContains(t, content, "goyaki")
+}
+
+func TestJobHandlerBootstrapTokens(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	sshCli := NewMockSSHClient()
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, sshCli, WithBootstrap(BootstrapConfig{TokenTTL: time.Hour}))
+
+	serviceID := "token-service"
+	serviceName := "token-cluster"
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	// Each node gets its own short-lived token
+	tcp, ok := kamajiCli.GetTenantControlPlane(serviceName)
+	require.True(t, ok)
+	require.Len(t, tcp.JoinTokens, 1)
+	token := tcp.JoinTokens["token1"]
+	require.Equal(t, "Bootstrap token of node "+vmName(serviceName, "node1"), token.Description)
+	require.WithinDuration(t, time.Now().Add(time.Hour), token.ExpirationTime, time.Minute)
+	content, ok := sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName(serviceName, "node1")))
+	require.True(t, ok)
+	require.Contains(t, content, "token1.test-token-secret")
+
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, "token1", service.Resources.BootstrapTokens["node1"].ID)
+
+	// The token is revoked once the node joined
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Empty(t, tcp.JoinTokens)
+	service, err = fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Empty(t, service.Resources.BootstrapTokens)
+
+	// A node started after its token expired gets a new one, injected into its cloud-init configuration
+	jobHandler = NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, sshCli, WithBootstrap(BootstrapConfig{TokenTTL: time.Minute}))
+	require.NoError(t, fulcrumCli.CreateService("expired-service", "expired-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	tcp, ok = kamajiCli.GetTenantControlPlane("expired-cluster")
+	require.True(t, ok)
+	require.Contains(t, tcp.JoinTokens, "token1")
+
+	require.NoError(t, fulcrumCli.StartService("expired-service"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Equal(t, 2, tcp.tokenCount)
+	require.Empty(t, tcp.JoinTokens)
+}
+
+func TestPackageSource(t *testing.T) {
+	h := NewJobHandler(nil, nil, 100, "path", nil, nil)
+
+	repository, packageVersion, err := h.packageSource("v1.31.4")
+	require.NoError(t, err)
+	require.Equal(t, "https://pkgs.k8s.io/core:/stable:/v1.31/deb/", repository)
+	require.Equal(t, "1.31.4", packageVersion)
+
+	_, _, err = h.packageSource("latest")
+	require.Error(t, err)
+}
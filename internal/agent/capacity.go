@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// CapacityConfig holds the configuration of the capacity check of the jobs adding nodes
+type CapacityConfig struct {
+	Storage    string  // Storage the VM disks are cloned to
+	Overcommit float64 // Ratio of the vCPUs and memory of the VMs to the ones of the hosts, 1 if not set
+}
+
+// WithCapacityCheck returns an option that checks the hosts have the CPU, memory and storage required by the
+// nodes to add before starting the jobs, so that they do not fail halfway.
+func WithCapacityCheck(cfg CapacityConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.Overcommit <= 0 {
+			cfg.Overcommit = 1
+		}
+		h.capacity = &cfg
+	}
+}
+
+// checkCapacity fails if the hosts the nodes can be placed on lack the CPU, memory or storage they require.
+// Every node requires the CPU and memory of its size and the disk size of the template of the service,
+// on a single host: the nodes are packed on the hosts, the largest first.
+func (h *JobHandler) checkCapacity(props *Properties, nodes []Node) error {
+	if h.capacity == nil || len(nodes) == 0 {
+		return nil
+	}
+
+	nodeTemplate, err := h.nodeTemplate(props)
+	if err != nil {
+		return err
+	}
+	template, err := h.proxmoxCli.GetVMInfo(nodeTemplate.VMID)
+	if err != nil {
+		return fmt.Errorf("failed to get template info: %w", err)
+	}
+	hosts, err := h.capacityHosts(props, template.NodeName)
+	if err != nil {
+		return err
+	}
+
+	var cores, memory int
+	for _, node := range nodes {
+		c, m := node.Size.Attrs()
+		cores += c
+		memory += m
+	}
+	disk := int64(len(nodes)) * template.MaxDisk
+
+	// The VMs allocated beyond the capacity of a host do not leave room on the others
+	free := make([]hostCapacity, len(hosts))
+	var freeCores, freeMemory int64
+	for i, host := range hosts {
+		free[i].cores, free[i].memory = h.hostFree(host)
+		freeCores += free[i].cores
+		freeMemory += free[i].memory
+	}
+	if int64(cores) > freeCores {
+		return fmt.Errorf("insufficient capacity: the nodes require %d vCPUs, %d are available", cores, freeCores)
+	}
+	if int64(memory) > freeMemory {
+		return fmt.Errorf("insufficient capacity: the nodes require %d MB of memory, %d MB are available", memory, freeMemory)
+	}
+
+	// The disks of a local storage must fit the host of their node, a shared storage is checked once
+	var freeDisk int64
+	shared := false
+	for i, host := range hosts {
+		status, err := h.proxmoxCli.GetStorageStatus(host.Name, h.capacity.Storage)
+		if err != nil {
+			return fmt.Errorf("failed to get storage %s status: %w", h.capacity.Storage, err)
+		}
+		if status.Shared {
+			freeDisk = status.Available
+			shared = true
+			break
+		}
+		free[i].disk = status.Available
+		freeDisk += status.Available
+	}
+	if disk > freeDisk {
+		return fmt.Errorf("insufficient capacity: the nodes require %d GB of storage %s, %d GB are available",
+			disk>>30, h.capacity.Storage, freeDisk>>30)
+	}
+
+	// A node cannot span hosts, so the totals are not enough: each node takes its share of a host
+	sorted := slices.Clone(nodes)
+	slices.SortStableFunc(sorted, func(a, b Node) int {
+		ca, ma := a.Size.Attrs()
+		cb, mb := b.Size.Attrs()
+		return cmp.Or(cmp.Compare(mb, ma), cmp.Compare(cb, ca))
+	})
+	for _, node := range sorted {
+		c, m := node.Size.Attrs()
+		i := slices.IndexFunc(free, func(f hostCapacity) bool {
+			return f.cores >= int64(c) && f.memory >= int64(m) && (shared || f.disk >= template.MaxDisk)
+		})
+		if i < 0 {
+			return fmt.Errorf("insufficient capacity: no host has the %d vCPUs and %d MB of memory of node %s available", c, m, node.ID)
+		}
+		free[i].cores -= int64(c)
+		free[i].memory -= int64(m)
+		free[i].disk -= template.MaxDisk
+	}
+
+	return nil
+}
+
+// hostCapacity is what is left on a host, the memory in MB and the disk in bytes
+type hostCapacity struct {
+	cores  int64
+	memory int64
+	disk   int64
+}
+
+// hostFree returns the vCPUs and the memory in MB left on a host with the overcommit ratio
+func (h *JobHandler) hostFree(host HostStatus) (int64, int64) {
+	cores := int64(float64(host.MaxCPU)*h.capacity.Overcommit) - int64(host.AllocatedCPU)
+	memory := int64(float64(host.MaxMemory)*h.capacity.Overcommit) - host.AllocatedMemory
+	return max(0, cores), max(0, memory>>20)
+}
+
+// hostsWithCapacity filters out the hosts lacking the CPU or memory of a node, counting the nodes of the
+// service placed on them whose VM is not created yet
+func (h *JobHandler) hostsWithCapacity(hosts []HostStatus, props *Properties, resources *Resources, node Node) []HostStatus {
+	if h.capacity == nil {
+		return hosts
+	}
+
+	sizes := make(map[string]NodeSize)
+	for _, n := range props.Nodes {
+		sizes[n.ID] = n.Size
+	}
+	cores, memory := node.Size.Attrs()
+	var fitting []HostStatus
+	for _, host := range hosts {
+		freeCores, freeMemory := h.hostFree(host)
+		for nodeID, placed := range resources.Hosts {
+			if _, created := resources.Nodes[nodeID]; placed != host.Name || created || nodeID == node.ID {
+				continue
+			}
+			c, m := sizes[nodeID].Attrs()
+			freeCores -= int64(c)
+			freeMemory -= int64(m)
+		}
+		if int64(cores) <= freeCores && int64(memory) <= freeMemory {
+			fitting = append(fitting, host)
+		}
+	}
+	return fitting
+}
+
+// capacityHosts returns the hosts the nodes of a service can be placed on, the template one without placement
+func (h *JobHandler) capacityHosts(props *Properties, templateHost string) ([]HostStatus, error) {
+	if h.placement != nil {
+		return h.placementCandidates(props)
+	}
+
+	hosts, err := h.proxmoxCli.GetHosts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %w", err)
+	}
+	for _, host := range hosts {
+		if host.Name == templateHost {
+			return []HostStatus{host}, nil
+		}
+	}
+	return nil, fmt.Errorf("template host %s not found", templateHost)
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerCapacityCheck(t *testing.T) {
+	newHandler := func(overcommit float64) (*MockFulcrumClient, *MockProxmoxClient, *MockKamajiClient, *JobHandler) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("test-node")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		kamajiCli := NewMockKamajiClient()
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(),
+			WithCapacityCheck(CapacityConfig{Storage: "local-lvm", Overcommit: overcommit}))
+		return fulcrumCli, proxmoxCli, kamajiCli, jobHandler
+	}
+	nodes := []Node{
+		{ID: "node1", Size: NodeSizeS4, Status: NodeStatusOff},
+		{ID: "node2", Size: NodeSizeS4, Status: NodeStatusOff},
+	}
+
+	t.Run("insufficient CPU", func(t *testing.T) {
+		fulcrumCli, proxmoxCli, kamajiCli, jobHandler := newHandler(1)
+
+		// The 16 vCPUs of the host less the 2 of the template do not fit two s4 nodes
+		require.NoError(t, fulcrumCli.CreateService("cpu-service", "cpu-cluster", nil, &Properties{Nodes: nodes}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "insufficient capacity: the nodes require 16 vCPUs, 14 are available")
+
+		// Nothing is created
+		_, exists := kamajiCli.GetTenantControlPlane("cpu-cluster")
+		require.False(t, exists)
+		require.Len(t, proxmoxCli.vms, 1)
+	})
+
+	t.Run("overcommit", func(t *testing.T) {
+		fulcrumCli, _, _, jobHandler := newHandler(2)
+
+		require.NoError(t, fulcrumCli.CreateService("overcommit-service", "overcommit-cluster", nil, &Properties{Nodes: nodes}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	})
+
+	t.Run("insufficient memory", func(t *testing.T) {
+		fulcrumCli, proxmoxCli, _, jobHandler := newHandler(1)
+		proxmoxCli.SetHost(HostStatus{Name: "test-node", Online: true, MaxCPU: 32, MaxMemory: 16 << 30})
+
+		// The 16384 MB of the host less the 2048 MB of the template do not fit two s4 nodes
+		require.NoError(t, fulcrumCli.CreateService("memory-service", "memory-cluster", nil, &Properties{Nodes: nodes[:1]}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		require.NoError(t, fulcrumCli.StartService("memory-service"))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		require.NoError(t, fulcrumCli.UpdateService("memory-service", &Properties{Nodes: nodes}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "insufficient capacity: the nodes require 8192 MB of memory, 6144 MB are available")
+		require.Len(t, proxmoxCli.vms, 2)
+	})
+
+	t.Run("insufficient storage", func(t *testing.T) {
+		fulcrumCli, proxmoxCli, _, jobHandler := newHandler(1)
+		proxmoxCli.SetStorageStatus("local-lvm", StorageStatus{Total: 100 << 30, Used: 85 << 30, Available: 15 << 30})
+
+		// Each node requires the 10 GB disk of the template
+		small := []Node{{ID: "node1", Size: NodeSizeS1}, {ID: "node2", Size: NodeSizeS1}}
+		require.NoError(t, fulcrumCli.CreateService("storage-service", "storage-cluster", nil, &Properties{Nodes: small}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "insufficient capacity: the nodes require 20 GB of storage local-lvm, 15 GB are available")
+	})
+
+	t.Run("per host", func(t *testing.T) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("test-node")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		proxmoxCli.SetHost(HostStatus{Name: "pve2", Online: true, MaxCPU: 6, MaxMemory: 64 << 30})
+		policy, err := NewPlacementPolicy(PlacementLeastLoaded)
+		require.NoError(t, err)
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(),
+			WithCapacityCheck(CapacityConfig{Storage: "local-lvm"}), WithPlacement(PlacementConfig{Policy: policy}))
+
+		// The 14 vCPUs of the first host and the 6 of the second add up to the 16 of two s4 nodes, which
+		// still do not fit
+		require.NoError(t, fulcrumCli.CreateService("pack-service", "pack-cluster", nil, &Properties{Nodes: nodes}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "insufficient capacity: no host has the 8 vCPUs and 8192 MB of memory of node node2 available")
+		require.Len(t, proxmoxCli.vms, 1)
+
+		// The least loaded host is skipped when it lacks the capacity of the node
+		proxmoxCli.SetHost(HostStatus{Name: "test-node", Online: true, CPU: 0.9, MaxCPU: 16, MaxMemory: 64 << 30})
+		require.NoError(t, fulcrumCli.CreateService("placed-service", "placed-cluster", nil, &Properties{Nodes: nodes[:1]}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		service, err := fulcrumCli.GetService("placed-service")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"node1": "test-node"}, service.Resources.Hosts)
+	})
+}
@@ -15,6 +15,7 @@ type MockFulcrumClient struct {
 	jobMap        map[string]int
 	service       map[string]Service
 	serviceExtIDs map[string]string
+	metrics       []MetricEntry
 }
 
 // NewMockFulcrumClient creates a new in-memory stub Fulcrum client
@@ -219,10 +220,23 @@ func (c *MockFulcrumClient) FailJob(jobID string, errorMessage string) error {
 
 // ReportMetric reports a metric
 func (c *MockFulcrumClient) ReportMetric(metric *MetricEntry) error {
-	fmt.Printf("Metric %s %s %s=%v", metric.ExternalID, metric.ResourceID, metric.TypeName, metric.Value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.metrics = append(c.metrics, *metric)
 	return nil
 }
 
+// PullMetrics returns the reported metrics and removes them
+func (c *MockFulcrumClient) PullMetrics() []MetricEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := c.metrics
+	c.metrics = nil
+	return metrics
+}
+
 // CreateService creates a new service with the given parameters
 func (c *MockFulcrumClient) CreateService(id, name string, externalID *string, targetProperties *Properties) error {
 	c.mu.Lock()
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MockHelmClient implements HelmClient interface for testing
+type MockHelmClient struct {
+	// Map of kubeconfigs to the releases installed in their cluster
+	releases map[string]map[string]HelmRelease
+	mu       sync.RWMutex
+}
+
+// NewMockHelmClient creates a new in-memory stub Helm client
+func NewMockHelmClient() *MockHelmClient {
+	return &MockHelmClient{
+		releases: make(map[string]map[string]HelmRelease),
+	}
+}
+
+// InstallRelease installs or upgrades a release
+func (c *MockHelmClient) InstallRelease(ctx context.Context, kubeConfig string, release HelmRelease) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.releases[kubeConfig]; !exists {
+		c.releases[kubeConfig] = make(map[string]HelmRelease)
+	}
+	c.releases[kubeConfig][release.Namespace+"/"+release.Name] = release
+	return nil
+}
+
+// UninstallRelease uninstalls a release
+func (c *MockHelmClient) UninstallRelease(ctx context.Context, kubeConfig string, name, namespace string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := namespace + "/" + name
+	if _, exists := c.releases[kubeConfig][key]; !exists {
+		return fmt.Errorf("release %s not found", key)
+	}
+	delete(c.releases[kubeConfig], key)
+	return nil
+}
+
+// ListReleases lists the installed releases
+func (c *MockHelmClient) ListReleases(ctx context.Context, kubeConfig string) ([]HelmRelease, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var releases []HelmRelease
+	for _, release := range c.releases[kubeConfig] {
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Namespace+"/"+releases[i].Name < releases[j].Namespace+"/"+releases[j].Name
+	})
+	return releases, nil
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MockIPAM implements IPAM interface for testing, allocating consecutive addresses of 10.0.0.0/16
+type MockIPAM struct {
+	allocations map[string]map[string]*IPRange
+	next        map[string]int
+	mu          sync.Mutex
+}
+
+// NewMockIPAM creates a new in-memory stub IPAM
+func NewMockIPAM() *MockIPAM {
+	return &MockIPAM{
+		allocations: make(map[string]map[string]*IPRange),
+		next:        make(map[string]int),
+	}
+}
+
+// Allocate allocates the next size addresses of the pool
+func (m *MockIPAM) Allocate(ctx context.Context, pool string, owner string, size int) (*IPRange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid range size %d", size)
+	}
+	if _, exists := m.allocations[pool]; !exists {
+		m.allocations[pool] = make(map[string]*IPRange)
+		m.next[pool] = 1
+	}
+	if r, exists := m.allocations[pool][owner]; exists {
+		return r, nil
+	}
+
+	first := m.next[pool]
+	m.next[pool] += size
+	r := &IPRange{
+		First: fmt.Sprintf("10.0.%d.%d", first/256, first%256),
+		Last:  fmt.Sprintf("10.0.%d.%d", (first+size-1)/256, (first+size-1)%256),
+	}
+	m.allocations[pool][owner] = r
+	return r, nil
+}
+
+// Release releases the allocation of the owner
+func (m *MockIPAM) Release(ctx context.Context, pool string, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.allocations[pool], owner)
+	return nil
+}
+
+// GetAllocation retrieves the allocation of an owner from the stub status
+func (m *MockIPAM) GetAllocation(pool string, owner string) (*IPRange, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, exists := m.allocations[pool][owner]
+	return r, exists
+}
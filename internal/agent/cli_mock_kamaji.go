@@ -17,12 +17,27 @@ type MockTenantControlPlane struct {
 	CAHash       string
 	KubeConfig   string
 	CreationTime time.Time
+	Manifests    map[string]string
+	Addons       map[string]InstalledAddon
+	NodeLabels   map[string]map[string]string
+	Volumes      map[string]string // Volume handle to CSI driver
+	JoinTokens   map[string]MockJoinToken
+	DeletedNodes []string // Names of the worker nodes deleted from the tenant cluster
+	tokenCount   int
 	mu           sync.RWMutex
 }
 
+// MockJoinToken represents a bootstrap token in the in-memory stub
+type MockJoinToken struct {
+	Description    string
+	ExpirationTime time.Time
+}
+
 // MockKamajiClient implements KamajiClient interface for testing
 type MockKamajiClient struct {
 	tenantControlPlanes map[string]*MockTenantControlPlane
+	ipAllocations       map[string]map[string]string
+	nodeCredentials     map[string]NodeCredentials
 	mu                  sync.RWMutex
 }
 
@@ -30,6 +45,8 @@ type MockKamajiClient struct {
 func NewMockKamajiClient() *MockKamajiClient {
 	return &MockKamajiClient{
 		tenantControlPlanes: make(map[string]*MockTenantControlPlane),
+		ipAllocations:       make(map[string]map[string]string),
+		nodeCredentials:     make(map[string]NodeCredentials),
 	}
 }
 
@@ -51,6 +68,11 @@ func (c *MockKamajiClient) CreateTenantControlPlane(ctx context.Context, name st
 		CAHash:       fmt.Sprintf("sha256:test-ca-hash-for-%s", name),
 		KubeConfig:   fmt.Sprintf("apiVersion: v1\nkind: Config\nclusters:\n- cluster:\n    server: https://%s.example.com:6443\n  name: %s", name, name),
 		CreationTime: time.Now(),
+		Manifests:    make(map[string]string),
+		Addons:       make(map[string]InstalledAddon),
+		NodeLabels:   make(map[string]map[string]string),
+		Volumes:      make(map[string]string),
+		JoinTokens:   make(map[string]MockJoinToken),
 	}
 
 	return nil
@@ -69,6 +91,69 @@ func (c *MockKamajiClient) DeleteTenantControlPlane(ctx context.Context, name st
 	return nil
 }
 
+// LoadIPAllocations loads the allocations of an IPAM pool
+func (c *MockKamajiClient) LoadIPAllocations(ctx context.Context, pool string) (map[string]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	allocations := make(map[string]string, len(c.ipAllocations[pool]))
+	for owner, r := range c.ipAllocations[pool] {
+		allocations[owner] = r
+	}
+	return allocations, nil
+}
+
+// SaveIPAllocations saves the allocations of an IPAM pool
+func (c *MockKamajiClient) SaveIPAllocations(ctx context.Context, pool string, allocations map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ipAllocations[pool] = make(map[string]string, len(allocations))
+	for owner, r := range allocations {
+		c.ipAllocations[pool][owner] = r
+	}
+	return nil
+}
+
+// GetNodeCredentials gets the generated node credentials of a tenant
+func (c *MockKamajiClient) GetNodeCredentials(ctx context.Context, name string) (*NodeCredentials, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	creds, ok := c.nodeCredentials[name]
+	if !ok {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// SaveNodeCredentials saves the generated node credentials of a tenant
+func (c *MockKamajiClient) SaveNodeCredentials(ctx context.Context, name string, creds *NodeCredentials) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nodeCredentials[name] = *creds
+	return nil
+}
+
+// DeleteNodeCredentials deletes the generated node credentials of a tenant
+func (c *MockKamajiClient) DeleteNodeCredentials(ctx context.Context, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.nodeCredentials, name)
+	return nil
+}
+
+// GetTenantControlPlane retrieves a tenant control plane from the stub client's status
+func (c *MockKamajiClient) GetTenantControlPlane(name string) (*MockTenantControlPlane, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tcp, exists := c.tenantControlPlanes[name]
+	return tcp, exists
+}
+
 // SetTenantControlPlaneStatus sets the status of a tenant control plane (for test setup)
 func (c *MockKamajiClient) SetTenantControlPlaneStatus(name, status string) error {
 	c.mu.Lock()
@@ -147,46 +232,56 @@ func (c *MockKamajiClient) GetTenantClient(ctx context.Context, name string) (Ka
 
 // StubKamajiTenantClient implements KamajiTenantClient for testing
 type StubKamajiTenantClient struct {
-	tcp    *MockTenantControlPlane
-	tokens map[string]*JoinTokenResponse
+	tcp *MockTenantControlPlane
 }
 
 // NewStubKamajiTenantClient creates a new tenant client for testing
 func NewStubKamajiTenantClient(tcp *MockTenantControlPlane) *StubKamajiTenantClient {
 	return &StubKamajiTenantClient{
-		tcp:    tcp,
-		tokens: make(map[string]*JoinTokenResponse),
+		tcp: tcp,
 	}
 }
 
 // CreateJoinToken creates a token for nodes to join the cluster
-func (t *StubKamajiTenantClient) CreateJoinToken(ctx context.Context, tenantName string, validityHours int) (*JoinTokenResponse, error) {
-	if validityHours <= 0 {
-		validityHours = 24 // Default to 24 hours
+func (t *StubKamajiTenantClient) CreateJoinToken(ctx context.Context, description string, ttl time.Duration) (*JoinTokenResponse, error) {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour // Default to 24 hours
 	}
 
-	tokenID := "test-token-id"
+	t.tcp.mu.Lock()
+	defer t.tcp.mu.Unlock()
+
+	t.tcp.tokenCount++
+	tokenID := fmt.Sprintf("token%d", t.tcp.tokenCount)
 	tokenSecret := "test-token-secret"
 	fullToken := fmt.Sprintf("%s.%s", tokenID, tokenSecret)
-	expirationTime := time.Now().Add(time.Duration(validityHours) * time.Hour)
+	expirationTime := time.Now().Add(ttl)
 
-	token := &JoinTokenResponse{
+	t.tcp.JoinTokens[tokenID] = MockJoinToken{Description: description, ExpirationTime: expirationTime}
+
+	return &JoinTokenResponse{
 		TokenID:        tokenID,
 		TokenSecret:    tokenSecret,
 		FullToken:      fullToken,
 		ExpirationTime: expirationTime,
-	}
+	}, nil
+}
 
+// DeleteJoinToken revokes a token
+func (t *StubKamajiTenantClient) DeleteJoinToken(ctx context.Context, tokenID string) error {
 	t.tcp.mu.Lock()
 	defer t.tcp.mu.Unlock()
-	t.tokens[tokenID] = token
 
-	return token, nil
+	delete(t.tcp.JoinTokens, tokenID)
+	return nil
 }
 
 // DeleteWorkerNode deletes a worker node
 func (t *StubKamajiTenantClient) DeleteWorkerNode(ctx context.Context, nodeName string) error {
-	// In the stub, we just pretend to delete the node
+	t.tcp.mu.Lock()
+	defer t.tcp.mu.Unlock()
+
+	t.tcp.DeletedNodes = append(t.tcp.DeletedNodes, nodeName)
 	return nil
 }
 
@@ -203,6 +298,75 @@ func (t *StubKamajiTenantClient) GetNodeStatus(ctx context.Context, nodeName str
 
 // CreateCalicoResources applies Calico networking resources to the tenant cluster
 func (t *StubKamajiTenantClient) CreateCalicoResources(ctx context.Context) error {
-	// In the stub, we just pretend to apply the resources
+	return t.ApplyManifest(ctx, "calico", "")
+}
+
+// ApplyManifest records the manifest applied under the given name
+func (t *StubKamajiTenantClient) ApplyManifest(ctx context.Context, name string, manifest string) error {
+	t.tcp.mu.Lock()
+	defer t.tcp.mu.Unlock()
+
+	t.tcp.Manifests[name] = manifest
+	return nil
+}
+
+// DeleteManifest forgets the manifest applied under the given name
+func (t *StubKamajiTenantClient) DeleteManifest(ctx context.Context, name string) error {
+	t.tcp.mu.Lock()
+	defer t.tcp.mu.Unlock()
+
+	delete(t.tcp.Manifests, name)
+	return nil
+}
+
+// GetAddons returns a copy of the recorded addons
+func (t *StubKamajiTenantClient) GetAddons(ctx context.Context) (map[string]InstalledAddon, error) {
+	t.tcp.mu.RLock()
+	defer t.tcp.mu.RUnlock()
+
+	addons := make(map[string]InstalledAddon, len(t.tcp.Addons))
+	for name, addon := range t.tcp.Addons {
+		addons[name] = addon
+	}
+	return addons, nil
+}
+
+// SetAddons records the installed addons
+func (t *StubKamajiTenantClient) SetAddons(ctx context.Context, addons map[string]InstalledAddon) error {
+	t.tcp.mu.Lock()
+	defer t.tcp.mu.Unlock()
+
+	t.tcp.Addons = make(map[string]InstalledAddon, len(addons))
+	for name, addon := range addons {
+		t.tcp.Addons[name] = addon
+	}
 	return nil
 }
+
+// SetNodeLabels records the labels of a node
+func (t *StubKamajiTenantClient) SetNodeLabels(ctx context.Context, nodeName string, labels map[string]string) error {
+	t.tcp.mu.Lock()
+	defer t.tcp.mu.Unlock()
+
+	if _, exists := t.tcp.NodeLabels[nodeName]; !exists {
+		t.tcp.NodeLabels[nodeName] = make(map[string]string)
+	}
+	for k, v := range labels {
+		t.tcp.NodeLabels[nodeName][k] = v
+	}
+	return nil
+}
+
+// ListVolumeHandles lists the recorded volumes of the given CSI driver
+func (t *StubKamajiTenantClient) ListVolumeHandles(ctx context.Context, driver string) ([]string, error) {
+	t.tcp.mu.RLock()
+	defer t.tcp.mu.RUnlock()
+
+	var handles []string
+	for handle, d := range t.tcp.Volumes {
+		if d == driver {
+			handles = append(handles, handle)
+		}
+	}
+	return handles, nil
+}
@@ -2,6 +2,8 @@ package agent
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,6 +16,22 @@ type VM struct {
 	Cores     int
 	Memory    int
 	CloudInit string
+	IPConfig  string
+	DNS       []string
+	NIC       string
+	CDROM     map[string]string // Drive to volume
+	Firewall  string            // Security group applied to the VM
+	Host      string            // Host the VM is placed on
+	Linked    bool              // Linked clone sharing the template disks
+	Template  bool              // Converted to a template
+	Source    int               // Template the VM was cloned from
+	Config    map[string]string // Other configuration options, such as the disks or 'description'
+
+	StopMode        string                  // How the VM was last stopped: 'stop' or 'shutdown'
+	IgnoreShutdown  bool                    // The guest ignores the shutdown requests
+	GuestAgentDown  bool                    // The guest agent does not respond
+	GuestInterfaces []GuestNetworkInterface // Reported by the guest agent, an address derived from the VM ID if nil
+	GuestCommands   []string                // Commands executed through the guest agent
 }
 
 // Task represents a task in the in-memory stub
@@ -30,20 +48,60 @@ type Task struct {
 
 // MockProxmoxClient implements ProxmoxClient interface for testing
 type MockProxmoxClient struct {
-	vms        map[int]*VM
-	tasks      map[string]*Task
-	nodeName   string
-	lastTaskID int
-	mu         sync.RWMutex
+	vms         map[int]*VM
+	tasks       map[string]*Task
+	roles       map[string][]string
+	users       map[string]*MockUser
+	volumes     map[string]bool
+	volumeHosts map[string]string // Host of the volumes on a host local storage
+	isos        map[string][]byte // Uploaded ISO images by volume
+	ipSets      map[string]map[string]bool
+	groups      map[string][]FirewallRule
+	hosts       []HostStatus
+	ha          map[int]*MockHAResource
+	storages    map[string]*StorageStatus
+	cloneErrs   map[string][]error // Errors of the next clones of each VM name
+	execs       map[int]*GuestExecStatus
+	execResult  map[string]GuestExecStatus // Result of the guest commands, success without output by default
+	lastPID     int
+	nodeName    string
+	lastTaskID  int
+	mu          sync.RWMutex
+}
+
+// MockHAResource represents an HA resource in the in-memory stub
+type MockHAResource struct {
+	Group string
+	State string
+}
+
+// MockUser represents a user and its API token in the in-memory stub
+type MockUser struct {
+	ID          string
+	Token       *APIToken
+	Permissions map[string]string // ACL path to role
 }
 
 // NewMockProxmoxClient creates a new in-memory stub Proxmox client
 func NewMockProxmoxClient(nodeName string) *MockProxmoxClient {
 	return &MockProxmoxClient{
-		vms:        make(map[int]*VM),
-		tasks:      make(map[string]*Task),
-		nodeName:   nodeName,
-		lastTaskID: 0,
+		vms:         make(map[int]*VM),
+		tasks:       make(map[string]*Task),
+		roles:       make(map[string][]string),
+		users:       make(map[string]*MockUser),
+		volumes:     make(map[string]bool),
+		volumeHosts: make(map[string]string),
+		isos:        make(map[string][]byte),
+		ipSets:      make(map[string]map[string]bool),
+		groups:      make(map[string][]FirewallRule),
+		hosts:       []HostStatus{{Name: nodeName, Online: true, MaxCPU: 16, MaxMemory: 64 << 30}},
+		ha:          make(map[int]*MockHAResource),
+		storages:    make(map[string]*StorageStatus),
+		cloneErrs:   make(map[string][]error),
+		execs:       make(map[int]*GuestExecStatus),
+		execResult:  make(map[string]GuestExecStatus),
+		nodeName:    nodeName,
+		lastTaskID:  0,
 	}
 }
 
@@ -58,6 +116,7 @@ func (c *MockProxmoxClient) AddVM(id int, name string, status VMStatus, cores in
 		Status: status,
 		Cores:  cores,
 		Memory: memory,
+		Host:   c.nodeName,
 	}
 
 	c.vms[id] = vm
@@ -108,14 +167,85 @@ func (c *MockProxmoxClient) createTask(taskType string, vmID int, exitStatus str
 	}
 }
 
+// SetHost adds a host to the cluster of the stub client, or replaces the one with the same name
+func (c *MockProxmoxClient) SetHost(host HostStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.hosts {
+		if c.hosts[i].Name == host.Name {
+			c.hosts[i] = host
+			return
+		}
+	}
+	c.hosts = append(c.hosts, host)
+}
+
+// GetHosts lists the hosts of the cluster, with the resources allocated to their VMs
+func (c *MockProxmoxClient) GetHosts() ([]HostStatus, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hosts := make([]HostStatus, len(c.hosts))
+	copy(hosts, c.hosts)
+	for i := range hosts {
+		for _, vm := range c.vms {
+			if vm.Host == hosts[i].Name {
+				hosts[i].AllocatedCPU += vm.Cores
+				hosts[i].AllocatedMemory += int64(vm.Memory) << 20
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// SetStorageStatus sets the usage of a storage, the same on all the hosts
+func (c *MockProxmoxClient) SetStorageStatus(storage string, status StorageStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.storages[storage] = &status
+}
+
+// GetStorageStatus retrieves the usage of a storage, 1 TB free unless set
+func (c *MockProxmoxClient) GetStorageStatus(_ string, storage string) (*StorageStatus, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status, exists := c.storages[storage]
+	if !exists {
+		return &StorageStatus{Type: "lvmthin", Total: 1 << 40, Available: 1 << 40}, nil
+	}
+	copied := *status
+	return &copied, nil
+}
+
+// FailClones makes the next clones of a VM name fail with the errors, one per clone
+func (c *MockProxmoxClient) FailClones(name string, errs ...error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cloneErrs[name] = append(c.cloneErrs[name], errs...)
+}
+
 // CloneVM creates a new VM by cloning from a template
-func (c *MockProxmoxClient) CloneVM(_ int, newVMID int, name string) (*TaskResponse, error) {
+func (c *MockProxmoxClient) CloneVM(templateID int, newVMID int, name string, target string, linked bool) (*TaskResponse, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if errs := c.cloneErrs[name]; len(errs) > 0 {
+		c.cloneErrs[name] = errs[1:]
+		return nil, errs[0]
+	}
+
 	if _, newVMExists := c.vms[newVMID]; newVMExists {
 		return nil, fmt.Errorf("VM with ID %d already exists", newVMID)
 	}
+	if target == "" {
+		target = c.nodeName
+	} else if !slices.ContainsFunc(c.hosts, func(h HostStatus) bool { return h.Name == target }) {
+		return nil, fmt.Errorf("host %s not found", target)
+	}
 
 	// Clone the VM synchronously
 	c.vms[newVMID] = &VM{
@@ -124,12 +254,155 @@ func (c *MockProxmoxClient) CloneVM(_ int, newVMID int, name string) (*TaskRespo
 		Status: VMStatusStopped,
 		Cores:  2,
 		Memory: 2048,
+		Host:   target,
+		Linked: linked,
+		Source: templateID,
 	}
 
 	// Create a completed task
 	return c.createTask("qmclone", newVMID, "OK"), nil
 }
 
+// ListVMs lists the VMs, the templates added as VMs included
+func (c *MockProxmoxClient) ListVMs() ([]VMInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	vms := make([]VMInfo, 0, len(c.vms))
+	for _, vm := range c.vms {
+		vms = append(vms, VMInfo{
+			Name:      vm.Name,
+			Status:    vm.Status,
+			VMID:      vm.ID,
+			NodeName:  vm.Host,
+			CPUCount:  vm.Cores,
+			MaxMemory: int64(vm.Memory) * 1024 * 1024,
+			MaxDisk:   10 * 1024 * 1024 * 1024,
+		})
+	}
+	return vms, nil
+}
+
+// RenameVM changes the name of a VM
+func (c *MockProxmoxClient) RenameVM(vmID int, name string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	vm.Name = name
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// CreateVM creates an empty VM on the configured host
+func (c *MockProxmoxClient) CreateVM(vmID int, name string, cores int, memory int, netConfig string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.vms[vmID]; exists {
+		return nil, fmt.Errorf("VM with ID %d already exists", vmID)
+	}
+	c.vms[vmID] = &VM{
+		ID:     vmID,
+		Name:   name,
+		Status: VMStatusStopped,
+		Cores:  cores,
+		Memory: memory,
+		NIC:    netConfig,
+		Host:   c.nodeName,
+		Config: make(map[string]string),
+	}
+
+	return c.createTask("qmcreate", vmID, "OK"), nil
+}
+
+// ImportDisk imports a disk image volume into a new disk of a VM
+func (c *MockProxmoxClient) ImportDisk(vmID int, drive string, storage string, volume string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if !c.volumes[volume] {
+		return nil, fmt.Errorf("volume %s not found", volume)
+	}
+	c.setConfig(vm, map[string]string{drive: fmt.Sprintf("%s:vm-%d-disk-0", storage, vmID)})
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// AttachCloudInitDrive adds a cloud-init drive to a VM
+func (c *MockProxmoxClient) AttachCloudInitDrive(vmID int, drive string, storage string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	c.setConfig(vm, map[string]string{drive: storage + ":cloudinit"})
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// SetVMConfig sets options of the configuration of a VM
+func (c *MockProxmoxClient) SetVMConfig(vmID int, options map[string]string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	c.setConfig(vm, options)
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// setConfig records configuration options of a VM, the mutex must be held
+func (c *MockProxmoxClient) setConfig(vm *VM, options map[string]string) {
+	if vm.Config == nil {
+		vm.Config = make(map[string]string)
+	}
+	for option, value := range options {
+		vm.Config[option] = value
+	}
+}
+
+// GetVMDescription retrieves the description of a VM
+func (c *MockProxmoxClient) GetVMDescription(vmID int) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return "", fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	return vm.Config["description"], nil
+}
+
+// ConvertToTemplate converts a stopped VM into a template
+func (c *MockProxmoxClient) ConvertToTemplate(vmID int) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if vm.Status != VMStatusStopped {
+		return nil, fmt.Errorf("VM %d is not stopped", vmID)
+	}
+	vm.Template = true
+
+	return c.createTask("qmtemplate", vmID, "OK"), nil
+}
+
 // ConfigureVM configures a VM (CPU, memory, cloud-init)
 func (c *MockProxmoxClient) ConfigureVM(vmID int, cores int, memory int, cloudInitConfig string) (*TaskResponse, error) {
 	c.mu.Lock()
@@ -151,6 +424,98 @@ func (c *MockProxmoxClient) ConfigureVM(vmID int, cores int, memory int, cloudIn
 	return c.createTask("qmconfig", vmID, "OK"), nil
 }
 
+// ConfigureNIC configures the network device of a VM
+func (c *MockProxmoxClient) ConfigureNIC(vmID int, netConfig string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+
+	vm.NIC = netConfig
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// AttachCDROM attaches a volume to a drive of a VM as a CD-ROM
+func (c *MockProxmoxClient) AttachCDROM(vmID int, drive string, volume string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if !c.volumes[volume] {
+		return nil, fmt.Errorf("volume %s not found", volume)
+	}
+	if vm.CDROM == nil {
+		vm.CDROM = make(map[string]string)
+	}
+	vm.CDROM[drive] = volume
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// DeleteVMConfig removes the cloud-init and CD-ROM options from the configuration of a VM
+func (c *MockProxmoxClient) DeleteVMConfig(vmID int, options []string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	for _, option := range options {
+		if option == "cicustom" {
+			vm.CloudInit = ""
+		} else {
+			delete(vm.CDROM, option)
+		}
+	}
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
+// UploadISO uploads an ISO image to a storage
+func (c *MockProxmoxClient) UploadISO(storage string, fileName string, content []byte) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	volumeID := fmt.Sprintf("%s:iso/%s", storage, fileName)
+	c.volumes[volumeID] = true
+	c.isos[volumeID] = content
+
+	return c.createTask("imgcopy", 0, "OK"), nil
+}
+
+// GetISO returns the content of an uploaded ISO image
+func (c *MockProxmoxClient) GetISO(volumeID string) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	content, exists := c.isos[volumeID]
+	return content, exists
+}
+
+// ConfigureNetwork configures the cloud-init network of a VM
+func (c *MockProxmoxClient) ConfigureNetwork(vmID int, ipConfig string, nameservers []string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+
+	vm.IPConfig = ipConfig
+	vm.DNS = nameservers
+
+	return c.createTask("qmconfig", vmID, "OK"), nil
+}
+
 // StartVM starts a virtual machine
 func (c *MockProxmoxClient) StartVM(vmID int) (*TaskResponse, error) {
 	c.mu.Lock()
@@ -188,11 +553,88 @@ func (c *MockProxmoxClient) StopVM(vmID int) (*TaskResponse, error) {
 
 	// Stop the VM synchronously
 	vm.Status = VMStatusStopped
+	vm.StopMode = "stop"
 
 	// Create a completed task
 	return c.createTask("qmstop", vmID, "OK"), nil
 }
 
+// ShutdownVM shuts down a virtual machine, unless its guest ignores the request and forceStop is false
+func (c *MockProxmoxClient) ShutdownVM(vmID int, _ time.Duration, forceStop bool) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if vm.Status == VMStatusStopped {
+		return nil, fmt.Errorf("VM with ID %d is already stopped", vmID)
+	}
+
+	switch {
+	case !vm.IgnoreShutdown:
+		vm.StopMode = "shutdown"
+	case forceStop:
+		vm.StopMode = "stop"
+	default:
+		return c.createTask("qmshutdown", vmID, "VM quit/powerdown failed"), nil
+	}
+	vm.Status = VMStatusStopped
+
+	return c.createTask("qmshutdown", vmID, "OK"), nil
+}
+
+// RebootVM reboots a running virtual machine
+func (c *MockProxmoxClient) RebootVM(vmID int) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if vm.Status != VMStatusRunning {
+		return nil, fmt.Errorf("VM with ID %d is not running", vmID)
+	}
+
+	return c.createTask("qmreboot", vmID, "OK"), nil
+}
+
+// SuspendVM pauses a running virtual machine
+func (c *MockProxmoxClient) SuspendVM(vmID int) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if vm.Status != VMStatusRunning {
+		return nil, fmt.Errorf("VM with ID %d is not running", vmID)
+	}
+	vm.Status = VMStatusPaused
+
+	return c.createTask("qmsuspend", vmID, "OK"), nil
+}
+
+// ResumeVM resumes a paused virtual machine
+func (c *MockProxmoxClient) ResumeVM(vmID int) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if vm.Status != VMStatusPaused {
+		return nil, fmt.Errorf("VM with ID %d is not paused", vmID)
+	}
+	vm.Status = VMStatusRunning
+
+	return c.createTask("qmresume", vmID, "OK"), nil
+}
+
 // DeleteVM deletes a virtual machine
 func (c *MockProxmoxClient) DeleteVM(vmID int) (*TaskResponse, error) {
 	c.mu.Lock()
@@ -202,6 +644,9 @@ func (c *MockProxmoxClient) DeleteVM(vmID int) (*TaskResponse, error) {
 	if !exists {
 		return nil, fmt.Errorf("VM with ID %d not found", vmID)
 	}
+	if _, registered := c.ha[vmID]; registered {
+		return nil, fmt.Errorf("unable to remove VM %d - used in HA resources", vmID)
+	}
 
 	// Delete the VM synchronously
 	delete(c.vms, vmID)
@@ -254,7 +699,7 @@ func (c *MockProxmoxClient) GetVMInfo(vmID int) (*VMInfo, error) {
 		Name:      vm.Name,
 		Status:    vm.Status,
 		VMID:      vm.ID,
-		NodeName:  c.nodeName,
+		NodeName:  vm.Host,
 		CPU:       0.0, // 0% CPU usage when not running
 		CPUCount:  vm.Cores,
 		Memory:    0,                              // No memory usage when not running
@@ -264,6 +709,9 @@ func (c *MockProxmoxClient) GetVMInfo(vmID int) (*VMInfo, error) {
 		Uptime:    0,                              // No uptime when not running
 		QMPStatus: "unknown",                      // QMP status (mock value)
 	}
+	if vm.Template {
+		status.Template = 1
+	}
 
 	// If VM is running, simulate some resource usage
 	if vm.Status == VMStatusRunning {
@@ -274,3 +722,339 @@ func (c *MockProxmoxClient) GetVMInfo(vmID int) (*VMInfo, error) {
 
 	return status, nil
 }
+
+// EnsureRole creates or updates a role
+func (c *MockProxmoxClient) EnsureRole(roleID string, privileges []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.roles[roleID] = privileges
+	return nil
+}
+
+// CreateAPIToken creates a user and its API token
+func (c *MockProxmoxClient) CreateAPIToken(userID string, tokenName string) (*APIToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.users[userID]; exists {
+		return nil, fmt.Errorf("user %s already exists", userID)
+	}
+
+	token := &APIToken{
+		TokenID: fmt.Sprintf("%s!%s", userID, tokenName),
+		Secret:  fmt.Sprintf("secret-%s-%s", userID, tokenName),
+	}
+	c.users[userID] = &MockUser{
+		ID:          userID,
+		Token:       token,
+		Permissions: make(map[string]string),
+	}
+	return token, nil
+}
+
+// GrantPermission grants a role to an API token on an ACL path
+func (c *MockProxmoxClient) GrantPermission(path string, tokenID string, roleID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.roles[roleID]; !exists {
+		return fmt.Errorf("role %s not found", roleID)
+	}
+	for _, user := range c.users {
+		if user.Token.TokenID == tokenID {
+			user.Permissions[path] = roleID
+			return nil
+		}
+	}
+	return fmt.Errorf("token %s not found", tokenID)
+}
+
+// DeleteUser deletes a user
+func (c *MockProxmoxClient) DeleteUser(userID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.users[userID]; !exists {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	delete(c.users, userID)
+	return nil
+}
+
+// GetUser retrieves a user from the stub client's status
+func (c *MockProxmoxClient) GetUser(userID string) (*MockUser, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	user, exists := c.users[userID]
+	return user, exists
+}
+
+// AddVolume adds a storage volume to the stub client's status
+func (c *MockProxmoxClient) AddVolume(volumeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.volumes[volumeID] = true
+}
+
+// HasVolume checks if a storage volume exists
+func (c *MockProxmoxClient) HasVolume(volumeID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.volumes[volumeID]
+}
+
+// AddHostVolume adds a storage volume on a storage local to a host
+func (c *MockProxmoxClient) AddHostVolume(host string, volumeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.volumes[volumeID] = true
+	c.volumeHosts[volumeID] = host
+}
+
+// DeleteVolume deletes a storage volume, the volumes of a host local storage only through that host
+func (c *MockProxmoxClient) DeleteVolume(host string, volumeID string) (*TaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if host == "" {
+		host = c.nodeName
+	}
+	if !c.volumes[volumeID] {
+		return nil, fmt.Errorf("volume %s not found", volumeID)
+	}
+	if volumeHost, ok := c.volumeHosts[volumeID]; ok && volumeHost != host {
+		return nil, fmt.Errorf("volume %s not found on host %s", volumeID, host)
+	}
+	delete(c.volumes, volumeID)
+	delete(c.volumeHosts, volumeID)
+	delete(c.isos, volumeID)
+
+	return c.createTask("imgdel", 0, "OK"), nil
+}
+
+// EnsureIPSet creates an IP set
+func (c *MockProxmoxClient) EnsureIPSet(name string, comment string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.ipSets[name]; !exists {
+		c.ipSets[name] = make(map[string]bool)
+	}
+	return nil
+}
+
+// AddIPSetEntry adds an address to an IP set
+func (c *MockProxmoxClient) AddIPSetEntry(name string, cidr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ipSet, exists := c.ipSets[name]
+	if !exists {
+		return fmt.Errorf("IP set %s not found", name)
+	}
+	ipSet[cidr] = true
+	return nil
+}
+
+// RemoveIPSetEntry removes an address from an IP set
+func (c *MockProxmoxClient) RemoveIPSetEntry(name string, cidr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ipSet, exists := c.ipSets[name]
+	if !exists || !ipSet[cidr] {
+		return fmt.Errorf("IP set %s entry %s not found", name, cidr)
+	}
+	delete(ipSet, cidr)
+	return nil
+}
+
+// DeleteIPSet deletes an IP set
+func (c *MockProxmoxClient) DeleteIPSet(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.ipSets, name)
+	return nil
+}
+
+// GetIPSet retrieves the addresses of an IP set from the stub client's status
+func (c *MockProxmoxClient) GetIPSet(name string) (map[string]bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ipSet, exists := c.ipSets[name]
+	return ipSet, exists
+}
+
+// EnsureSecurityGroup creates or replaces a security group
+func (c *MockProxmoxClient) EnsureSecurityGroup(name string, rules []FirewallRule) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.groups[name] = rules
+	return nil
+}
+
+// DeleteSecurityGroup deletes a security group
+func (c *MockProxmoxClient) DeleteSecurityGroup(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.groups, name)
+	return nil
+}
+
+// GetSecurityGroup retrieves the rules of a security group from the stub client's status
+func (c *MockProxmoxClient) GetSecurityGroup(name string) ([]FirewallRule, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rules, exists := c.groups[name]
+	return rules, exists
+}
+
+// EnableVMFirewall applies a security group to a VM
+func (c *MockProxmoxClient) EnableVMFirewall(vmID int, group string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if _, exists := c.groups[group]; !exists {
+		return fmt.Errorf("security group %s not found", group)
+	}
+	vm.Firewall = group
+	return nil
+}
+
+// SetHAResource registers a VM as an HA resource, the VM is immediately brought to the requested state
+func (c *MockProxmoxClient) SetHAResource(vmID int, group string, state string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	switch state {
+	case "started":
+		vm.Status = VMStatusRunning
+	case "stopped":
+		vm.Status = VMStatusStopped
+	default:
+		return fmt.Errorf("unsupported HA state %s", state)
+	}
+	c.ha[vmID] = &MockHAResource{Group: group, State: state}
+
+	return nil
+}
+
+// RemoveHAResource unregisters a VM from HA
+func (c *MockProxmoxClient) RemoveHAResource(vmID int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.ha, vmID)
+	return nil
+}
+
+// GetHAResource retrieves the HA resource of a VM
+func (c *MockProxmoxClient) GetHAResource(vmID int) (*MockHAResource, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	r, exists := c.ha[vmID]
+	return r, exists
+}
+
+// SetGuestExecResult sets the result of a command executed through the guest agent, given as its space separated arguments
+func (c *MockProxmoxClient) SetGuestExecResult(command string, status GuestExecStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.execResult[command] = status
+}
+
+// runningGuest returns a running VM with a responding guest agent, the mutex must be held
+func (c *MockProxmoxClient) runningGuest(vmID int) (*VM, error) {
+	vm, exists := c.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM with ID %d not found", vmID)
+	}
+	if vm.Status != VMStatusRunning || vm.GuestAgentDown {
+		return nil, fmt.Errorf("QEMU guest agent is not running")
+	}
+	return vm, nil
+}
+
+// AgentPing checks that the guest agent of a VM responds
+func (c *MockProxmoxClient) AgentPing(vmID int) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, err := c.runningGuest(vmID)
+	return err
+}
+
+// AgentNetworkInterfaces lists the network interfaces of a VM reported by its guest agent
+func (c *MockProxmoxClient) AgentNetworkInterfaces(vmID int) ([]GuestNetworkInterface, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	vm, err := c.runningGuest(vmID)
+	if err != nil {
+		return nil, err
+	}
+	if vm.GuestInterfaces != nil {
+		return vm.GuestInterfaces, nil
+	}
+	return []GuestNetworkInterface{
+		{Name: "lo", IPAddresses: []GuestIPAddress{{Type: "ipv4", Address: "127.0.0.1", Prefix: 8}}},
+		{Name: "eth0", IPAddresses: []GuestIPAddress{
+			{Type: "ipv4", Address: fmt.Sprintf("192.168.%d.%d", vmID/250%250, vmID%250+2), Prefix: 24},
+			{Type: "ipv6", Address: "fe80::1", Prefix: 64},
+		}},
+	}, nil
+}
+
+// AgentExec starts a command through the guest agent of a VM, which completes immediately
+func (c *MockProxmoxClient) AgentExec(vmID int, command []string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, err := c.runningGuest(vmID)
+	if err != nil {
+		return 0, err
+	}
+	line := strings.Join(command, " ")
+	vm.GuestCommands = append(vm.GuestCommands, line)
+	status := c.execResult[line]
+	status.Exited = true
+	c.lastPID++
+	c.execs[c.lastPID] = &status
+
+	return c.lastPID, nil
+}
+
+// AgentExecStatus retrieves the status and output of a command started through the guest agent
+func (c *MockProxmoxClient) AgentExecStatus(vmID int, pid int) (*GuestExecStatus, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, err := c.runningGuest(vmID); err != nil {
+		return nil, err
+	}
+	status, exists := c.execs[pid]
+	if !exists {
+		return nil, fmt.Errorf("no command with PID %d", pid)
+	}
+	return status, nil
+}
@@ -54,6 +54,15 @@ func (s *MockSSHClient) FileExists(filePath string) bool {
 	return exists
 }
 
+// GetFile returns the content of a file
+func (s *MockSSHClient) GetFile(filePath string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	content, exists := s.filePaths[filePath]
+	return content, exists
+}
+
 // Reset clears all files and operations
 func (s *MockSSHClient) Reset() {
 	s.mu.Lock()
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"fmt"
+
+	"fulcrumproject.org/kube-agent/internal/cloudinit"
+)
+
+// DefaultCloudInitTemplate is the name of the embedded cloud-init template
+const DefaultCloudInitTemplate = "default"
+
+// CloudInitConfig holds the cloud-init templates the worker nodes are configured with
+type CloudInitConfig struct {
+	Templates map[string]cloudinit.Template // Named templates, besides the embedded default one
+	Default   string                        // Template of the nodes not selecting one
+	Sizes     map[NodeSize]string           // Template of the nodes of a size, unless the service selects one
+}
+
+// WithCloudInitTemplates returns an option that registers named cloud-init templates.
+// Services select one with their properties, otherwise the template of the node size or the default one is used.
+func WithCloudInitTemplates(cfg CloudInitConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.Default == "" {
+			cfg.Default = DefaultCloudInitTemplate
+		}
+		h.cloudInit = &cfg
+	}
+}
+
+// Validate checks that the default and size templates are registered or embedded
+func (cfg CloudInitConfig) Validate() error {
+	names := []string{cfg.Default}
+	for _, name := range cfg.Sizes {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, ok := cfg.Templates[name]; !ok && name != "" && name != DefaultCloudInitTemplate && name != FlatcarCloudInitTemplate {
+			return fmt.Errorf("unknown cloud-init template %s", name)
+		}
+	}
+	return nil
+}
+
+// ValidateNodeTemplates checks that the cloud-init templates the nodes of the registered templates get are
+// registered or embedded, and fit their OS
+func (cfg CloudInitConfig) ValidateNodeTemplates(templates []NodeTemplate) error {
+	for _, t := range templates {
+		names := []string{t.cloudInitTemplate()}
+		if names[0] == "" {
+			names[0] = cfg.Default
+			for _, name := range cfg.Sizes {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			if name == "" {
+				name = DefaultCloudInitTemplate
+			}
+			if _, ok := cfg.Templates[name]; ok {
+				continue
+			}
+			if name != DefaultCloudInitTemplate && name != FlatcarCloudInitTemplate {
+				return fmt.Errorf("unknown cloud-init template %s of template %d", name, t.VMID)
+			}
+			if err := checkCloudInitOS(name, t.OS); err != nil {
+				return fmt.Errorf("template %d: %w", t.VMID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkCloudInitOS fails if an embedded cloud-init template does not fit the OS of the nodes: the default one
+// installs the packages with apt, the Flatcar one configures Flatcar. The OS of the agent template is unknown.
+func checkCloudInitOS(name, os string) error {
+	if os == "" {
+		return nil
+	}
+	if (name == DefaultCloudInitTemplate && os == OSFlatcar) || (name == FlatcarCloudInitTemplate && os != OSFlatcar) {
+		return fmt.Errorf("cloud-init template %s does not fit %s nodes", name, os)
+	}
+	return nil
+}
+
+// cloudInitTemplate returns the cloud-init template of a node. The template of the node OS, if any,
+// takes precedence over the ones of the agent.
+func (h *JobHandler) cloudInitTemplate(props *Properties, size NodeSize) (cloudinit.Template, error) {
+	name := DefaultCloudInitTemplate
+	if h.cloudInit != nil {
+		name = h.cloudInit.Default
+		if sizeName, ok := h.cloudInit.Sizes[size]; ok {
+			name = sizeName
+		}
+	}
+	nodeTemplate, err := h.nodeTemplate(props)
+	if err != nil {
+		return "", err
+	}
+	if osName := nodeTemplate.cloudInitTemplate(); osName != "" {
+		name = osName
+	}
+	if props != nil && props.CloudInitTemplate != "" {
+		name = props.CloudInitTemplate
+	}
+
+	if h.cloudInit != nil {
+		if templ, ok := h.cloudInit.Templates[name]; ok {
+			return templ, nil
+		}
+	}
+	if err := checkCloudInitOS(name, nodeTemplate.OS); err != nil {
+		return "", err
+	}
+	switch name {
+	case DefaultCloudInitTemplate:
+		return cloudinit.CloudInitTempl, nil
+	case FlatcarCloudInitTemplate:
+		return cloudinit.FlatcarTempl, nil
+	}
+	return "", fmt.Errorf("unknown cloud-init template %s", name)
+}
+
+// validateCloudInit checks the cloud-init templates and user data fragments of a service
+func (h *JobHandler) validateCloudInit(props *Properties) error {
+	for _, node := range props.Nodes {
+		if _, err := h.cloudInitTemplate(props, node.Size); err != nil {
+			return err
+		}
+	}
+	for _, fragment := range props.UserData {
+		if err := cloudinit.ValidateFragment(fragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
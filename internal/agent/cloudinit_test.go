@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"fulcrumproject.org/kube-agent/internal/cloudinit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerCloudInitTemplates(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	sshCli := NewMockSSHClient()
+	cfg := CloudInitConfig{
+		Templates: map[string]cloudinit.Template{
+			"large":  "#cloud-config\nhostname: {{.Hostname}}\n",
+			"gpu":    "#cloud-config\nhostname: {{.Hostname}}\ntimezone: {{quote .Vars.timezone}}\n",
+			"broken": "#cloud-config\nhostname: {{.Hostname}}\nunknown: true\n",
+		},
+		Sizes: map[NodeSize]string{NodeSizeS4: "large"},
+	}
+	require.NoError(t, cfg.Validate())
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, sshCli, WithCloudInitTemplates(cfg))
+	cloudInitFile := func(serviceName, nodeID string) string {
+		content, ok := sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName(serviceName, nodeID)))
+		require.True(t, ok)
+		return content
+	}
+
+	// The nodes get the template of their size, or the default one
+	small := Node{ID: "small", Size: NodeSizeS1, Status: NodeStatusOff}
+	large := Node{ID: "large", Size: NodeSizeS4, Status: NodeStatusOff}
+	require.NoError(t, fulcrumCli.CreateService("size-service", "size-cluster", nil, &Properties{Nodes: []Node{small, large}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Contains(t, cloudInitFile("size-cluster", "small"), "kind: JoinConfiguration")
+	require.Equal(t, "#cloud-config\nhostname: "+vmName("size-cluster", "large")+"\n", cloudInitFile("size-cluster", "large"))
+
+	// The service template is rendered with its variables and the fragments are merged into it
+	props := &Properties{
+		Nodes:             []Node{small},
+		CloudInitTemplate: "gpu",
+		CloudInitVars:     map[string]string{"timezone": "Europe/Rome"},
+		UserData:          []string{"#cloud-config\npackages:\n  - htop\n"},
+	}
+	require.NoError(t, fulcrumCli.CreateService("gpu-service", "gpu-cluster", nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	content := cloudInitFile("gpu-cluster", "small")
+	require.Contains(t, content, "Content-Type: multipart/mixed")
+	require.Contains(t, content, `timezone: "Europe/Rome"`)
+	require.Contains(t, content, "  - htop")
+
+	// Unknown templates and forbidden fragments are rejected before creating anything
+	invalidProps := []*Properties{
+		{Nodes: []Node{small}, CloudInitTemplate: "unknown"},
+		{Nodes: []Node{small}, UserData: []string{"#cloud-config\nusers: []\n"}},
+	}
+	for i, props := range invalidProps {
+		serviceName := fmt.Sprintf("invalid-cluster-%d", i)
+		require.NoError(t, fulcrumCli.CreateService(fmt.Sprintf("invalid-service-%d", i), serviceName, nil, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+		_, ok := kamajiCli.GetTenantControlPlane(serviceName)
+		require.False(t, ok)
+	}
+
+	// An invalid rendered configuration fails the job before the VM is cloned
+	require.NoError(t, fulcrumCli.CreateService("broken-service", "broken-cluster", nil, &Properties{Nodes: []Node{small}, CloudInitTemplate: "broken"}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+	_, ok := proxmoxCli.GetVM(jobHandler.generateVMID("broken-cluster", "small"))
+	require.False(t, ok)
+
+	// The default and size templates must be registered
+	require.Error(t, CloudInitConfig{Default: "unknown"}.Validate())
+	require.Error(t, CloudInitConfig{Sizes: map[NodeSize]string{NodeSizeS1: "unknown"}}.Validate())
+
+	// The cloud-init templates of the node templates must be registered or embedded, and fit their OS
+	ubuntu := NodeTemplate{OS: OSUbuntu, KubeVersion: "v1.30.2", VMID: 200}
+	flatcar := NodeTemplate{OS: OSFlatcar, KubeVersion: "v1.30.2", VMID: 201}
+	require.NoError(t, cfg.ValidateNodeTemplates([]NodeTemplate{ubuntu, flatcar}))
+	require.NoError(t, CloudInitConfig{}.ValidateNodeTemplates([]NodeTemplate{ubuntu, flatcar}))
+	custom := flatcar
+	custom.CloudInit = "large"
+	require.NoError(t, cfg.ValidateNodeTemplates([]NodeTemplate{custom}))
+	custom.CloudInit = "unknown"
+	require.ErrorContains(t, cfg.ValidateNodeTemplates([]NodeTemplate{custom}), "unknown cloud-init template unknown")
+	custom.CloudInit = DefaultCloudInitTemplate
+	require.ErrorContains(t, cfg.ValidateNodeTemplates([]NodeTemplate{custom}), "cloud-init template default does not fit flatcar nodes")
+	require.ErrorContains(t, CloudInitConfig{Default: FlatcarCloudInitTemplate}.ValidateNodeTemplates([]NodeTemplate{ubuntu}),
+		"cloud-init template flatcar does not fit ubuntu nodes")
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultNodeUser is the login user of the worker nodes
+const DefaultNodeUser = "ubuntu"
+
+// NodeCredentialsSecret returns the name of the management cluster Secret holding the generated node credentials
+// of a tenant
+func NodeCredentialsSecret(tenantName string) string {
+	return "fulcrum-node-credentials-" + tenantName
+}
+
+// NodeCredentialsConfig holds the configuration of the access to the worker nodes
+type NodeCredentialsConfig struct {
+	User           string   // Login user of the nodes
+	AuthorizedKeys []string // SSH public keys authorized on the nodes of all the tenants
+	PasswordLogin  bool     // Enable the password login with a generated password
+}
+
+// NodeCredentials holds the generated credentials of the nodes of a tenant
+type NodeCredentials struct {
+	SSHPublicKey  string // Authorized key format
+	SSHPrivateKey string // OpenSSH PEM format
+	Password      string // Plain text, only hashed into the cloud-init configuration
+}
+
+// nodeAccess holds the access to the nodes written into their cloud-init configuration
+type nodeAccess struct {
+	user         string
+	sshKeys      []string
+	passwordHash string // Password login is disabled if empty
+}
+
+// WithNodeCredentials returns an option that configures the access to the worker nodes.
+// Without it the nodes only accept the SSH keys of the service properties.
+func WithNodeCredentials(cfg NodeCredentialsConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.User == "" {
+			cfg.User = DefaultNodeUser
+		}
+		h.credentials = cfg
+	}
+}
+
+// normalizeSSHKeys parses SSH public keys in authorized keys format, dropping their options
+func normalizeSSHKeys(keys []string) ([]string, error) {
+	normalized := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH key %q: %w", key, err)
+		}
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+		if comment != "" {
+			line += " " + comment
+		}
+		normalized = append(normalized, line)
+	}
+	return normalized, nil
+}
+
+// prepareNodeAccess generates the missing tenant credentials, refers to the Secret holding them in the resources
+// and builds the access to the nodes created by the job. The secrets themselves are never returned to Fulcrum.
+func (h *JobHandler) prepareNodeAccess(ctx context.Context, tenantName string, props *Properties, resources *Resources) (*nodeAccess, error) {
+	creds, err := h.kamajiCli.GetNodeCredentials(ctx, tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node credentials: %w", err)
+	}
+	if creds == nil {
+		creds = &NodeCredentials{}
+	}
+
+	// The credentials are generated once per tenant, so that all its nodes share them
+	changed := false
+	if props.GenerateSSHKey && creds.SSHPrivateKey == "" {
+		if creds.SSHPublicKey, creds.SSHPrivateKey, err = generateSSHKeyPair("fulcrum-" + tenantName); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if h.credentials.PasswordLogin && creds.Password == "" {
+		if creds.Password, err = generatePassword(); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if changed {
+		if err := h.kamajiCli.SaveNodeCredentials(ctx, tenantName, creds); err != nil {
+			return nil, fmt.Errorf("failed to save node credentials: %w", err)
+		}
+	}
+
+	keys, err := normalizeSSHKeys(slices.Concat(h.credentials.AuthorizedKeys, props.SSHKeys))
+	if err != nil {
+		return nil, err
+	}
+	access := &nodeAccess{user: h.credentials.User, sshKeys: keys}
+	resources.NodeUser = h.credentials.User
+	resources.SSHPublicKey, resources.CredentialsSecret = "", ""
+	if props.GenerateSSHKey {
+		access.sshKeys = append(access.sshKeys, creds.SSHPublicKey)
+		resources.SSHPublicKey = creds.SSHPublicKey
+		resources.CredentialsSecret = NodeCredentialsSecret(tenantName)
+	}
+	if h.credentials.PasswordLogin {
+		hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash node password: %w", err)
+		}
+		access.passwordHash = string(hash)
+		resources.CredentialsSecret = NodeCredentialsSecret(tenantName)
+	}
+
+	return access, nil
+}
+
+// generateSSHKeyPair generates an ed25519 key pair, returning the public key in authorized keys format
+// and the private key in OpenSSH PEM format
+func generateSSHKeyPair(comment string) (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate SSH key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode SSH public key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode SSH private key: %w", err)
+	}
+
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+	return publicKey, string(pem.EncodeToMemory(block)), nil
+}
+
+// generatePassword generates a random password
+func generatePassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate node password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
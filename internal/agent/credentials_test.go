@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestJobHandlerNodeCredentials(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	sshCli := NewMockSSHClient()
+	agentKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBeZfPGgiVw7zMpOhs7RQMCL3+jxfA8U1iiGSiYDSXWy admin@example.com"
+	serviceKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHsGPZSeyt5Ng1gFyrhHqbT1RzdIDm4mTe1hjUsENjVp tenant@example.com"
+	cfg := NodeCredentialsConfig{User: "kube", AuthorizedKeys: []string{agentKey}, PasswordLogin: true}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, sshCli, WithNodeCredentials(cfg))
+
+	serviceID := "creds-service"
+	serviceName := "creds-cluster"
+	node1 := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}
+	node2 := Node{ID: "node2", Size: NodeSizeS1, Status: NodeStatusOff}
+	props := &Properties{Nodes: []Node{node1}, SSHKeys: []string{serviceKey}, GenerateSSHKey: true}
+
+	// Create the service, the tenant key pair and password are generated
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	// Only the public key and a reference to the Secret holding the credentials are returned
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, "kube", service.Resources.NodeUser)
+	require.Equal(t, "fulcrum-node-credentials-"+serviceName, service.Resources.CredentialsSecret)
+	creds, err := kamajiCli.GetNodeCredentials(context.Background(), serviceName)
+	require.NoError(t, err)
+	require.NotEmpty(t, creds.Password)
+	resources, err := json.Marshal(service.Resources)
+	require.NoError(t, err)
+	require.NotContains(t, string(resources), creds.Password)
+	require.NotContains(t, string(resources), "PRIVATE KEY")
+	signer, err := ssh.ParsePrivateKey([]byte(creds.SSHPrivateKey))
+	require.NoError(t, err)
+	generatedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(service.Resources.SSHPublicKey))
+	require.NoError(t, err)
+	require.Equal(t, generatedKey.Marshal(), signer.PublicKey().Marshal())
+
+	// The node accepts all the keys and only the password hash is written
+	content, ok := sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName(serviceName, "node1")))
+	require.True(t, ok)
+	require.Contains(t, content, `- name: "kube"`)
+	require.Contains(t, content, `- "`+agentKey+`"`)
+	require.Contains(t, content, `- "`+serviceKey+`"`)
+	require.Contains(t, content, `- "`+service.Resources.SSHPublicKey+`"`)
+	require.Contains(t, content, "ssh_pwauth: true")
+	require.NotContains(t, content, creds.Password)
+	hash := extractValue(t, content, "hashed_passwd: ")
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte(creds.Password)))
+
+	// Nodes added later share the tenant credentials
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	updatedProps := *props
+	updatedProps.Nodes = []Node{node1, node2}
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, &updatedProps))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	updated, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, service.Resources.SSHPublicKey, updated.Resources.SSHPublicKey)
+	updatedCreds, err := kamajiCli.GetNodeCredentials(context.Background(), serviceName)
+	require.NoError(t, err)
+	require.Equal(t, creds, updatedCreds)
+	content, ok = sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName(serviceName, "node2")))
+	require.True(t, ok)
+	require.Contains(t, content, `- "`+service.Resources.SSHPublicKey+`"`)
+
+	// Deleting the service deletes the credentials
+	require.NoError(t, fulcrumCli.StopService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.DeleteService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	creds, err = kamajiCli.GetNodeCredentials(context.Background(), serviceName)
+	require.NoError(t, err)
+	require.Nil(t, creds)
+}
+
+func TestJobHandlerNodeCredentialsDefaults(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	sshCli := NewMockSSHClient()
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, sshCli)
+
+	// Without credentials the password login is disabled and nothing is generated
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}
+	require.NoError(t, fulcrumCli.CreateService("default-service", "default-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err := fulcrumCli.GetService("default-service")
+	require.NoError(t, err)
+	require.Equal(t, DefaultNodeUser, service.Resources.NodeUser)
+	require.Empty(t, service.Resources.SSHPublicKey)
+	require.Empty(t, service.Resources.CredentialsSecret)
+	creds, err := kamajiCli.GetNodeCredentials(context.Background(), "default-cluster")
+	require.NoError(t, err)
+	require.Nil(t, creds)
+
+	content, ok := sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName("default-cluster", "node1")))
+	require.True(t, ok)
+	require.Contains(t, content, "lock_passwd: true")
+	require.Contains(t, content, "ssh_pwauth: false")
+
+	// Malformed keys are rejected before creating anything
+	props := &Properties{Nodes: []Node{node}, SSHKeys: []string{"not a key"}}
+	require.NoError(t, fulcrumCli.CreateService("invalid-service", "invalid-cluster", nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+	_, ok = kamajiCli.GetTenantControlPlane("invalid-cluster")
+	require.False(t, ok)
+}
+
+// extractValue returns the double quoted value following the given prefix in the content
+func extractValue(t *testing.T, content, prefix string) string {
+	var value string
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, prefix); i >= 0 {
+			value = strings.Trim(line[i+len(prefix):], `"`)
+		}
+	}
+	require.NotEmpty(t, value)
+	return value
+}
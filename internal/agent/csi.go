@@ -0,0 +1,246 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// CSIDriverName is the name of the Proxmox CSI driver
+	CSIDriverName = "csi.proxmox.sinextra.dev"
+
+	// TopologyRegionLabel is the node label holding the Proxmox cluster of a node
+	TopologyRegionLabel = "topology.kubernetes.io/region"
+
+	// TopologyZoneLabel is the node label holding the Proxmox host of a node
+	TopologyZoneLabel = "topology.kubernetes.io/zone"
+
+	csiRoleID       = "FulcrumKubeCSI"
+	csiTokenName    = "csi"
+	csiReleaseName  = "proxmox-csi"
+	csiNamespace    = "csi-proxmox"
+	csiStorageClass = "proxmox"
+)
+
+// csiPrivileges are the Proxmox privileges required by the CSI driver to manage the tenant disks
+var csiPrivileges = []string{
+	"VM.Audit",
+	"VM.Config.Disk",
+	"Datastore.Allocate",
+	"Datastore.AllocateSpace",
+	"Datastore.Audit",
+}
+
+// CSIConfig holds the configuration of the Proxmox CSI driver deployed into the tenant clusters
+type CSIConfig struct {
+	Chart    string // Chart reference of the CSI plugin
+	Version  string // Chart version of the CSI plugin
+	APIURL   string // Proxmox API URL reachable from the tenant nodes
+	Insecure bool   // Skip the Proxmox API TLS certificate validation
+	Region   string // Proxmox cluster name, used as topology region
+	Storage  string // Proxmox storage backing the tenant StorageClass
+}
+
+// WithProxmoxCSI returns an option that deploys the Proxmox CSI driver into the tenant clusters.
+// The driver is installed as a Helm release, so the Helm client is required as well.
+func WithProxmoxCSI(cfg CSIConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.csi = &cfg
+	}
+}
+
+// csiUserID returns the Proxmox user owning the CSI API token of a tenant
+func csiUserID(tenantName string) string {
+	return fmt.Sprintf("kube-csi-%s@pve", tenantName)
+}
+
+// csiTokenID returns the full ID of the CSI API token of a tenant
+func csiTokenID(tenantName string) string {
+	return fmt.Sprintf("%s!%s", csiUserID(tenantName), csiTokenName)
+}
+
+// deployCSI creates the tenant scoped Proxmox API token and installs the CSI driver into the tenant cluster
+func (h *JobHandler) deployCSI(ctx context.Context, tenantName string) error {
+	if h.csi == nil {
+		return nil
+	}
+	if h.helmCli == nil {
+		return fmt.Errorf("the Proxmox CSI driver requires helm charts to be enabled")
+	}
+
+	if err := h.proxmoxCli.EnsureRole(csiRoleID, csiPrivileges); err != nil {
+		return fmt.Errorf("failed to ensure CSI role: %w", err)
+	}
+
+	// The token secret is only returned on creation, so a leftover user of a previous attempt is recreated
+	userID := csiUserID(tenantName)
+	if err := h.proxmoxCli.DeleteUser(userID); err == nil {
+		log.Printf("Deleted leftover CSI user %s", userID)
+	}
+	token, err := h.proxmoxCli.CreateAPIToken(userID, csiTokenName)
+	if err != nil {
+		return fmt.Errorf("failed to create CSI API token: %w", err)
+	}
+	if err := h.proxmoxCli.GrantPermission("/storage/"+h.csi.Storage, token.TokenID, csiRoleID); err != nil {
+		return fmt.Errorf("failed to grant CSI storage permission: %w", err)
+	}
+
+	kubeConfig, err := h.kamajiCli.GetTenantKubeConfig(ctx, tenantName)
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	log.Printf("Installing Proxmox CSI driver to tenant %s", tenantName)
+	release := HelmRelease{
+		Name:      csiReleaseName,
+		Namespace: csiNamespace,
+		Chart:     h.csi.Chart,
+		Version:   h.csi.Version,
+		Values:    h.csiValues(token),
+	}
+	if err := h.helmCli.InstallRelease(ctx, kubeConfig.Config, release); err != nil {
+		return fmt.Errorf("failed to install CSI driver: %w", err)
+	}
+
+	return nil
+}
+
+// csiValues returns the Helm values of the CSI driver release
+func (h *JobHandler) csiValues(token *APIToken) map[string]any {
+	apiURL := strings.TrimSuffix(h.csi.APIURL, "/")
+	if !strings.HasSuffix(apiURL, "/api2/json") {
+		apiURL += "/api2/json"
+	}
+
+	return map[string]any{
+		"config": map[string]any{
+			"clusters": []any{
+				map[string]any{
+					"url":          apiURL,
+					"insecure":     h.csi.Insecure,
+					"token_id":     token.TokenID,
+					"token_secret": token.Secret,
+					"region":       h.csi.Region,
+				},
+			},
+		},
+		"storageClass": []any{
+			map[string]any{
+				"name":          csiStorageClass,
+				"storage":       h.csi.Storage,
+				"reclaimPolicy": "Delete",
+				"fstype":        "ext4",
+				"annotations": map[string]any{
+					"storageclass.kubernetes.io/is-default-class": "true",
+				},
+			},
+		},
+		// Tenant control planes run in the management cluster, the controller runs on the workers
+		"nodeSelector": map[string]any{
+			"node-role.kubernetes.io/control-plane": nil,
+		},
+	}
+}
+
+// grantCSIAccess allows the CSI driver of a tenant to attach disks to a VM of the tenant
+func (h *JobHandler) grantCSIAccess(tenantName string, vmID int) error {
+	if h.csi == nil {
+		return nil
+	}
+	if err := h.proxmoxCli.GrantPermission(fmt.Sprintf("/vms/%d", vmID), csiTokenID(tenantName), csiRoleID); err != nil {
+		return fmt.Errorf("failed to grant CSI VM permission: %w", err)
+	}
+	return nil
+}
+
+// labelCSINode sets the topology labels the CSI driver uses to locate the VM of a node
+func (h *JobHandler) labelCSINode(ctx context.Context, tenantClient KamajiTenantClient, vmID int, nodeName string) error {
+	if h.csi == nil {
+		return nil
+	}
+
+	info, err := h.proxmoxCli.GetVMInfo(vmID)
+	if err != nil {
+		return fmt.Errorf("failed to get VM info: %w", err)
+	}
+
+	labels := map[string]string{
+		TopologyRegionLabel: h.csi.Region,
+		TopologyZoneLabel:   info.NodeName,
+	}
+	if err := tenantClient.SetNodeLabels(ctx, nodeName, labels); err != nil {
+		return fmt.Errorf("failed to set topology labels: %w", err)
+	}
+
+	return nil
+}
+
+// csiVolume is a Proxmox volume provisioned by the CSI driver
+type csiVolume struct {
+	Host string // Zone of the volume, the host its storage is reached through
+	ID   string // Proxmox volume ID, such as 'local-lvm:vm-9999-pvc-1'
+}
+
+// listCSIVolumes lists the Proxmox volumes provisioned by the CSI driver of a tenant
+func (h *JobHandler) listCSIVolumes(ctx context.Context, tenantClient KamajiTenantClient) ([]csiVolume, error) {
+	if h.csi == nil {
+		return nil, nil
+	}
+
+	handles, err := tenantClient.ListVolumeHandles(ctx, CSIDriverName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CSI volumes: %w", err)
+	}
+
+	volumes := make([]csiVolume, 0, len(handles))
+	for _, handle := range handles {
+		volume, err := parseCSIVolume(handle)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+	}
+
+	return volumes, nil
+}
+
+// cleanupCSI deletes the Proxmox volumes provisioned by the CSI driver and the tenant API token
+func (h *JobHandler) cleanupCSI(tenantName string, volumes []csiVolume) error {
+	if h.csi == nil {
+		return nil
+	}
+
+	// Host local storages are only reached through their host
+	for _, volume := range volumes {
+		log.Printf("Deleting CSI volume %s on %s of tenant %s", volume.ID, volume.Host, tenantName)
+		t, err := h.proxmoxCli.DeleteVolume(volume.Host, volume.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete volume %s: %w", volume.ID, err)
+		}
+		if t == nil {
+			continue
+		}
+		if _, err := h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+			return fmt.Errorf("failed to delete volume %s: %w", volume.ID, err)
+		}
+	}
+
+	// The user may not exist if the service creation failed early
+	if err := h.proxmoxCli.DeleteUser(csiUserID(tenantName)); err != nil {
+		log.Printf("Failed to delete CSI user of tenant %s: %v", tenantName, err)
+	}
+
+	return nil
+}
+
+// parseCSIVolume converts a CSI volume handle, such as 'region/zone/storage/disk', to a Proxmox volume on its host
+func parseCSIVolume(handle string) (csiVolume, error) {
+	parts := strings.Split(handle, "/")
+	if len(parts) != 4 || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return csiVolume{}, fmt.Errorf("invalid CSI volume handle %s", handle)
+	}
+	return csiVolume{Host: parts[1], ID: fmt.Sprintf("%s:%s", parts[2], parts[3])}, nil
+}
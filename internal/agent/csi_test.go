@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerProxmoxCSI(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	helmCli := NewMockHelmClient()
+	csiCfg := CSIConfig{Chart: "oci://registry.example.com/charts/proxmox-csi-plugin", Version: "0.3.5", APIURL: "https://pve.example.com:8006", Region: "pve", Storage: "local-lvm"}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(), WithHelmClient(helmCli), WithProxmoxCSI(csiCfg))
+
+	serviceID := "csi-service"
+	serviceName := "csi-cluster"
+	props := &Properties{Nodes: []Node{{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}}}
+
+	// Create the service, the CSI driver is installed with a tenant scoped token
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	vmID := service.Resources.Nodes["node1"]
+
+	user, ok := proxmoxCli.GetUser(csiUserID(serviceName))
+	require.True(t, ok)
+	require.Equal(t, csiRoleID, user.Permissions["/storage/local-lvm"])
+	require.Equal(t, csiRoleID, user.Permissions[fmt.Sprintf("/vms/%d", vmID)])
+
+	kubeConfig, err := kamajiCli.GetTenantKubeConfig(context.Background(), serviceName)
+	require.NoError(t, err)
+	releases, err := helmCli.ListReleases(context.Background(), kubeConfig.Config)
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+	require.Equal(t, csiReleaseName, releases[0].Name)
+	clusters := releases[0].Values["config"].(map[string]any)["clusters"].([]any)
+	require.Equal(t, "https://pve.example.com:8006/api2/json", clusters[0].(map[string]any)["url"])
+	require.Equal(t, user.Token.Secret, clusters[0].(map[string]any)["token_secret"])
+
+	// Starting the nodes labels them with their topology
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	tcp, ok := kamajiCli.GetTenantControlPlane(serviceName)
+	require.True(t, ok)
+	require.Equal(t, map[string]string{TopologyRegionLabel: "pve", TopologyZoneLabel: "test-node"}, tcp.NodeLabels[vmName(serviceName, "node1")])
+
+	// The CSI release survives the Helm releases sync
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	releases, err = helmCli.ListReleases(context.Background(), kubeConfig.Config)
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+
+	// Deleting the service deletes the provisioned disks through their host and the tenant user
+	tcp.Volumes["pve/test-node/local-lvm/vm-9999-pvc-1"] = CSIDriverName
+	tcp.Volumes["pve/other-node/local-lvm/vm-9999-pvc-2"] = CSIDriverName
+	tcp.Volumes["other-driver-volume"] = "other.csi.example.com"
+	proxmoxCli.AddVolume("local-lvm:vm-9999-pvc-1")
+	proxmoxCli.AddHostVolume("other-node", "local-lvm:vm-9999-pvc-2")
+
+	require.NoError(t, fulcrumCli.StopService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.DeleteService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	require.False(t, proxmoxCli.HasVolume("local-lvm:vm-9999-pvc-1"))
+	require.False(t, proxmoxCli.HasVolume("local-lvm:vm-9999-pvc-2"))
+	_, ok = proxmoxCli.GetUser(csiUserID(serviceName))
+	require.False(t, ok)
+}
+
+func TestParseCSIVolume(t *testing.T) {
+	volume, err := parseCSIVolume("pve/node-1/local-lvm/vm-9999-pvc-123")
+	require.NoError(t, err)
+	require.Equal(t, csiVolume{Host: "node-1", ID: "local-lvm:vm-9999-pvc-123"}, volume)
+
+	_, err = parseCSIVolume("local-lvm:vm-9999-pvc-123")
+	require.Error(t, err)
+}
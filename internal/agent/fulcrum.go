@@ -76,14 +76,89 @@ type Node struct {
 
 // Resources represents the resources in a job response
 type Resources struct {
-	ClusterIP  string         `json:"clusterIp,omitempty"`
-	KubeConfig string         `json:"kubeConfig,omitempty"`
-	Nodes      map[string]int `json:"nodes,omitempty"`
+	ClusterIP  string            `json:"clusterIp,omitempty"`
+	KubeConfig string            `json:"kubeConfig,omitempty"`
+	Nodes      map[string]int    `json:"nodes,omitempty"`
+	NodeIPs    map[string]string `json:"nodeIps,omitempty"` // Static addresses of the nodes
+	Hosts      map[string]string `json:"hosts,omitempty"`   // Proxmox hosts the nodes were placed on
+
+	NodeAddresses map[string][]string `json:"nodeAddresses,omitempty"` // Addresses reported by the guest agent of the nodes
+
+	Snippets        map[string]*CloudInitDrive `json:"snippets,omitempty"`        // Stored cloud-init configurations of the nodes not joined yet
+	BootstrapTokens map[string]*BootstrapToken `json:"bootstrapTokens,omitempty"` // Bootstrap tokens of the nodes not joined yet
+
+	LoadBalancerRange string `json:"loadBalancerRange,omitempty"` // Addresses allocated to the tenant LoadBalancer services
+
+	NodeUser          string `json:"nodeUser,omitempty"`          // Login user of the nodes
+	SSHPublicKey      string `json:"sshPublicKey,omitempty"`      // Generated tenant key authorized on the nodes
+	CredentialsSecret string `json:"credentialsSecret,omitempty"` // Management cluster Secret holding the generated private key and password
+}
+
+// setNodeIP records the static address of a node, if any
+func (r *Resources) setNodeIP(nodeID, ip string) {
+	if ip == "" {
+		return
+	}
+	if r.NodeIPs == nil {
+		r.NodeIPs = make(map[string]string)
+	}
+	r.NodeIPs[nodeID] = ip
+}
+
+// setNodeAddresses records the addresses reported by the guest agent of a node
+func (r *Resources) setNodeAddresses(nodeID string, addrs []string) {
+	if r.NodeAddresses == nil {
+		r.NodeAddresses = make(map[string][]string)
+	}
+	r.NodeAddresses[nodeID] = addrs
+}
+
+// setHost records the Proxmox host a node was placed on, if any
+func (r *Resources) setHost(nodeID, host string) {
+	if host == "" {
+		return
+	}
+	if r.Hosts == nil {
+		r.Hosts = make(map[string]string)
+	}
+	r.Hosts[nodeID] = host
+}
+
+// setSnippet records the stored cloud-init configuration of a node
+func (r *Resources) setSnippet(nodeID string, drive *CloudInitDrive) {
+	if r.Snippets == nil {
+		r.Snippets = make(map[string]*CloudInitDrive)
+	}
+	r.Snippets[nodeID] = drive
+}
+
+// setBootstrapToken records the bootstrap token of a node
+func (r *Resources) setBootstrapToken(nodeID string, token *JoinTokenResponse) {
+	if r.BootstrapTokens == nil {
+		r.BootstrapTokens = make(map[string]*BootstrapToken)
+	}
+	r.BootstrapTokens[nodeID] = &BootstrapToken{ID: token.TokenID, Expiration: token.ExpirationTime}
 }
 
 // Properties represents the properties of a service
 type Properties struct {
-	Nodes []Node `json:"nodes"`
+	Nodes   []Node        `json:"nodes"`
+	Addons  []Addon       `json:"addons,omitempty"`
+	Charts  []HelmRelease `json:"charts,omitempty"`
+	Subnet  string        `json:"subnet,omitempty"`  // Subnet the node addresses are allocated from, the agent default if empty
+	Network *Network      `json:"network,omitempty"` // Network the nodes are attached to, the agent default if nil
+
+	HostLabels []string `json:"hostLabels,omitempty"` // Labels of the Proxmox hosts the nodes are placed on
+
+	OS          string `json:"os,omitempty"`          // OS of the nodes, the agent default if empty
+	KubeVersion string `json:"kubeVersion,omitempty"` // Kubernetes version of the control plane and the nodes, DefaultKubeVersion if empty
+
+	SSHKeys        []string `json:"sshKeys,omitempty"`        // SSH public keys authorized on the nodes, besides the agent ones
+	GenerateSSHKey bool     `json:"generateSshKey,omitempty"` // Generate a tenant key pair returned in the resources
+
+	CloudInitTemplate string            `json:"cloudInitTemplate,omitempty"` // Cloud-init template of the nodes, the agent default if empty
+	CloudInitVars     map[string]string `json:"cloudInitVars,omitempty"`     // Extra variables of the cloud-init template
+	UserData          []string          `json:"userData,omitempty"`          // Cloud-config fragments merged into the generated user data
 }
 type Service struct {
 	ID                string         `json:"id"`
@@ -113,8 +188,17 @@ const (
 	MetricTypeVMMemoryUsage       MetricType = "vm.memory.usage"
 	MetricTypeVMDiskUsage         MetricType = "vm.disk.usage"
 	MetricTypeVMNetworkThroughput MetricType = "vm.network.throughput"
+
+	// Quota consumption of a service, reported for the quotaResourceID resource
+	MetricTypeQuotaNodes  MetricType = "quota.nodes"
+	MetricTypeQuotaCPU    MetricType = "quota.cpu"
+	MetricTypeQuotaMemory MetricType = "quota.memory"
+	MetricTypeQuotaDisk   MetricType = "quota.disk"
 )
 
+// quotaResourceID is the resource the quota metrics of a service are reported for
+const quotaResourceID = "quota"
+
 // MetricEntry represents a single metric measurement
 type MetricEntry struct {
 	ExternalID string     `json:"externalId"`
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// DefaultGuestBootTimeout is how long a started node has to answer through the guest agent by default
+	DefaultGuestBootTimeout = 5 * time.Minute
+	// DefaultGuestLogLines is how many lines of each log are collected from a node failing to join by default
+	DefaultGuestLogLines = 50
+)
+
+// guestPollInterval is how often the guest agent is polled while waiting for a node or a command
+const guestPollInterval = 5 * time.Second
+
+// GuestAgentConfig holds the configuration of the QEMU guest agent integration
+type GuestAgentConfig struct {
+	BootTimeout time.Duration // How long a started node has to answer through the guest agent
+	LogLines    int           // Lines of each log collected from a node failing to join
+}
+
+// WithGuestAgent returns an option that follows the nodes through the QEMU guest agent of their template:
+// a started node must boot and complete cloud-init before waiting for it to join, its addresses are reported,
+// and the logs of a node failing to join are collected into the job error.
+func WithGuestAgent(cfg GuestAgentConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.BootTimeout <= 0 {
+			cfg.BootTimeout = DefaultGuestBootTimeout
+		}
+		if cfg.LogLines <= 0 {
+			cfg.LogLines = DefaultGuestLogLines
+		}
+		h.guestAgent = &cfg
+	}
+}
+
+// waitGuest waits for a started node to boot and complete cloud-init, and records the addresses it reports
+func (h *JobHandler) waitGuest(props *Properties, resources *Resources, vmID int, node Node) error {
+	if h.guestAgent == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	err := wait.PollUntilContextTimeout(ctx, guestPollInterval, h.guestAgent.BootTimeout, true, func(ctx context.Context) (bool, error) {
+		return h.proxmoxCli.AgentPing(vmID) == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("node %s did not boot: the guest agent is not responding", node.ID)
+	}
+
+	addrs, err := h.guestAddresses(vmID)
+	if err != nil {
+		return fmt.Errorf("failed to get node %s addresses: %w", node.ID, err)
+	}
+	resources.setNodeAddresses(node.ID, addrs)
+
+	// Flatcar runs its configuration as a systemd unit, whose failure shows in the join logs
+	template, err := h.nodeTemplate(props)
+	if err != nil {
+		return err
+	}
+	if template.OS == OSFlatcar {
+		return nil
+	}
+	status, err := h.guestExec(vmID, []string{"cloud-init", "status", "--wait"}, joinTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to wait for node %s cloud-init: %w", node.ID, err)
+	}
+	// Exit code 2 reports recoverable errors, the configuration was applied
+	if status.ExitCode != 0 && status.ExitCode != 2 {
+		return fmt.Errorf("node %s cloud-init failed: %s%s", node.ID, strings.TrimSpace(status.OutData), h.guestLogs(vmID))
+	}
+	return nil
+}
+
+// guestAddresses returns the global addresses of a node, as reported by its guest agent
+func (h *JobHandler) guestAddresses(vmID int) ([]string, error) {
+	ifaces, err := h.proxmoxCli.AgentNetworkInterfaces(vmID)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, iface := range ifaces {
+		for _, a := range iface.IPAddresses {
+			ip, err := netip.ParseAddr(a.Address)
+			if err != nil || !ip.IsGlobalUnicast() {
+				continue
+			}
+			addrs = append(addrs, ip.String())
+		}
+	}
+	return addrs, nil
+}
+
+// guestExec runs a command in a node through its guest agent and waits for it to exit
+func (h *JobHandler) guestExec(vmID int, command []string, timeout time.Duration) (*GuestExecStatus, error) {
+	pid, err := h.proxmoxCli.AgentExec(vmID, command)
+	if err != nil {
+		return nil, err
+	}
+	var status *GuestExecStatus
+	err = wait.PollUntilContextTimeout(context.Background(), guestPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		status, err = h.proxmoxCli.AgentExecStatus(vmID, pid)
+		if err != nil {
+			return false, err
+		}
+		return status.Exited, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("command %s did not complete: %w", command[0], err)
+	}
+	return status, nil
+}
+
+// guestLogs collects the end of the bootstrap logs of a node through its guest agent, formatted to be appended
+// to an error. The logs that can not be read are skipped.
+func (h *JobHandler) guestLogs(vmID int) string {
+	if h.guestAgent == nil {
+		return ""
+	}
+
+	lines := strconv.Itoa(h.guestAgent.LogLines)
+	commands := [][]string{
+		{"tail", "-n", lines, "/var/log/cloud-init-output.log"},
+		{"journalctl", "--no-pager", "-n", lines, "-u", "kubeadm-join", "-u", "kubelet"},
+	}
+	var logs strings.Builder
+	for _, command := range commands {
+		status, err := h.guestExec(vmID, command, 1*time.Minute)
+		if err != nil {
+			log.Printf("Failed to collect VM %d logs with %s: %v", vmID, command[0], err)
+			continue
+		}
+		if out := strings.TrimSpace(status.OutData); out != "" {
+			fmt.Fprintf(&logs, "\n--- %s\n%s", strings.Join(command, " "), out)
+		}
+	}
+	return logs.String()
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerGuestAgent(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(),
+		WithGuestAgent(GuestAgentConfig{LogLines: 10}))
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}
+
+	// The addresses reported by a started node are recorded once cloud-init completed
+	require.NoError(t, fulcrumCli.CreateService("guest-service", "guest-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.StartService("guest-service"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err := fulcrumCli.GetService("guest-service")
+	require.NoError(t, err)
+	vmID := service.Resources.Nodes["node1"]
+	vm, ok := proxmoxCli.GetVM(vmID)
+	require.True(t, ok)
+	require.Equal(t, []string{"cloud-init status --wait"}, vm.GuestCommands)
+	addrs := service.Resources.NodeAddresses["node1"]
+	require.Len(t, addrs, 1)
+	require.Regexp(t, `^192\.168\.`, addrs[0])
+
+	// A failed cloud-init fails the job with the logs of the node
+	proxmoxCli.SetGuestExecResult("cloud-init status --wait", GuestExecStatus{ExitCode: 1, OutData: "status: error\n"})
+	proxmoxCli.SetGuestExecResult("tail -n 10 /var/log/cloud-init-output.log", GuestExecStatus{OutData: "E: Unable to locate package kubelet\n"})
+	require.NoError(t, fulcrumCli.CreateService("failed-service", "failed-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.StartService("failed-service"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	failed := fulcrumCli.PullFailedJobs()
+	require.Len(t, failed, 1)
+	require.Contains(t, failed[0].ErrorMessage, "node node1 cloud-init failed: status: error")
+	require.Contains(t, failed[0].ErrorMessage, "--- tail -n 10 /var/log/cloud-init-output.log\nE: Unable to locate package kubelet")
+}
+
+func TestGuestAddresses(t *testing.T) {
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	vm := proxmoxCli.AddVM(200, "node-vm", VMStatusRunning, 2, 2048)
+	vm.GuestInterfaces = []GuestNetworkInterface{
+		{Name: "lo", IPAddresses: []GuestIPAddress{{Type: "ipv4", Address: "127.0.0.1"}, {Type: "ipv6", Address: "::1"}}},
+		{Name: "eth0", IPAddresses: []GuestIPAddress{
+			{Type: "ipv4", Address: "10.0.0.5"},
+			{Type: "ipv6", Address: "fe80::be24:11ff:fe00:1"},
+			{Type: "ipv6", Address: "2001:db8::5"},
+		}},
+	}
+	jobHandler := NewJobHandler(NewMockFulcrumClient(), proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient())
+
+	// Loopback and link-local addresses are skipped
+	addrs, err := jobHandler.guestAddresses(200)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.5", "2001:db8::5"}, addrs)
+
+	vm.GuestAgentDown = true
+	_, err = jobHandler.guestAddresses(200)
+	require.ErrorContains(t, err, "guest agent is not running")
+}
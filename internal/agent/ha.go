@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// HA states requested for the worker VMs
+const (
+	HAStateStarted = "started"
+	HAStateStopped = "stopped"
+)
+
+// HAConfig holds the configuration of the Proxmox HA registration of the worker VMs
+type HAConfig struct {
+	Group string // HA group the VMs are registered in
+}
+
+// WithHA returns an option that registers the worker VMs as HA resources of the group,
+// so that Proxmox restarts them on another host when theirs fails.
+// The VMs are then started and stopped by requesting their HA state.
+func WithHA(cfg HAConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.ha = &cfg
+	}
+}
+
+// registerHA registers a new VM as an HA resource, stopped until its node is started
+func (h *JobHandler) registerHA(vmID int) error {
+	if h.ha == nil {
+		return nil
+	}
+	if err := h.proxmoxCli.SetHAResource(vmID, h.ha.Group, HAStateStopped); err != nil {
+		return fmt.Errorf("failed to register VM in HA group %s: %w", h.ha.Group, err)
+	}
+	return nil
+}
+
+// unregisterHA unregisters a VM from HA, which Proxmox requires before deleting it
+func (h *JobHandler) unregisterHA(vmID int) error {
+	if h.ha == nil {
+		return nil
+	}
+	if err := h.proxmoxCli.RemoveHAResource(vmID); err != nil {
+		return fmt.Errorf("failed to unregister VM from HA: %w", err)
+	}
+	return nil
+}
+
+// requestHAState requests the HA state of a VM, registering it if needed, and waits for the HA manager
+// to bring the VM to the matching status
+func (h *JobHandler) requestHAState(vmID int, state string, status VMStatus) error {
+	if err := h.proxmoxCli.SetHAResource(vmID, h.ha.Group, state); err != nil {
+		return fmt.Errorf("failed to request HA state %s: %w", state, err)
+	}
+
+	err := wait.PollUntilContextTimeout(context.Background(), 2*time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+		i, err := h.proxmoxCli.GetVMInfo(vmID)
+		if err != nil {
+			return false, nil // The VM may be moving to another host
+		}
+		return i.Status == status, nil
+	})
+	if err != nil {
+		return fmt.Errorf("VM did not reach HA state %s: %w", state, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerHA(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), WithHA(HAConfig{Group: "workers"}))
+
+	serviceID := "ha-service"
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}
+	require.NoError(t, fulcrumCli.CreateService(serviceID, "ha-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	// The new VMs are registered stopped in the HA group
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	vmID := service.Resources.Nodes["node1"]
+	ha, ok := proxmoxCli.GetHAResource(vmID)
+	require.True(t, ok)
+	require.Equal(t, MockHAResource{Group: "workers", State: HAStateStopped}, *ha)
+
+	// Starting and stopping the service requests the HA state of the VMs
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	ha, ok = proxmoxCli.GetHAResource(vmID)
+	require.True(t, ok)
+	require.Equal(t, HAStateStarted, ha.State)
+	vm, ok := proxmoxCli.GetVM(vmID)
+	require.True(t, ok)
+	require.Equal(t, VMStatusRunning, vm.Status)
+
+	require.NoError(t, fulcrumCli.StopService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	ha, ok = proxmoxCli.GetHAResource(vmID)
+	require.True(t, ok)
+	require.Equal(t, HAStateStopped, ha.State)
+	require.Equal(t, VMStatusStopped, vm.Status)
+
+	// The VMs are unregistered before being deleted
+	require.NoError(t, fulcrumCli.DeleteService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	_, ok = proxmoxCli.GetHAResource(vmID)
+	require.False(t, ok)
+	_, ok = proxmoxCli.GetVM(vmID)
+	require.False(t, ok)
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// HelmRelease represents a Helm chart release installed into a tenant cluster
+type HelmRelease struct {
+	Name      string         `json:"name"`
+	Namespace string         `json:"namespace"`
+	Chart     string         `json:"chart"` // Local chart directory or archive, or oci:// reference
+	Version   string         `json:"version,omitempty"`
+	Values    map[string]any `json:"values,omitempty"`
+}
+
+// HelmClient defines the interface for managing Helm releases in a cluster
+type HelmClient interface {
+	// InstallRelease installs the release, or upgrades it if it already exists
+	InstallRelease(ctx context.Context, kubeConfig string, release HelmRelease) error
+
+	// UninstallRelease uninstalls a release
+	UninstallRelease(ctx context.Context, kubeConfig string, name, namespace string) error
+
+	// ListReleases lists the releases installed by the agent
+	ListReleases(ctx context.Context, kubeConfig string) ([]HelmRelease, error)
+}
+
+// isSystemRelease reports whether a Helm release is managed by the agent itself rather than by the service properties
+func isSystemRelease(namespace, name string) bool {
+	return (namespace == csiNamespace && name == csiReleaseName) ||
+		(namespace == lbNamespace && name == lbReleaseName)
+}
+
+// validateHelmReleases checks the Helm releases before any resource is created
+func (h *JobHandler) validateHelmReleases(releases []HelmRelease) error {
+	if len(releases) == 0 {
+		return nil
+	}
+	if h.helmCli == nil {
+		return fmt.Errorf("helm charts are not enabled on this agent")
+	}
+
+	seen := make(map[string]bool, len(releases))
+	for _, release := range releases {
+		if release.Name == "" || release.Namespace == "" || release.Chart == "" {
+			return fmt.Errorf("helm release requires a name, a namespace and a chart")
+		}
+		key := release.Namespace + "/" + release.Name
+		if isSystemRelease(release.Namespace, release.Name) {
+			return fmt.Errorf("helm release %s is reserved", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("helm release %s is declared more than once", key)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// syncHelmReleases installs, upgrades and uninstalls the tenant Helm releases to match the target ones
+func (h *JobHandler) syncHelmReleases(ctx context.Context, tenantName string, releases []HelmRelease) error {
+	if err := h.validateHelmReleases(releases); err != nil {
+		return err
+	}
+	if h.helmCli == nil {
+		return nil
+	}
+
+	kubeConfig, err := h.kamajiCli.GetTenantKubeConfig(ctx, tenantName)
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	installed, err := h.helmCli.ListReleases(ctx, kubeConfig.Config)
+	if err != nil {
+		return fmt.Errorf("failed to list helm releases: %w", err)
+	}
+
+	target := make(map[string]bool, len(releases))
+	for _, release := range releases {
+		target[release.Namespace+"/"+release.Name] = true
+
+		log.Printf("Installing helm release %s/%s of chart %s to tenant %s", release.Namespace, release.Name, release.Chart, tenantName)
+		if err := h.helmCli.InstallRelease(ctx, kubeConfig.Config, release); err != nil {
+			return fmt.Errorf("failed to install helm release %s/%s: %w", release.Namespace, release.Name, err)
+		}
+	}
+
+	for _, release := range installed {
+		if target[release.Namespace+"/"+release.Name] || isSystemRelease(release.Namespace, release.Name) {
+			continue
+		}
+
+		log.Printf("Uninstalling helm release %s/%s from tenant %s", release.Namespace, release.Name, tenantName)
+		if err := h.helmCli.UninstallRelease(ctx, kubeConfig.Config, release.Name, release.Namespace); err != nil {
+			return fmt.Errorf("failed to uninstall helm release %s/%s: %w", release.Namespace, release.Name, err)
+		}
+	}
+
+	return nil
+}
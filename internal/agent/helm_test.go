@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerHelmReleases(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	helmCli := NewMockHelmClient()
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(), WithHelmClient(helmCli))
+
+	serviceID := "helm-service"
+	serviceName := "helm-cluster"
+	nodes := []Node{{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}}
+	ingress := HelmRelease{Name: "ingress", Namespace: "ingress-nginx", Chart: "oci://registry.example.com/charts/ingress-nginx", Version: "4.11.0"}
+	certManager := HelmRelease{Name: "cert-manager", Namespace: "cert-manager", Chart: "cert-manager", Values: map[string]any{"crds": map[string]any{"enabled": true}}}
+
+	// Create the service with two releases
+	props := &Properties{Nodes: nodes, Charts: []HelmRelease{ingress, certManager}}
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	kubeConfig, err := kamajiCli.GetTenantKubeConfig(context.Background(), serviceName)
+	require.NoError(t, err)
+	releases, err := helmCli.ListReleases(context.Background(), kubeConfig.Config)
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+
+	// Upgrade the ingress release and uninstall cert-manager
+	ingress.Version = "4.12.0"
+	props = &Properties{Nodes: nodes, Charts: []HelmRelease{ingress}}
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Empty(t, fulcrumCli.PullFailedJobs())
+
+	releases, err = helmCli.ListReleases(context.Background(), kubeConfig.Config)
+	require.NoError(t, err)
+	require.Equal(t, []HelmRelease{ingress}, releases)
+
+	// Releases without a namespace are rejected
+	props = &Properties{Nodes: nodes, Charts: []HelmRelease{{Name: "broken", Chart: "broken"}}}
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+}
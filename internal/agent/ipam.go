@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// IPRange represents a range of consecutive IP addresses
+type IPRange struct {
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// String returns the range in the 'first-last' notation
+func (r IPRange) String() string {
+	return fmt.Sprintf("%s-%s", r.First, r.Last)
+}
+
+// IPAM defines the interface for allocating addresses from the agent address pools
+type IPAM interface {
+	// Allocate allocates a range of size addresses of the pool to the owner.
+	// The current allocation of the owner is returned if it already has one.
+	Allocate(ctx context.Context, pool string, owner string, size int) (*IPRange, error)
+
+	// Release releases the addresses allocated to the owner, if any
+	Release(ctx context.Context, pool string, owner string) error
+}
+
+// WithIPAM returns an option that allocates the service addresses through the given IPAM
+func WithIPAM(ipam IPAM) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.ipam = ipam
+	}
+}
@@ -2,8 +2,11 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"sync"
 	"time"
 
 	"fulcrumproject.org/kube-agent/internal/cloudinit"
@@ -12,12 +15,49 @@ import (
 
 // JobHandler processes jobs from the Fulcrum Core job queue
 type JobHandler struct {
-	templateID int
-	ciPath     string
-	fulcrumCli FulcrumClient
-	proxmoxCli ProxmoxClient
-	kamajiCli  KamajiClient
-	sshCli     SSHClient
+	templateID   int
+	fulcrumCli   FulcrumClient
+	proxmoxCli   ProxmoxClient
+	kamajiCli    KamajiClient
+	helmCli      HelmClient
+	addonCatalog AddonCatalog
+	csi          *CSIConfig
+	ipam         IPAM
+	loadBalancer *LoadBalancerConfig
+	subnets      []Subnet
+	network      *NetworkConfig
+	credentials  NodeCredentialsConfig
+	bootstrap    BootstrapConfig
+	cloudInit    *CloudInitConfig
+	snippets     SnippetStore
+	placement    *PlacementConfig
+	ha           *HAConfig
+	capacity     *CapacityConfig
+	quotas       *QuotaConfig
+	clones       *cloneSettings
+	pool         *vmPool
+	templates    *TemplateRegistry
+	guestAgent   *GuestAgentConfig
+	shutdown     ShutdownConfig
+	parallel     int           // Nodes provisioned concurrently
+	cloneSlots   chan struct{} // Clone tasks running concurrently, unlimited if nil
+}
+
+// JobHandlerOption is a function type that configures a JobHandler
+type JobHandlerOption func(*JobHandler)
+
+// WithHelmClient returns an option that enables the tenant Helm releases through the given client
+func WithHelmClient(helmCli HelmClient) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.helmCli = helmCli
+	}
+}
+
+// WithAddonCatalog returns an option that enables the tenant addons from the given catalog
+func WithAddonCatalog(catalog AddonCatalog) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.addonCatalog = catalog
+	}
 }
 
 // JobResponse represents the response for a job
@@ -34,15 +74,35 @@ func NewJobHandler(
 	ciPath string,
 	kamajiCli KamajiClient,
 	sshCli SSHClient,
+	options ...JobHandlerOption,
 ) *JobHandler {
-	return &JobHandler{
+	h := &JobHandler{
 		templateID: templateID,
-		ciPath:     ciPath,
 		fulcrumCli: fulcrumCli,
 		proxmoxCli: proxmoxCli,
 		kamajiCli:  kamajiCli,
-		sshCli:     sshCli,
+		credentials: NodeCredentialsConfig{
+			User: DefaultNodeUser,
+		},
+		bootstrap: BootstrapConfig{
+			PackageRepository: DefaultPackageRepository,
+			TokenTTL:          DefaultBootstrapTokenTTL,
+		},
+		shutdown: ShutdownConfig{
+			Timeout: DefaultShutdownTimeout,
+		},
+	}
+
+	if sshCli != nil {
+		h.snippets = &sshSnippetStore{cli: sshCli, path: ciPath}
 	}
+
+	// Apply user-provided options
+	for _, option := range options {
+		option(h)
+	}
+
+	return h
 }
 
 // PollAndProcessJobs polls for pending jobs and processes them
@@ -120,10 +180,49 @@ func (h *JobHandler) handleServiceCreate(job *Job) (*JobResponse, error) {
 	}
 
 	tenantName := job.Service.Name
+
+	// Validate the addons and charts before creating any resource
+	props := job.Service.TargetProperties
+	if props == nil {
+		props = &Properties{}
+	}
+	addons := props.Addons
+	charts := props.Charts
+	if _, err := h.nodeSubnet(props.Subnet); err != nil {
+		return nil, err
+	}
+	if _, err := h.serviceNetwork(props); err != nil {
+		return nil, err
+	}
+	if err := h.validatePlacement(props); err != nil {
+		return nil, err
+	}
+	if _, err := h.nodeTemplate(props); err != nil {
+		return nil, err
+	}
+	if err := h.validateAddons(addons, tenantName); err != nil {
+		return nil, err
+	}
+	if err := h.validateHelmReleases(charts); err != nil {
+		return nil, err
+	}
+	if _, err := normalizeSSHKeys(props.SSHKeys); err != nil {
+		return nil, err
+	}
+	if err := h.validateCloudInit(props); err != nil {
+		return nil, err
+	}
+	if err := h.checkQuotas(job, props); err != nil {
+		return nil, err
+	}
+	if err := h.checkCapacity(props, props.Nodes); err != nil {
+		return nil, err
+	}
+
 	log.Printf("Creating tenant control plane: %s", tenantName)
 
 	// Create tenant control plane
-	err := h.kamajiCli.CreateTenantControlPlane(ctx, tenantName, "v1.30.2", 1)
+	err := h.kamajiCli.CreateTenantControlPlane(ctx, tenantName, kubeVersion(props), 1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create tenant control plane: %w", err)
 	}
@@ -146,6 +245,39 @@ func (h *JobHandler) handleServiceCreate(job *Job) (*JobResponse, error) {
 		return nil, fmt.Errorf("failed to apply Calico resources: %w", err)
 	}
 
+	// Install addons
+	err = h.syncAddons(ctx, tenantClient, tenantName, addons)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install addons: %w", err)
+	}
+
+	// Install Helm charts
+	err = h.syncHelmReleases(ctx, tenantName, charts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install helm charts: %w", err)
+	}
+
+	// Install the Proxmox CSI driver
+	err = h.deployCSI(ctx, tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy CSI driver: %w", err)
+	}
+
+	// Install the load balancer
+	lbRange, err := h.deployLoadBalancer(ctx, tenantClient, tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy load balancer: %w", err)
+	}
+	if lbRange != nil {
+		resp.Resources.LoadBalancerRange = lbRange.String()
+	}
+
+	// Isolate the tenant nodes
+	err = h.deployFirewall(tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy firewall: %w", err)
+	}
+
 	// Get kubeconfig
 	kubeConfig, err := h.kamajiCli.GetTenantKubeConfig(ctx, tenantName)
 	if err != nil {
@@ -156,15 +288,15 @@ func (h *JobHandler) handleServiceCreate(job *Job) (*JobResponse, error) {
 	resp.Resources.ClusterIP = kubeConfig.Endpoint
 	resp.Resources.KubeConfig = kubeConfig.Config
 
+	// Generate the node credentials
+	access, err := h.prepareNodeAccess(ctx, tenantName, props, resp.Resources)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create nodes if specified in the job
-	if job.Service.TargetProperties != nil && job.Service.TargetProperties.Nodes != nil {
-		for _, node := range job.Service.TargetProperties.Nodes {
-			vmID, err := h.createVM(ctx, tenantName, node)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create node %s: %w", node.ID, err)
-			}
-			resp.Resources.Nodes[node.ID] = vmID
-		}
+	if err := h.provisionNodes(ctx, props, access, tenantName, props.Nodes, resp.Resources); err != nil {
+		return nil, err
 	}
 
 	// Set external ID
@@ -208,6 +340,27 @@ func (h *JobHandler) handleServiceUpdate(job *Job, startStop bool) (*JobResponse
 		targetNodesMap[node.ID] = node
 	}
 
+	// Moving the nodes to another subnet or network would require readdressing them
+	if job.Service.TargetProperties.Subnet != job.Service.CurrentProperties.Subnet {
+		return nil, fmt.Errorf("changing subnet is not supported")
+	}
+	if !reflect.DeepEqual(job.Service.TargetProperties.Network, job.Service.CurrentProperties.Network) {
+		return nil, fmt.Errorf("changing network is not supported")
+	}
+	// The nodes of a service share the OS and the version of the control plane
+	if job.Service.TargetProperties.OS != job.Service.CurrentProperties.OS {
+		return nil, fmt.Errorf("changing OS is not supported")
+	}
+	if kubeVersion(job.Service.TargetProperties) != kubeVersion(job.Service.CurrentProperties) {
+		return nil, fmt.Errorf("changing Kubernetes version is not supported")
+	}
+	subnet := job.Service.CurrentProperties.Subnet
+
+	// The cloud-init configuration of the new nodes is checked before changing anything
+	if err := h.validateCloudInit(job.Service.TargetProperties); err != nil {
+		return nil, err
+	}
+
 	// Check if there are changes in the node size
 	for _, targetNode := range targetNodes {
 		currentNode, exists := currentNodesMap[targetNode.ID]
@@ -240,6 +393,14 @@ func (h *JobHandler) handleServiceUpdate(job *Job, startStop bool) (*JobResponse
 		}
 	}
 
+	if err := h.checkQuotas(job, job.Service.TargetProperties); err != nil {
+		return nil, err
+	}
+	// The removed nodes are deleted after the new ones are created, they do not free capacity for them
+	if err := h.checkCapacity(job.Service.TargetProperties, nodesToAdd); err != nil {
+		return nil, err
+	}
+
 	tenantName := job.Service.Name
 
 	// Get tenant client to manage worker nodes
@@ -248,13 +409,36 @@ func (h *JobHandler) handleServiceUpdate(job *Job, startStop bool) (*JobResponse
 		return nil, fmt.Errorf("failed to get tenant client: %w", err)
 	}
 
+	// Install, upgrade or remove addons
+	if err := h.syncAddons(ctx, tenantClient, tenantName, job.Service.TargetProperties.Addons); err != nil {
+		return nil, fmt.Errorf("failed to update addons: %w", err)
+	}
+
+	// Install, upgrade or uninstall Helm charts
+	if err := h.syncHelmReleases(ctx, tenantName, job.Service.TargetProperties.Charts); err != nil {
+		return nil, fmt.Errorf("failed to update helm charts: %w", err)
+	}
+
+	// Make sure the load balancer is deployed, services created before it was enabled get one too
+	lbRange, err := h.deployLoadBalancer(ctx, tenantClient, tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy load balancer: %w", err)
+	}
+	if lbRange != nil {
+		resp.Resources.LoadBalancerRange = lbRange.String()
+	}
+
+	// Generate the credentials requested since the last update, the new keys only apply to the new nodes
+	access, err := h.prepareNodeAccess(ctx, tenantName, job.Service.TargetProperties, resp.Resources)
+	if err != nil {
+		return nil, err
+	}
+
 	// Add new nodes
+	if err := h.provisionNodes(ctx, job.Service.TargetProperties, access, tenantName, nodesToAdd, resp.Resources); err != nil {
+		return nil, err
+	}
 	for _, targetNode := range nodesToAdd {
-		vmID, err := h.createVM(ctx, tenantName, targetNode)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create node %s: %w", targetNode.ID, err)
-		}
-		resp.Resources.Nodes[targetNode.ID] = vmID
 		if startStop && targetNode.Status == NodeStatusOn {
 			nodesToStart = append(nodesToStart, targetNode)
 		}
@@ -272,8 +456,23 @@ func (h *JobHandler) handleServiceUpdate(job *Job, startStop bool) (*JobResponse
 			if err := tenantClient.DeleteWorkerNode(ctx, vmName(job.Service.Name, currentNode.ID)); err != nil {
 				return nil, fmt.Errorf("failed to delete worker node %s: %w", currentNode.ID, err)
 			}
+			// Revoke the bootstrap token and delete the cloud-init configuration of a node that never joined
+			if err := h.revokeJoinToken(ctx, tenantClient, resp.Resources, currentNode.ID); err != nil {
+				return nil, fmt.Errorf("failed to clean up node %s: %w", currentNode.ID, err)
+			}
+			if err := h.removeSnippet(resp.Resources, vmID, tenantName, currentNode.ID, false); err != nil {
+				return nil, fmt.Errorf("failed to clean up node %s: %w", currentNode.ID, err)
+			}
+			// Release the node address
+			h.firewallRemoveNode(tenantName, resp.Resources.NodeIPs[currentNode.ID])
+			if err := h.releaseNodeIP(ctx, subnet, tenantName, currentNode.ID); err != nil {
+				return nil, fmt.Errorf("failed to release node %s address: %w", currentNode.ID, err)
+			}
 			// Remove from resources
 			delete(resp.Resources.Nodes, currentNode.ID)
+			delete(resp.Resources.NodeIPs, currentNode.ID)
+			delete(resp.Resources.Hosts, currentNode.ID)
+			delete(resp.Resources.NodeAddresses, currentNode.ID)
 		}
 	}
 
@@ -281,7 +480,7 @@ func (h *JobHandler) handleServiceUpdate(job *Job, startStop bool) (*JobResponse
 	for _, currentNode := range nodesToStart {
 		if vmID, ok := resp.Resources.Nodes[currentNode.ID]; ok {
 			// Start the VM
-			err := h.startVMAndWaitJoin(vmID, job.Service.Name, currentNode.ID)
+			err := h.startVMAndWaitJoin(job.Service.TargetProperties, resp.Resources, vmID, job.Service.Name, currentNode)
 			if err != nil {
 				return nil, fmt.Errorf("failed to start node %s: %w", currentNode.ID, err)
 			}
@@ -303,7 +502,7 @@ func (h *JobHandler) handleServiceUpdate(job *Job, startStop bool) (*JobResponse
 func (h *JobHandler) handleServiceStart(job *Job) (*JobResponse, error) {
 	err := iterateCurrNodes(job, func(node Node, vmID int) error {
 		if node.Status == NodeStatusOn {
-			err := h.startVMAndWaitJoin(vmID, job.Service.Name, node.ID)
+			err := h.startVMAndWaitJoin(job.Service.CurrentProperties, job.Service.Resources, vmID, job.Service.Name, node)
 			if err != nil {
 				return fmt.Errorf("failed to start node %s: %w", node.ID, err)
 			}
@@ -345,26 +544,73 @@ func (h *JobHandler) handleServiceDelete(job *Job) (*JobResponse, error) {
 		return nil, fmt.Errorf("failed to get tenant client: %w", err)
 	}
 
+	// Collect the CSI volumes while the tenant cluster is still reachable
+	csiVolumes, err := h.listCSIVolumes(ctx, tenantCli)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean up every node, the failures are reported once all were attempted
+	var nodeErrs []error
 	iterateCurrNodes(job, func(node Node, vmID int) error {
-		// Stop and delete the VM
-		if err := h.deleteVM(vmID); err != nil {
-			return err
-		}
-		// Delete the node from the tenant control plane
-		if err := tenantCli.DeleteWorkerNode(ctx, node.ID); err != nil {
-			return err
+		if err := h.deleteNode(ctx, tenantCli, job, node, vmID); err != nil {
+			nodeErrs = append(nodeErrs, fmt.Errorf("failed to delete node %s: %w", node.ID, err))
 		}
 		return nil
 	})
+	if len(nodeErrs) > 0 {
+		return nil, errors.Join(nodeErrs...)
+	}
+
+	// Delete the CSI volumes once no VM has them attached anymore
+	if err := h.cleanupCSI(tenantName, csiVolumes); err != nil {
+		return nil, fmt.Errorf("failed to clean up CSI volumes: %w", err)
+	}
 
 	// Delete tenant control plane
 	if err := h.kamajiCli.DeleteTenantControlPlane(ctx, tenantName); err != nil {
 		return nil, fmt.Errorf("failed to delete tenant control plane: %w", err)
 	}
 
+	// Remove the tenant firewall once no VM uses it anymore
+	if err := h.removeFirewall(tenantName); err != nil {
+		return nil, err
+	}
+
+	// Release the tenant addresses once nothing announces them anymore
+	if err := h.releaseLoadBalancer(ctx, tenantName); err != nil {
+		return nil, err
+	}
+
+	if err := h.kamajiCli.DeleteNodeCredentials(ctx, tenantName); err != nil {
+		return nil, fmt.Errorf("failed to delete node credentials: %w", err)
+	}
+
 	return &JobResponse{}, nil
 }
 
+// deleteNode deletes the VM of a node of a deleted service, its tenant cluster node and everything it holds
+func (h *JobHandler) deleteNode(ctx context.Context, tenantCli KamajiTenantClient, job *Job, node Node, vmID int) error {
+	tenantName := job.Service.Name
+
+	// Stop and delete the VM
+	if err := h.deleteVM(vmID); err != nil {
+		return err
+	}
+	// Delete the node from the tenant control plane
+	if err := tenantCli.DeleteWorkerNode(ctx, vmName(tenantName, node.ID)); err != nil {
+		return err
+	}
+	// Delete the cloud-init configuration of a node that never joined, and release the node address
+	if job.Service.Resources != nil {
+		if err := h.removeSnippet(job.Service.Resources, vmID, tenantName, node.ID, false); err != nil {
+			return err
+		}
+		h.firewallRemoveNode(tenantName, job.Service.Resources.NodeIPs[node.ID])
+	}
+	return h.releaseNodeIP(ctx, job.Service.CurrentProperties.Subnet, tenantName, node.ID)
+}
+
 // Helper methods
 
 // iterateCurrNodes iterates over the current nodes in the job and applies the provided function
@@ -390,105 +636,237 @@ func iterateCurrNodes(job *Job, callback func(node Node, vmID int) error) error
 	return nil
 }
 
-// createVM creates a new node for a service
-func (h *JobHandler) createVM(ctx context.Context, serviceName string, node Node) (int, error) {
-	vmName := vmName(serviceName, node.ID)
-	vmID := h.generateVMID(serviceName, node.ID)
+// provisionNode creates the VM of a node and attaches it to the service network, recording it in the resources.
+// The nodes provisioned concurrently share the resources and the lock guarding them.
+func (h *JobHandler) provisionNode(ctx context.Context, props *Properties, access *nodeAccess, serviceName string, node Node, resources *Resources, mu *sync.Mutex) error {
+	network, err := h.serviceNetwork(props)
+	if err != nil {
+		return err
+	}
 
-	// Get node configuration based on size
-	cores, memory := node.Size.Attrs()
+	tenantClient, err := h.kamajiCli.GetTenantClient(ctx, serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant client: %w", err)
+	}
 
-	// Create VM by cloning from template
-	t, err := h.proxmoxCli.CloneVM(h.templateID, vmID, vmName) // Assume 100 is template ID
+	// The host is recorded right away, so that the nodes placed next take it into account.
+	// The address is allocated first, as the seed ISOs carry the network configuration.
+	mu.Lock()
+	host, addr, err := h.placeAndAddressNode(ctx, props, resources, serviceName, node)
+	mu.Unlock()
 	if err != nil {
-		return 0, fmt.Errorf("failed to clone VM: %w", err)
+		return err
+	}
+	release := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(resources.Hosts, node.ID)
+		if addr != nil {
+			if err := h.releaseNodeIP(ctx, props.Subnet, serviceName, node.ID); err != nil {
+				log.Printf("Failed to release node %s address: %v", node.ID, err)
+			}
+		}
 	}
 
-	_, err = h.proxmoxCli.WaitForTask(t.TaskID, 10*time.Minute)
+	// Each node joins with its own bootstrap token
+	token, err := h.issueJoinToken(ctx, tenantClient, serviceName, node.ID)
 	if err != nil {
-		return 0, fmt.Errorf("failed to clone VM: %w", err)
+		release()
+		return fmt.Errorf("failed to create node %s: %w", node.ID, err)
 	}
 
-	// Generate join token for the node to join the cluster
-	tenantClient, err := h.kamajiCli.GetTenantClient(ctx, serviceName)
+	vmID, drive, err := h.createVM(ctx, props, access, serviceName, node, host, addr, token.FullToken)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get tenant client: %w", err)
+		h.deleteJoinToken(ctx, tenantClient, token.TokenID)
+		release()
+		return fmt.Errorf("failed to create node %s: %w", node.ID, err)
 	}
+	mu.Lock()
+	resources.Nodes[node.ID] = vmID
+	resources.setSnippet(node.ID, drive)
+	resources.setBootstrapToken(node.ID, token)
+	mu.Unlock()
 
-	joinToken, err := tenantClient.CreateJoinToken(ctx, serviceName, 24) // 24 hours validity
+	if err := h.configureNIC(vmID, network); err != nil {
+		return fmt.Errorf("failed to configure node %s network: %w", node.ID, err)
+	}
+	ip, err := h.configureNodeIP(vmID, addr)
+	if err != nil {
+		return fmt.Errorf("failed to assign node %s address: %w", node.ID, err)
+	}
+	mu.Lock()
+	resources.setNodeIP(node.ID, ip)
+	err = h.firewallAddNode(serviceName, vmID, ip)
+	mu.Unlock()
 	if err != nil {
-		return 0, fmt.Errorf("failed to create join token: %w", err)
+		return fmt.Errorf("failed to isolate node %s: %w", node.ID, err)
 	}
+	if err := h.registerHA(vmID); err != nil {
+		return fmt.Errorf("failed to register node %s: %w", node.ID, err)
+	}
+
+	return nil
+}
+
+// placeAndAddressNode chooses the host of a node and allocates its address
+func (h *JobHandler) placeAndAddressNode(ctx context.Context, props *Properties, resources *Resources, serviceName string, node Node) (string, *nodeAddress, error) {
+	host, err := h.placeNode(props, resources, serviceName, node)
+	if err != nil {
+		return "", nil, err
+	}
+	addr, err := h.allocateNodeIP(ctx, props.Subnet, serviceName, node.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to assign node %s address: %w", node.ID, err)
+	}
+	resources.setHost(node.ID, host)
+	return host, addr, nil
+}
+
+// nodeSeed renders the cloud-init configuration of a node joining with the bootstrap token
+func (h *JobHandler) nodeSeed(ctx context.Context, props *Properties, access *nodeAccess, serviceName string, node Node, addr *nodeAddress, joinToken string) (*CloudInitSeed, error) {
+	vmName := vmName(serviceName, node.ID)
 
 	// Get CA cert hash for the cluster
 	caCertHash, err := h.kamajiCli.GetTenantCAHash(ctx, serviceName)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get CA cert hash: %w", err)
+		return nil, fmt.Errorf("failed to get CA cert hash: %w", err)
 	}
 
 	// Get kubeconfig for the cluster
 	kubeConfig, err := h.kamajiCli.GetTenantKubeConfig(ctx, serviceName)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get kubeconfig: %w", err)
+		return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	// Nodes install the packages of the control plane version
+	kubeVersion := kubeVersion(props)
+	packageRepository, packageVersion, err := h.packageSource(kubeVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate cloud-init configuration
 	cloudInitParams := cloudinit.CloudInitParams{
 		Hostname:       vmName,
 		FQDN:           vmName,
-		Username:       "ubuntu",
-		Password:       "ubuntu",
-		SSHKeys:        []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBeZfPGgiVw7zMpOhs7RQMCL3+jxfA8U1iiGSiYDSXWy kube@testudo"},
+		Username:       access.user,
+		PasswordHash:   access.passwordHash,
+		SSHKeys:        access.sshKeys,
 		ExpirePassword: false,
 		PackageUpgrade: true,
-		JoinURL:        kubeConfig.Endpoint,
-		JoinToken:      joinToken.FullToken,
+		JoinURL:        joinEndpoint(kubeConfig.Endpoint),
+		JoinToken:      joinToken,
 		CACertHash:     caCertHash,
-		KubeVersion:    "v1.30.2",
+		KubeVersion:    kubeVersion,
+
+		PackageRepository: packageRepository,
+		PackageVersion:    packageVersion,
+		RegistryMirror:    h.bootstrap.RegistryMirror,
+
+		Vars: props.CloudInitVars,
 	}
 
 	// Generate cloud-init config
-	cloudInitContent, err := cloudinit.GenerateCloudInit(cloudinit.CloudInitTempl, cloudInitParams)
+	templ, err := h.cloudInitTemplate(props, node.Size)
 	if err != nil {
-		return 0, fmt.Errorf("failed to generate cloud-init configuration: %w", err)
+		return nil, err
 	}
+	cloudInitContent, err := cloudinit.GenerateCloudInit(templ, cloudInitParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate cloud-init configuration: %w", err)
+	}
+	cloudInitContent, err = cloudinit.MergeUserData(cloudInitContent, props.UserData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge cloud-init user data: %w", err)
+	}
+
+	// The configuration is validated, a node with an invalid one would never join
+	if err := cloudinit.Validate(cloudInitContent); err != nil {
+		return nil, fmt.Errorf("invalid cloud-init configuration: %w", err)
+	}
+	networkConfig, err := cloudinit.GenerateNetworkConfig(addr.networkParams())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate network configuration: %w", err)
+	}
+
+	return &CloudInitSeed{
+		UserData:      cloudInitContent,
+		MetaData:      cloudinit.GenerateMetaData(vmName, vmName),
+		NetworkConfig: networkConfig,
+	}, nil
+}
+
+// createVM creates a new node for a service on the host, returning its VM and stored cloud-init configuration
+func (h *JobHandler) createVM(ctx context.Context, props *Properties, access *nodeAccess, serviceName string, node Node, host string, addr *nodeAddress, joinToken string) (int, *CloudInitDrive, error) {
+	vmName := vmName(serviceName, node.ID)
 
-	cloudInitFileName := fmt.Sprintf("kube-agent-ci-%s.yml", vmName)
-	cloudInitFilePath := fmt.Sprintf("%s/%s", h.ciPath, cloudInitFileName)
+	// Get node configuration based on size
+	cores, memory := node.Size.Attrs()
 
-	// Upload cloud-init config to Proxmox host via SSH - use appropriate path/filename
-	err = h.sshCli.Copy(cloudInitContent, cloudInitFilePath)
+	// The configuration is rendered before cloning, so that an invalid one fails early
+	seed, err := h.nodeSeed(ctx, props, access, serviceName, node, addr, joinToken)
 	if err != nil {
-		return 0, fmt.Errorf("failed to copy cloud-init configuration: %w", err)
+		return 0, nil, err
 	}
 
-	// Configure VM with cloud-init config
-	cloudInitConfig := fmt.Sprintf("user=local:snippets/%s", cloudInitFileName)
-	t, err = h.proxmoxCli.ConfigureVM(vmID, cores, memory, cloudInitConfig)
+	// Take a VM from the pool, or create it by cloning from the template of the service
+	template, err := h.nodeTemplate(props)
 	if err != nil {
-		return 0, fmt.Errorf("failed to configure VM: %w", err)
+		return 0, nil, err
+	}
+	vmID, claimed := h.claimPoolVM(template.VMID, node.Size, host, vmName)
+	if !claimed {
+		vmID = h.generateVMID(serviceName, node.ID)
+		if err := h.cloneVM(template.VMID, vmID, vmName, host); err != nil {
+			return 0, nil, err
+		}
 	}
 
-	_, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute)
+	// Configure VM with cloud-init config
+	drive, err := h.uploadCloudInit(vmID, vmName, seed, cores, memory)
 	if err != nil {
-		return 0, fmt.Errorf("failed to configure VM: %w", err)
+		return 0, nil, err
+	}
+
+	if err := h.grantCSIAccess(serviceName, vmID); err != nil {
+		h.deleteCloudInit(vmName)
+		return 0, nil, err
 	}
 
-	return vmID, nil
+	return vmID, drive, nil
 }
 
-// startVM starts a node
-func (h *JobHandler) startVMAndWaitJoin(vmID int, serviceName, nodeName string) error {
+// startVMAndWaitJoin starts a node and revokes its bootstrap token and cloud-init configuration once it joined
+func (h *JobHandler) startVMAndWaitJoin(props *Properties, resources *Resources, vmID int, serviceName string, node Node) error {
+	ctx := context.Background()
+	if err := h.renewJoinToken(ctx, props, resources, vmID, serviceName, node); err != nil {
+		return fmt.Errorf("failed to renew join token: %w", err)
+	}
 	err := h.startVM(vmID)
 	if err != nil {
 		return err
 	}
-	vmName := vmName(serviceName, nodeName)
+	if err := h.waitGuest(props, resources, vmID, node); err != nil {
+		return err
+	}
+	vmName := vmName(serviceName, node.ID)
 	err = h.waitJoin(serviceName, vmName)
 	if err != nil {
+		return fmt.Errorf("%w%s", err, h.guestLogs(vmID))
+	}
+	tenantClient, err := h.kamajiCli.GetTenantClient(ctx, serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant client: %w", err)
+	}
+	if h.csi != nil {
+		if err := h.labelCSINode(ctx, tenantClient, vmID, vmName); err != nil {
+			return err
+		}
+	}
+	if err := h.revokeJoinToken(ctx, tenantClient, resources, node.ID); err != nil {
 		return err
 	}
-	return nil
+	return h.removeSnippet(resources, vmID, serviceName, node.ID, true)
 }
 
 // startVM starts a node
@@ -502,6 +880,9 @@ func (h *JobHandler) startVM(vmID int) error {
 	} else if i.Status != VMStatusStopped {
 		return fmt.Errorf("VM is not stopped")
 	}
+	if h.ha != nil {
+		return h.requestHAState(vmID, HAStateStarted, VMStatusRunning)
+	}
 
 	t, err := h.proxmoxCli.StartVM(vmID)
 	if err != nil {
@@ -525,7 +906,7 @@ func (h *JobHandler) waitJoin(serviceName, nodeName string) error {
 	}
 
 	// Wait for node to join
-	err = wait.PollUntilContextTimeout(ctx, 10*time.Second, 10*time.Minute, true, func(ctx context.Context) (bool, error) {
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Second, joinTimeout, true, func(ctx context.Context) (bool, error) {
 		nodeStatus, err := tenantClient.GetNodeStatus(ctx, nodeName)
 		if err != nil {
 			return false, nil // Node not found, continue polling
@@ -554,30 +935,29 @@ func (h *JobHandler) stopVM(vmID int) error {
 	} else if i.Status != VMStatusRunning {
 		return fmt.Errorf("VM is not running")
 	}
-
-	t, err := h.proxmoxCli.StopVM(vmID)
-	if err != nil {
-		return fmt.Errorf("failed to stop VM: %w", err)
-	}
-
-	_, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute)
-	if err != nil {
-		return fmt.Errorf("failed to stop VM: %w", err)
+	if h.ha != nil {
+		return h.requestHAState(vmID, HAStateStopped, VMStatusStopped)
 	}
 
-	return nil
+	return h.powerOff(vmID)
 }
 
 // deleteVM deletes a node
 func (h *JobHandler) deleteVM(vmID int) error {
-	// First try to stop the VM if it's running
-	t, err := h.proxmoxCli.StopVM(vmID) // Ignore errors - might already be stopped
-	if err == nil {
-		_, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute)
+	// The HA manager would restart the VM, and Proxmox refuses to delete it while registered
+	if err := h.unregisterHA(vmID); err != nil {
+		return err
+	}
+
+	// First power off the VM if it's running
+	if i, err := h.proxmoxCli.GetVMInfo(vmID); err == nil && i.Status != VMStatusStopped {
+		if err := h.powerOff(vmID); err != nil {
+			return err
+		}
 	}
 
 	// Then delete it
-	t, err = h.proxmoxCli.DeleteVM(vmID)
+	t, err := h.proxmoxCli.DeleteVM(vmID)
 	if err != nil {
 		return fmt.Errorf("failed to delete VM: %w", err)
 	}
@@ -30,6 +30,21 @@ type KamajiClient interface {
 
 	// GetTenantClient gets a subcluster client
 	GetTenantClient(ctx context.Context, name string) (KamajiTenantClient, error)
+
+	// LoadIPAllocations loads the address allocations of an IPAM pool, as ranges keyed by owner
+	LoadIPAllocations(ctx context.Context, pool string) (map[string]string, error)
+
+	// SaveIPAllocations saves the address allocations of an IPAM pool, as ranges keyed by owner
+	SaveIPAllocations(ctx context.Context, pool string, allocations map[string]string) error
+
+	// GetNodeCredentials gets the generated node credentials of a tenant, nil if none were saved
+	GetNodeCredentials(ctx context.Context, name string) (*NodeCredentials, error)
+
+	// SaveNodeCredentials saves the generated node credentials of a tenant
+	SaveNodeCredentials(ctx context.Context, name string, creds *NodeCredentials) error
+
+	// DeleteNodeCredentials deletes the generated node credentials of a tenant
+	DeleteNodeCredentials(ctx context.Context, name string) error
 }
 
 // JoinTokenResponse represents a token for joining nodes to a cluster
@@ -52,8 +67,11 @@ type KubeNodeStatus struct {
 // KamajiTenantClient defines the interface for interacting with Kamaji API
 type KamajiTenantClient interface {
 
-	// CreateJoinToken creates a bootstrap token for nodes to join the cluster
-	CreateJoinToken(ctx context.Context, tenantName string, validityHours int) (*JoinTokenResponse, error)
+	// CreateJoinToken creates a bootstrap token for nodes to join the cluster, valid for the given duration
+	CreateJoinToken(ctx context.Context, description string, ttl time.Duration) (*JoinTokenResponse, error)
+
+	// DeleteJoinToken revokes a bootstrap token, expired tokens may already be deleted
+	DeleteJoinToken(ctx context.Context, tokenID string) error
 
 	// DeleteWorkerNode deletes a worker node
 	DeleteWorkerNode(ctx context.Context, nodeName string) error
@@ -63,4 +81,23 @@ type KamajiTenantClient interface {
 
 	// CreateCalicoResources applies Calico networking resources to the tenant cluster
 	CreateCalicoResources(ctx context.Context) error
+
+	// ApplyManifest server-side applies a multi-document YAML manifest to the tenant cluster,
+	// pruning the objects previously applied under the same name that were removed from it
+	ApplyManifest(ctx context.Context, name string, manifest string) error
+
+	// DeleteManifest deletes all the objects previously applied under the given manifest name
+	DeleteManifest(ctx context.Context, name string) error
+
+	// GetAddons retrieves the addons installed in the tenant cluster
+	GetAddons(ctx context.Context) (map[string]InstalledAddon, error)
+
+	// SetAddons records the addons installed in the tenant cluster
+	SetAddons(ctx context.Context, addons map[string]InstalledAddon) error
+
+	// SetNodeLabels adds or updates labels on a node of the tenant cluster
+	SetNodeLabels(ctx context.Context, nodeName string, labels map[string]string) error
+
+	// ListVolumeHandles lists the handles of the persistent volumes provisioned by the given CSI driver
+	ListVolumeHandles(ctx context.Context, driver string) ([]string, error)
 }
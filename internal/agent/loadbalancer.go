@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+const (
+	// LoadBalancerPool is the IPAM pool the tenant load balancer ranges are allocated from
+	LoadBalancerPool = "loadbalancer"
+
+	// DefaultLoadBalancerRangeSize is the default number of load balancer addresses allocated to a tenant
+	DefaultLoadBalancerRangeSize = 8
+
+	lbReleaseName  = "metallb"
+	lbNamespace    = "metallb-system"
+	lbManifestName = "loadbalancer"
+)
+
+// lbManifest configures MetalLB to announce the tenant range in L2 mode
+const lbManifest = `apiVersion: metallb.io/v1beta1
+kind: IPAddressPool
+metadata:
+  name: fulcrum
+  namespace: %[1]s
+spec:
+  addresses:
+  - %[2]s
+---
+apiVersion: metallb.io/v1beta1
+kind: L2Advertisement
+metadata:
+  name: fulcrum
+  namespace: %[1]s
+spec:
+  ipAddressPools:
+  - fulcrum
+`
+
+// LoadBalancerConfig holds the configuration of the MetalLB load balancer deployed into the tenant clusters
+type LoadBalancerConfig struct {
+	Chart     string // Chart reference of MetalLB
+	Version   string // Chart version of MetalLB
+	RangeSize int    // Number of addresses allocated to each tenant
+}
+
+// WithLoadBalancer returns an option that deploys MetalLB into the tenant clusters.
+// The tenant ranges are allocated through the IPAM and MetalLB is installed as a Helm release,
+// so both the IPAM and the Helm client are required as well.
+func WithLoadBalancer(cfg LoadBalancerConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.RangeSize <= 0 {
+			cfg.RangeSize = DefaultLoadBalancerRangeSize
+		}
+		h.loadBalancer = &cfg
+	}
+}
+
+// deployLoadBalancer allocates the tenant load balancer range and installs MetalLB announcing it.
+// It is idempotent and returns the allocated range, or nil if load balancers are not enabled.
+func (h *JobHandler) deployLoadBalancer(ctx context.Context, tenantClient KamajiTenantClient, tenantName string) (*IPRange, error) {
+	if h.loadBalancer == nil {
+		return nil, nil
+	}
+	if h.ipam == nil || h.helmCli == nil {
+		return nil, fmt.Errorf("load balancers require IPAM and helm charts to be enabled")
+	}
+
+	ipRange, err := h.ipam.Allocate(ctx, LoadBalancerPool, tenantName, h.loadBalancer.RangeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate load balancer range: %w", err)
+	}
+
+	kubeConfig, err := h.kamajiCli.GetTenantKubeConfig(ctx, tenantName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+
+	log.Printf("Installing MetalLB with range %s to tenant %s", ipRange, tenantName)
+	release := HelmRelease{
+		Name:      lbReleaseName,
+		Namespace: lbNamespace,
+		Chart:     h.loadBalancer.Chart,
+		Version:   h.loadBalancer.Version,
+		Values: map[string]any{
+			// The webhook runs on the workers, which may not be running yet when the pool is applied
+			"crds": map[string]any{
+				"validationFailurePolicy": "Ignore",
+			},
+		},
+	}
+	if err := h.helmCli.InstallRelease(ctx, kubeConfig.Config, release); err != nil {
+		return nil, fmt.Errorf("failed to install MetalLB: %w", err)
+	}
+
+	manifest := fmt.Sprintf(lbManifest, lbNamespace, ipRange)
+	if err := tenantClient.ApplyManifest(ctx, lbManifestName, manifest); err != nil {
+		return nil, fmt.Errorf("failed to configure MetalLB: %w", err)
+	}
+
+	return ipRange, nil
+}
+
+// releaseLoadBalancer releases the tenant load balancer range
+func (h *JobHandler) releaseLoadBalancer(ctx context.Context, tenantName string) error {
+	if h.loadBalancer == nil || h.ipam == nil {
+		return nil
+	}
+	if err := h.ipam.Release(ctx, LoadBalancerPool, tenantName); err != nil {
+		return fmt.Errorf("failed to release load balancer range: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerLoadBalancer(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	helmCli := NewMockHelmClient()
+	ipam := NewMockIPAM()
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(),
+		WithHelmClient(helmCli), WithIPAM(ipam), WithLoadBalancer(LoadBalancerConfig{Chart: "metallb", Version: "0.14.8", RangeSize: 4}))
+
+	serviceID := "lb-service"
+	serviceName := "lb-cluster"
+	props := &Properties{Nodes: []Node{{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}}}
+
+	// Create the service, a range is allocated and announced by MetalLB
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1-10.0.0.4", service.Resources.LoadBalancerRange)
+
+	kubeConfig, err := kamajiCli.GetTenantKubeConfig(context.Background(), serviceName)
+	require.NoError(t, err)
+	releases, err := helmCli.ListReleases(context.Background(), kubeConfig.Config)
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+	require.Equal(t, lbReleaseName, releases[0].Name)
+
+	tcp, ok := kamajiCli.GetTenantControlPlane(serviceName)
+	require.True(t, ok)
+	require.Contains(t, tcp.Manifests[lbManifestName], "- 10.0.0.1-10.0.0.4")
+
+	// Updates keep the same range and the MetalLB release
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	service, err = fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1-10.0.0.4", service.Resources.LoadBalancerRange)
+	releases, err = helmCli.ListReleases(context.Background(), kubeConfig.Config)
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+
+	// Deleting the service releases the range
+	require.NoError(t, fulcrumCli.StopService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.DeleteService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	_, ok = ipam.GetAllocation(LoadBalancerPool, serviceName)
+	require.False(t, ok)
+}
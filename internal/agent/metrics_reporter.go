@@ -7,6 +7,7 @@ import (
 type MetricsReporter struct {
 	fulcrumCli FulcrumClient
 	proxmoxCli ProxmoxClient
+	quotas     *JobHandler // Reports the quota consumption of the services if set
 }
 
 func NewMetricsReporter(fulcrumCli FulcrumClient, proxmoxCli ProxmoxClient) *MetricsReporter {
@@ -30,6 +31,9 @@ func (m *MetricsReporter) Report() error {
 
 		// Process services in current page
 		for _, service := range services.Items {
+			if err := m.processQuotaMetrics(service); err != nil {
+				return err
+			}
 			if service.CurrentStatus != ServiceStarted {
 				continue
 			}
@@ -87,3 +91,25 @@ func (m *MetricsReporter) processServiceMetrics(service *Service) error {
 
 	return nil
 }
+
+// processQuotaMetrics reports the quota consumption of a service, stopped services included
+func (m *MetricsReporter) processQuotaMetrics(service *Service) error {
+	if m.quotas == nil || m.quotas.quotas == nil {
+		return nil
+	}
+	if service.ExternalID == nil || service.CurrentProperties == nil || service.CurrentStatus == ServiceDeleted {
+		return nil
+	}
+
+	metrics, err := m.quotas.quotaMetrics(service)
+	if err != nil {
+		slog.Error("failed to get quota consumption", "service", service.ID, "error", err)
+		return nil
+	}
+	for _, metric := range metrics {
+		if err := m.fulcrumCli.ReportMetric(&metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
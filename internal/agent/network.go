@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultNICModel is the model of the network device of the worker nodes
+	DefaultNICModel = "virtio"
+
+	// firewallNodesIPSet is the cluster IP set holding the addresses of the worker nodes of all the tenants
+	firewallNodesIPSet = "fulcrum-nodes"
+)
+
+// Network represents the network the worker nodes of a service are attached to
+type Network struct {
+	Bridge string `json:"bridge,omitempty"` // Linux or OVS bridge
+	VNet   string `json:"vnet,omitempty"`   // SDN VNet, exclusive with the bridge
+	VLAN   int    `json:"vlan,omitempty"`   // VLAN tag, untagged if 0
+}
+
+// NetworkConfig holds the network configuration of the worker nodes
+type NetworkConfig struct {
+	Default  Network  // Network of the services not selecting one
+	Allowed  []string // Bridges and VNets the services can select, any if empty
+	Model    string   // Network device model
+	Firewall bool     // Isolate the tenants from one another with the Proxmox firewall
+}
+
+// WithNetwork returns an option that attaches the worker nodes to the service network.
+// The tenant isolation firewall matches the node addresses, so it requires the node subnets as well.
+func WithNetwork(cfg NetworkConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.Model == "" {
+			cfg.Model = DefaultNICModel
+		}
+		h.network = &cfg
+	}
+}
+
+// serviceNetwork returns the network selected by a service, nil if the template one is kept
+func (h *JobHandler) serviceNetwork(props *Properties) (*Network, error) {
+	var network *Network
+	if props != nil && props.Network != nil {
+		network = props.Network
+	} else if h.network != nil {
+		network = &h.network.Default
+	}
+	if network == nil || (network.Bridge == "" && network.VNet == "") {
+		if network != nil && network.VLAN != 0 {
+			return nil, fmt.Errorf("VLAN tag requires a bridge")
+		}
+		// The network device of the template would be kept without firewall
+		if h.firewallEnabled() {
+			return nil, fmt.Errorf("the tenant firewall requires a bridge or a VNet")
+		}
+		return nil, nil
+	}
+
+	if network.Bridge != "" && network.VNet != "" {
+		return nil, fmt.Errorf("network requires either a bridge or a VNet, not both")
+	}
+	if network.VLAN < 0 || network.VLAN > 4094 {
+		return nil, fmt.Errorf("invalid VLAN tag %d", network.VLAN)
+	}
+	if network.VNet != "" && network.VLAN != 0 {
+		return nil, fmt.Errorf("VLAN tag is defined by VNet %s", network.VNet)
+	}
+	if h.network != nil && len(h.network.Allowed) > 0 && props != nil && props.Network != nil {
+		if !slices.Contains(h.network.Allowed, network.Bridge+network.VNet) {
+			return nil, fmt.Errorf("network %s is not allowed", network.Bridge+network.VNet)
+		}
+	}
+
+	return network, nil
+}
+
+// configureNIC attaches the network device of a VM to the network
+func (h *JobHandler) configureNIC(vmID int, network *Network) error {
+	if network == nil {
+		return nil
+	}
+
+	model := DefaultNICModel
+	firewall := false
+	if h.network != nil {
+		model = h.network.Model
+		firewall = h.network.Firewall
+	}
+
+	// A VNet is exposed as a bridge on every Proxmox node
+	netConfig := fmt.Sprintf("%s,bridge=%s%s", model, network.Bridge, network.VNet)
+	if network.VLAN != 0 {
+		netConfig += fmt.Sprintf(",tag=%d", network.VLAN)
+	}
+	if firewall {
+		netConfig += ",firewall=1"
+	}
+
+	t, err := h.proxmoxCli.ConfigureNIC(vmID, netConfig)
+	if err != nil {
+		return fmt.Errorf("failed to configure VM network device: %w", err)
+	}
+	_, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed to configure VM network device: %w", err)
+	}
+
+	return nil
+}
+
+// firewallName returns the name of the IP set and security group of a tenant.
+// Security group names are limited to 18 characters, so the tenant name is hashed.
+func firewallName(tenantName string) string {
+	sum := sha256.Sum256([]byte(tenantName))
+	return "fk-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// firewallEnabled reports whether the tenants are isolated with the Proxmox firewall
+func (h *JobHandler) firewallEnabled() bool {
+	return h.network != nil && h.network.Firewall
+}
+
+// deployFirewall creates the security group isolating the tenant nodes from the other tenants
+func (h *JobHandler) deployFirewall(tenantName string) error {
+	if !h.firewallEnabled() {
+		return nil
+	}
+	if len(h.subnets) == 0 {
+		return fmt.Errorf("the tenant firewall requires node subnets to be enabled")
+	}
+
+	name := firewallName(tenantName)
+	if err := h.proxmoxCli.EnsureIPSet(firewallNodesIPSet, "Nodes of all the tenants"); err != nil {
+		return fmt.Errorf("failed to create nodes IP set: %w", err)
+	}
+	if err := h.proxmoxCli.EnsureIPSet(name, fmt.Sprintf("Nodes of tenant %s", tenantName)); err != nil {
+		return fmt.Errorf("failed to create tenant IP set: %w", err)
+	}
+
+	// Traffic from the nodes of the same tenant is accepted, from the nodes of other tenants dropped
+	rules := []FirewallRule{
+		{Type: "in", Action: "ACCEPT", Source: "+" + name, Comment: fmt.Sprintf("Nodes of tenant %s", tenantName)},
+		{Type: "in", Action: "DROP", Source: "+" + firewallNodesIPSet, Comment: "Nodes of the other tenants"},
+	}
+	if err := h.proxmoxCli.EnsureSecurityGroup(name, rules); err != nil {
+		return fmt.Errorf("failed to create tenant security group: %w", err)
+	}
+
+	return nil
+}
+
+// firewallAddNode adds a node to the tenant IP sets and applies the tenant security group to its VM
+func (h *JobHandler) firewallAddNode(tenantName string, vmID int, ip string) error {
+	if !h.firewallEnabled() {
+		return nil
+	}
+	if ip == "" {
+		return fmt.Errorf("the tenant firewall requires a static node address")
+	}
+
+	name := firewallName(tenantName)
+	if err := h.proxmoxCli.AddIPSetEntry(name, ip); err != nil {
+		return fmt.Errorf("failed to add node to tenant IP set: %w", err)
+	}
+	if err := h.proxmoxCli.AddIPSetEntry(firewallNodesIPSet, ip); err != nil {
+		return fmt.Errorf("failed to add node to nodes IP set: %w", err)
+	}
+	if err := h.proxmoxCli.EnableVMFirewall(vmID, name); err != nil {
+		return fmt.Errorf("failed to enable node firewall: %w", err)
+	}
+
+	return nil
+}
+
+// firewallRemoveNode removes a node from the tenant IP sets
+func (h *JobHandler) firewallRemoveNode(tenantName string, ip string) {
+	if !h.firewallEnabled() || ip == "" {
+		return
+	}
+
+	// The entries may be missing if the node creation failed early
+	if err := h.proxmoxCli.RemoveIPSetEntry(firewallName(tenantName), ip); err != nil {
+		log.Printf("Failed to remove node %s from tenant IP set: %v", ip, err)
+	}
+	if err := h.proxmoxCli.RemoveIPSetEntry(firewallNodesIPSet, ip); err != nil {
+		log.Printf("Failed to remove node %s from nodes IP set: %v", ip, err)
+	}
+}
+
+// removeFirewall deletes the tenant security group and IP set
+func (h *JobHandler) removeFirewall(tenantName string) error {
+	if !h.firewallEnabled() {
+		return nil
+	}
+
+	name := firewallName(tenantName)
+	if err := h.proxmoxCli.DeleteSecurityGroup(name); err != nil {
+		return fmt.Errorf("failed to delete tenant security group: %w", err)
+	}
+	if err := h.proxmoxCli.DeleteIPSet(name); err != nil {
+		return fmt.Errorf("failed to delete tenant IP set: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerNetwork(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	network := NetworkConfig{
+		Default:  Network{Bridge: "vmbr0"},
+		Allowed:  []string{"vmbr1", "tenants"},
+		Firewall: true,
+	}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(),
+		WithIPAM(NewMockIPAM()), WithNodeSubnets(Subnet{Name: "default", CIDR: "10.0.0.0/16"}), WithNetwork(network))
+
+	nodes := []Node{{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}}
+
+	// Services without network are attached to the default one
+	require.NoError(t, fulcrumCli.CreateService("service-a", "cluster-a", nil, &Properties{Nodes: nodes}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	serviceA, err := fulcrumCli.GetService("service-a")
+	require.NoError(t, err)
+	vmA, ok := proxmoxCli.GetVM(serviceA.Resources.Nodes["node1"])
+	require.True(t, ok)
+	require.Equal(t, "virtio,bridge=vmbr0,firewall=1", vmA.NIC)
+
+	// Services can select an allowed bridge with a VLAN tag
+	props := &Properties{Nodes: nodes, Network: &Network{Bridge: "vmbr1", VLAN: 120}}
+	require.NoError(t, fulcrumCli.CreateService("service-b", "cluster-b", nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	serviceB, err := fulcrumCli.GetService("service-b")
+	require.NoError(t, err)
+	vmB, ok := proxmoxCli.GetVM(serviceB.Resources.Nodes["node1"])
+	require.True(t, ok)
+	require.Equal(t, "virtio,bridge=vmbr1,tag=120,firewall=1", vmB.NIC)
+
+	// Each tenant gets its security group, accepting its own nodes only
+	require.Equal(t, firewallName("cluster-a"), vmA.Firewall)
+	require.Equal(t, firewallName("cluster-b"), vmB.Firewall)
+	rules, ok := proxmoxCli.GetSecurityGroup(firewallName("cluster-a"))
+	require.True(t, ok)
+	require.Equal(t, "+"+firewallName("cluster-a"), rules[0].Source)
+	require.Equal(t, "ACCEPT", rules[0].Action)
+	require.Equal(t, "+"+firewallNodesIPSet, rules[1].Source)
+	require.Equal(t, "DROP", rules[1].Action)
+	allNodes, ok := proxmoxCli.GetIPSet(firewallNodesIPSet)
+	require.True(t, ok)
+	require.Len(t, allNodes, 2)
+	tenantNodes, ok := proxmoxCli.GetIPSet(firewallName("cluster-a"))
+	require.True(t, ok)
+	require.Equal(t, map[string]bool{serviceA.Resources.NodeIPs["node1"]: true}, tenantNodes)
+
+	// Deleting a service removes its firewall
+	require.NoError(t, fulcrumCli.StartService("service-a"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.StopService("service-a"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.DeleteService("service-a"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	_, ok = proxmoxCli.GetSecurityGroup(firewallName("cluster-a"))
+	require.False(t, ok)
+	require.Len(t, allNodes, 1)
+
+	// Networks not allowed and invalid networks are rejected
+	for i, network := range []*Network{
+		{Bridge: "vmbr2"},
+		{Bridge: "vmbr1", VNet: "tenants"},
+		{VNet: "tenants", VLAN: 10},
+		{Bridge: "vmbr1", VLAN: 5000},
+		{}, // The firewall is only enabled on the network devices the agent attaches
+	} {
+		props := &Properties{Nodes: nodes, Network: network}
+		serviceID := fmt.Sprintf("invalid-service-%d", i)
+		require.NoError(t, fulcrumCli.CreateService(serviceID, serviceID, nil, props), i)
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullFailedJobs(), 1, i)
+	}
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cloneLockRetries is how many times a clone failing to get a Proxmox lock is retried
+const cloneLockRetries = 5
+
+// cloneLockRetryDelay is the delay before retrying a clone failing to get a Proxmox lock
+var cloneLockRetryDelay = 10 * time.Second
+
+// ParallelConfig holds the limits of the nodes provisioned concurrently within a job
+type ParallelConfig struct {
+	Nodes  int // Nodes provisioned concurrently
+	Clones int // Clone tasks running concurrently, which the locks of the template and storage serialize
+}
+
+// WithParallelProvisioning returns an option that provisions the nodes of a job concurrently.
+// Without it they are provisioned one at a time.
+func WithParallelProvisioning(cfg ParallelConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.parallel = max(cfg.Nodes, 1)
+		h.cloneSlots = make(chan struct{}, max(cfg.Clones, 1))
+	}
+}
+
+// provisionNodes provisions the nodes concurrently, up to the parallel limit. A failed node does not stop
+// the others, but once they are done all the nodes are rolled back and the error aggregates the failed ones,
+// so that a failed job leaves no VM, address, token or snippet the resources would not record.
+func (h *JobHandler) provisionNodes(ctx context.Context, props *Properties, access *nodeAccess, serviceName string, nodes []Node, resources *Resources) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(h.parallel, 1))
+	errs := make([]error, len(nodes))
+	for i, node := range nodes {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = h.provisionNode(ctx, props, access, serviceName, node, resources, &mu)
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		} else {
+			log.Printf("Created node %s of %s", nodes[i].ID, serviceName)
+		}
+	}
+	if len(failed) > 0 {
+		h.rollbackNodes(ctx, props, serviceName, nodes, resources)
+		return fmt.Errorf("failed to create %d of %d nodes: %w", len(failed), len(nodes), errors.Join(failed...))
+	}
+	return nil
+}
+
+// rollbackNodes deletes the VMs of the nodes recorded in the resources and everything they hold.
+// The failures are only logged, as the provisioning error is the one reported.
+func (h *JobHandler) rollbackNodes(ctx context.Context, props *Properties, serviceName string, nodes []Node, resources *Resources) {
+	tenantClient, err := h.kamajiCli.GetTenantClient(ctx, serviceName)
+	if err != nil {
+		log.Printf("Failed to get tenant client of %s: %v", serviceName, err)
+	}
+	for _, node := range nodes {
+		vmID, ok := resources.Nodes[node.ID]
+		if !ok {
+			continue
+		}
+		if err := h.deleteVM(vmID); err != nil {
+			log.Printf("Failed to delete node %s of %s: %v", node.ID, serviceName, err)
+		}
+		if tenantClient != nil {
+			if err := h.revokeJoinToken(ctx, tenantClient, resources, node.ID); err != nil {
+				log.Printf("Failed to clean up node %s of %s: %v", node.ID, serviceName, err)
+			}
+		}
+		if err := h.removeSnippet(resources, vmID, serviceName, node.ID, false); err != nil {
+			log.Printf("Failed to clean up node %s of %s: %v", node.ID, serviceName, err)
+		}
+		h.firewallRemoveNode(serviceName, resources.NodeIPs[node.ID])
+		if err := h.releaseNodeIP(ctx, props.Subnet, serviceName, node.ID); err != nil {
+			log.Printf("Failed to release node %s address: %v", node.ID, err)
+		}
+		delete(resources.Nodes, node.ID)
+		delete(resources.NodeIPs, node.ID)
+		delete(resources.Hosts, node.ID)
+		delete(resources.NodeAddresses, node.ID)
+		log.Printf("Rolled back node %s of %s", node.ID, serviceName)
+	}
+}
+
+// isLockTimeout tells whether a Proxmox request or task failed waiting for a VM or storage lock
+func isLockTimeout(msg string) bool {
+	return strings.Contains(msg, "can't lock file") || strings.Contains(msg, "got timeout")
+}
+
+// cloneVM clones a template into a new VM, on the host or the template one if empty.
+// The clones wait for a free clone slot, and are retried when Proxmox times out waiting for a lock.
+func (h *JobHandler) cloneVM(templateID int, vmID int, name string, host string) error {
+	if h.cloneSlots != nil {
+		h.cloneSlots <- struct{}{}
+		defer func() { <-h.cloneSlots }()
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := h.tryCloneVM(templateID, vmID, name, host)
+		if err == nil || attempt == cloneLockRetries || !isLockTimeout(err.Error()) {
+			return err
+		}
+		log.Printf("Clone of VM %d timed out waiting for a lock, retrying: %v", vmID, err)
+		time.Sleep(cloneLockRetryDelay)
+	}
+}
+
+func (h *JobHandler) tryCloneVM(templateID int, vmID int, name string, host string) error {
+	t, err := h.proxmoxCli.CloneVM(templateID, vmID, name, host, h.linkedClones())
+	if err != nil {
+		return fmt.Errorf("failed to clone VM: %w", err)
+	}
+	status, err := h.proxmoxCli.WaitForTask(t.TaskID, 10*time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed to clone VM: %w", err)
+	}
+	if status.ExitStatus != "" && status.ExitStatus != "OK" {
+		return fmt.Errorf("failed to clone VM: %s", status.ExitStatus)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerParallelProvisioning(t *testing.T) {
+	cloneLockRetryDelay = 0
+	nodes := func(ids ...string) []Node {
+		var nodes []Node
+		for _, id := range ids {
+			nodes = append(nodes, Node{ID: id, Size: NodeSizeS1, Status: NodeStatusOff})
+		}
+		return nodes
+	}
+
+	t.Run("spread", func(t *testing.T) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("pve1")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		proxmoxCli.SetHost(HostStatus{Name: "pve2", Online: true, MaxCPU: 16, MaxMemory: 64 << 30})
+		policy, err := NewPlacementPolicy(PlacementSpread)
+		require.NoError(t, err)
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(),
+			WithPlacement(PlacementConfig{Policy: policy}), WithParallelProvisioning(ParallelConfig{Nodes: 4, Clones: 2}))
+
+		// The nodes provisioned concurrently see the hosts the others were placed on
+		require.NoError(t, fulcrumCli.CreateService("spread-service", "spread-cluster", nil, &Properties{Nodes: nodes("node1", "node2", "node3", "node4")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		service, err := fulcrumCli.GetService("spread-service")
+		require.NoError(t, err)
+		require.Len(t, service.Resources.Nodes, 4)
+		require.Len(t, service.Resources.BootstrapTokens, 4)
+		perHost := make(map[string]int)
+		for _, host := range service.Resources.Hosts {
+			perHost[host]++
+		}
+		require.Equal(t, map[string]int{"pve1": 2, "pve2": 2}, perHost)
+	})
+
+	t.Run("failed nodes", func(t *testing.T) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("test-node")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		kamajiCli := NewMockKamajiClient()
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(),
+			WithParallelProvisioning(ParallelConfig{Nodes: 4, Clones: 2}))
+
+		// Clones timing out on a lock are retried, the other failures are reported for each node
+		proxmoxCli.FailClones(vmName("failed-cluster", "node2"), fmt.Errorf("storage full"))
+		proxmoxCli.FailClones(vmName("failed-cluster", "node3"), fmt.Errorf("can't lock file '/var/lock/pve-manager/pve-storage-local-lvm' - got timeout"))
+		proxmoxCli.FailClones(vmName("failed-cluster", "node4"), fmt.Errorf("disk error"))
+		require.NoError(t, fulcrumCli.CreateService("failed-service", "failed-cluster", nil, &Properties{Nodes: nodes("node1", "node2", "node3", "node4")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "failed to create 2 of 4 nodes")
+		require.Contains(t, failed[0].ErrorMessage, "failed to create node node2: failed to clone VM: storage full")
+		require.Contains(t, failed[0].ErrorMessage, "failed to create node node4: failed to clone VM: disk error")
+
+		// The created nodes are rolled back with the failed ones
+		for _, id := range []string{"node1", "node3"} {
+			_, ok := proxmoxCli.GetVM(jobHandler.generateVMID("failed-cluster", id))
+			require.False(t, ok)
+		}
+		tcp, ok := kamajiCli.GetTenantControlPlane("failed-cluster")
+		require.True(t, ok)
+		require.Empty(t, tcp.JoinTokens)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("test-node")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		kamajiCli := NewMockKamajiClient()
+		ipam := NewMockIPAM()
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "", kamajiCli, nil,
+			WithSnippetStore(NewISOSnippetStore(proxmoxCli, "local", "")), WithIPAM(ipam),
+			WithNodeSubnets(Subnet{Name: "default", CIDR: "10.0.0.0/24"}), WithParallelProvisioning(ParallelConfig{Nodes: 3, Clones: 3}))
+
+		// One node out of three fails, the job leaves nothing behind the resources would not record
+		proxmoxCli.FailClones(vmName("rollback-cluster", "node2"), fmt.Errorf("storage full"))
+		require.NoError(t, fulcrumCli.CreateService("rollback-service", "rollback-cluster", nil, &Properties{Nodes: nodes("node1", "node2", "node3")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "failed to create 1 of 3 nodes")
+
+		for _, id := range []string{"node1", "node2", "node3"} {
+			_, ok := proxmoxCli.GetVM(jobHandler.generateVMID("rollback-cluster", id))
+			require.False(t, ok)
+			_, ok = ipam.GetAllocation(SubnetPool("default"), vmName("rollback-cluster", id))
+			require.False(t, ok)
+			_, ok = proxmoxCli.GetISO("local:iso/kube-agent-ci-" + vmName("rollback-cluster", id) + ".iso")
+			require.False(t, ok)
+		}
+		tcp, ok := kamajiCli.GetTenantControlPlane("rollback-cluster")
+		require.True(t, ok)
+		require.Empty(t, tcp.JoinTokens)
+	})
+}
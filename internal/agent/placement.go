@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Placement policies of the VMs on the hosts of the Proxmox cluster
+const (
+	PlacementLeastLoaded = "least-loaded" // Host with the lowest CPU or memory usage
+	PlacementSpread      = "spread"       // Host with the fewest nodes of the service, then the least loaded
+	PlacementLabel       = "label"        // Least loaded host among the ones carrying the labels of the service
+)
+
+// PlacementRequest describes the VM to place
+type PlacementRequest struct {
+	ServiceName string
+	NodeID      string
+	Memory      int               // Memory of the VM in MB
+	Labels      []string          // Host labels selected by the service
+	Placed      map[string]string // Hosts of the other nodes of the service
+}
+
+// PlacementPolicy chooses the host of a new VM among the candidates, which are online and carry the labels
+// selected by the service
+type PlacementPolicy interface {
+	Place(candidates []HostStatus, req *PlacementRequest) (string, error)
+}
+
+// NewPlacementPolicy returns the placement policy with the given name
+func NewPlacementPolicy(name string) (PlacementPolicy, error) {
+	switch name {
+	case PlacementLeastLoaded:
+		return leastLoadedPolicy{}, nil
+	case PlacementSpread:
+		return spreadPolicy{}, nil
+	case PlacementLabel:
+		return labelPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement policy %s", name)
+	}
+}
+
+// PlacementConfig holds the configuration of the VM placement
+type PlacementConfig struct {
+	Policy     PlacementPolicy
+	HostLabels map[string][]string // Labels of the Proxmox hosts, by host name
+}
+
+// WithPlacement returns an option that places the VMs on the hosts of the Proxmox cluster with the policy.
+// Without it the VMs are cloned on the host of the template.
+func WithPlacement(cfg PlacementConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.placement = &cfg
+	}
+}
+
+// hostLoad returns the highest of the CPU and memory usage of a host once the VM memory is added
+func hostLoad(host HostStatus, memory int) float64 {
+	load := host.CPU
+	if host.MaxMemory > 0 {
+		load = max(load, float64(host.Memory+int64(memory)*1024*1024)/float64(host.MaxMemory))
+	}
+	return load
+}
+
+type leastLoadedPolicy struct{}
+
+func (leastLoadedPolicy) Place(candidates []HostStatus, req *PlacementRequest) (string, error) {
+	best := slices.MinFunc(candidates, func(a, b HostStatus) int {
+		return compareLoad(a, b, req.Memory)
+	})
+	return best.Name, nil
+}
+
+func compareLoad(a, b HostStatus, memory int) int {
+	la, lb := hostLoad(a, memory), hostLoad(b, memory)
+	switch {
+	case la < lb:
+		return -1
+	case la > lb:
+		return 1
+	}
+	return 0
+}
+
+// spreadPolicy keeps the nodes of a service on distinct hosts as long as possible
+type spreadPolicy struct{}
+
+func (spreadPolicy) Place(candidates []HostStatus, req *PlacementRequest) (string, error) {
+	count := make(map[string]int)
+	for nodeID, host := range req.Placed {
+		if nodeID != req.NodeID {
+			count[host]++
+		}
+	}
+	best := slices.MinFunc(candidates, func(a, b HostStatus) int {
+		if count[a.Name] != count[b.Name] {
+			return count[a.Name] - count[b.Name]
+		}
+		return compareLoad(a, b, req.Memory)
+	})
+	return best.Name, nil
+}
+
+// labelPolicy pins the nodes of a service to the hosts carrying its labels
+type labelPolicy struct{}
+
+func (labelPolicy) Place(candidates []HostStatus, req *PlacementRequest) (string, error) {
+	if len(req.Labels) == 0 {
+		return "", fmt.Errorf("the service selects no host labels")
+	}
+	return leastLoadedPolicy{}.Place(candidates, req)
+}
+
+// validatePlacement checks that the host labels selected by a service can be honored
+func (h *JobHandler) validatePlacement(props *Properties) error {
+	if h.placement == nil && len(props.HostLabels) > 0 {
+		return fmt.Errorf("placement is not enabled on this agent")
+	}
+	return nil
+}
+
+// placeNode chooses the host of a new node, empty to clone it on the host of the template
+func (h *JobHandler) placeNode(props *Properties, resources *Resources, serviceName string, node Node) (string, error) {
+	if err := h.validatePlacement(props); err != nil || h.placement == nil {
+		return "", err
+	}
+
+	candidates, err := h.placementCandidates(props)
+	if err != nil {
+		return "", err
+	}
+	candidates = h.hostsWithCapacity(candidates, props, resources, node)
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("failed to place node %s: no host has the capacity it requires", node.ID)
+	}
+
+	_, memory := node.Size.Attrs()
+	host, err := h.placement.Policy.Place(candidates, &PlacementRequest{
+		ServiceName: serviceName,
+		NodeID:      node.ID,
+		Memory:      memory,
+		Labels:      props.HostLabels,
+		Placed:      resources.Hosts,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to place node %s: %w", node.ID, err)
+	}
+	return host, nil
+}
+
+// placementCandidates returns the online hosts carrying the labels selected by a service
+func (h *JobHandler) placementCandidates(props *Properties) ([]HostStatus, error) {
+	hosts, err := h.proxmoxCli.GetHosts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %w", err)
+	}
+	var candidates []HostStatus
+	for _, host := range hosts {
+		if host.Online && h.hasHostLabels(host.Name, props.HostLabels) {
+			candidates = append(candidates, host)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no online host with labels %v", props.HostLabels)
+	}
+	return candidates, nil
+}
+
+func (h *JobHandler) hasHostLabels(host string, labels []string) bool {
+	for _, label := range labels {
+		if !slices.Contains(h.placement.HostLabels[host], label) {
+			return false
+		}
+	}
+	return true
+}
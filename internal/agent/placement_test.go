@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlacementPolicies(t *testing.T) {
+	hosts := []HostStatus{
+		{Name: "pve1", Online: true, CPU: 0.1, MaxCPU: 16, Memory: 48 << 30, MaxMemory: 64 << 30},
+		{Name: "pve2", Online: true, CPU: 0.6, MaxCPU: 16, Memory: 16 << 30, MaxMemory: 64 << 30},
+		{Name: "pve3", Online: true, CPU: 0.2, MaxCPU: 16, Memory: 16 << 30, MaxMemory: 64 << 30},
+	}
+	req := &PlacementRequest{ServiceName: "cluster", NodeID: "node3", Memory: 4096}
+
+	// The load is the highest of the CPU and memory usage
+	policy, err := NewPlacementPolicy(PlacementLeastLoaded)
+	require.NoError(t, err)
+	host, err := policy.Place(hosts, req)
+	require.NoError(t, err)
+	require.Equal(t, "pve3", host)
+
+	// The nodes of a service are spread before the load is considered
+	policy, err = NewPlacementPolicy(PlacementSpread)
+	require.NoError(t, err)
+	req.Placed = map[string]string{"node1": "pve3", "node2": "pve2"}
+	host, err = policy.Place(hosts, req)
+	require.NoError(t, err)
+	require.Equal(t, "pve1", host)
+
+	// The label policy requires the service to select labels, the handler only passes the hosts carrying them
+	policy, err = NewPlacementPolicy(PlacementLabel)
+	require.NoError(t, err)
+	_, err = policy.Place(hosts, req)
+	require.Error(t, err)
+	req.Labels = []string{"ssd"}
+	host, err = policy.Place(hosts, req)
+	require.NoError(t, err)
+	require.Equal(t, "pve3", host)
+
+	_, err = NewPlacementPolicy("random")
+	require.Error(t, err)
+}
+
+func TestJobHandlerPlacement(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("pve1")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	proxmoxCli.SetHost(HostStatus{Name: "pve2", Online: true, CPU: 0.5, MaxCPU: 16, MaxMemory: 64 << 30})
+	proxmoxCli.SetHost(HostStatus{Name: "pve3", Online: true, CPU: 0.2, MaxCPU: 16, MaxMemory: 64 << 30})
+	proxmoxCli.SetHost(HostStatus{Name: "pve4", Online: false, MaxCPU: 16, MaxMemory: 64 << 30})
+	policy, err := NewPlacementPolicy(PlacementSpread)
+	require.NoError(t, err)
+	placement := PlacementConfig{Policy: policy, HostLabels: map[string][]string{"pve2": {"ssd"}, "pve4": {"ssd"}}}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), WithPlacement(placement))
+
+	// The nodes of a service are spread over the online hosts, the least loaded first
+	nodes := []Node{
+		{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff},
+		{ID: "node2", Size: NodeSizeS1, Status: NodeStatusOff},
+		{ID: "node3", Size: NodeSizeS1, Status: NodeStatusOff},
+		{ID: "node4", Size: NodeSizeS1, Status: NodeStatusOff},
+	}
+	require.NoError(t, fulcrumCli.CreateService("spread-service", "spread-cluster", nil, &Properties{Nodes: nodes}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err := fulcrumCli.GetService("spread-service")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"node1": "pve1", "node2": "pve3", "node3": "pve2", "node4": "pve1"}, service.Resources.Hosts)
+	for nodeID, host := range service.Resources.Hosts {
+		vm, ok := proxmoxCli.GetVM(service.Resources.Nodes[nodeID])
+		require.True(t, ok)
+		require.Equal(t, host, vm.Host)
+	}
+
+	// The services can pin their nodes to the hosts with labels
+	require.NoError(t, fulcrumCli.CreateService("ssd-service", "ssd-cluster", nil, &Properties{Nodes: nodes[:2], HostLabels: []string{"ssd"}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	service, err = fulcrumCli.GetService("ssd-service")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"node1": "pve2", "node2": "pve2"}, service.Resources.Hosts)
+
+	// Without placement the services can not select host labels
+	jobHandler = NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient())
+	require.NoError(t, fulcrumCli.CreateService("label-service", "label-cluster", nil, &Properties{Nodes: nodes[:1], HostLabels: []string{"ssd"}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+}
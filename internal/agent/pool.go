@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPoolRefillInterval is how often the VM pool is refilled by default
+const DefaultPoolRefillInterval = time.Minute
+
+const (
+	// poolVMPrefix starts the names of the pool VMs, so that they are found again when the agent restarts
+	poolVMPrefix = "kube-agent-pool-"
+	// poolVMIDBase is the first VM ID of the pool VMs, above the range of the IDs generated for the nodes
+	poolVMIDBase = 10000
+)
+
+// linkedCloneStorages are the storage types supporting linked clones, provided the template disks are qcow2
+// images on the file based ones
+var linkedCloneStorages = []string{"lvmthin", "zfspool", "rbd", "dir", "nfs", "cifs", "glusterfs"}
+
+// CloneConfig holds the configuration of the VM clones
+type CloneConfig struct {
+	Linked  bool   // Use linked clones when the storage supports them
+	Storage string // Storage of the template disks
+}
+
+type cloneSettings struct {
+	CloneConfig
+	once   sync.Once
+	linked bool
+}
+
+// WithClones returns an option configuring how the VMs are cloned from the template.
+// Without it they are full clones.
+func WithClones(cfg CloneConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.clones = &cloneSettings{CloneConfig: cfg}
+	}
+}
+
+// linkedClones tells whether the VMs are linked clones, checking the storage type the first time
+func (h *JobHandler) linkedClones() bool {
+	if h.clones == nil || !h.clones.Linked {
+		return false
+	}
+	h.clones.once.Do(func() {
+		status, err := h.proxmoxCli.GetStorageStatus("", h.clones.Storage)
+		if err != nil {
+			log.Printf("Failed to get storage %s status, using full clones: %v", h.clones.Storage, err)
+			return
+		}
+		h.clones.linked = slices.Contains(linkedCloneStorages, status.Type)
+		if !h.clones.linked {
+			log.Printf("Storage %s of type %s does not support linked clones, using full clones", h.clones.Storage, status.Type)
+		}
+	})
+	return h.clones.linked
+}
+
+// PoolConfig holds the configuration of the pool of stopped VMs the nodes are created from
+type PoolConfig struct {
+	Sizes          map[NodeSize]int // Number of VMs kept ready for each node size
+	RefillInterval time.Duration    // How often the pool is refilled
+}
+
+type vmPool struct {
+	PoolConfig
+	refill   sync.Mutex // Held while refilling
+	mu       sync.Mutex // Guards the ready VMs
+	loaded   bool
+	template int                   // Template the pool VMs are cloned from, the one of the default OS and version
+	vms      map[NodeSize][]VMInfo // Ready VMs of each size
+}
+
+// WithVMPool returns an option that keeps a pool of VMs cloned from the template and configured for each
+// node size, but stopped, so that adding a node only needs to configure and start one of them
+func WithVMPool(cfg PoolConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.RefillInterval <= 0 {
+			cfg.RefillInterval = DefaultPoolRefillInterval
+		}
+		h.pool = &vmPool{PoolConfig: cfg, vms: make(map[NodeSize][]VMInfo)}
+	}
+}
+
+// PoolRefillInterval returns how often the VM pool is refilled, 0 if there is no pool
+func (h *JobHandler) PoolRefillInterval() time.Duration {
+	if h.pool == nil {
+		return 0
+	}
+	return h.pool.RefillInterval
+}
+
+func poolVMName(templateID int, size NodeSize, vmID int) string {
+	return fmt.Sprintf("%s%d-%s-%d", poolVMPrefix, templateID, size, vmID)
+}
+
+// parsePoolVMName returns the template and size of a pool VM from its name
+func parsePoolVMName(name string) (int, NodeSize, bool) {
+	rest, ok := strings.CutPrefix(name, poolVMPrefix)
+	if !ok {
+		return 0, "", false
+	}
+	parts := strings.Split(rest, "-")
+	if len(parts) != 3 {
+		return 0, "", false
+	}
+	templateID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", false
+	}
+	return templateID, NodeSize(parts[1]), true
+}
+
+// RefillPool clones the VMs missing from the pool. The pool VMs left by a previous run are taken back first,
+// unless they were cloned from another template.
+func (h *JobHandler) RefillPool() error {
+	if h.pool == nil {
+		return nil
+	}
+	h.pool.refill.Lock()
+	defer h.pool.refill.Unlock()
+
+	template, err := h.nodeTemplate(nil)
+	if err != nil {
+		return fmt.Errorf("failed to get pool template: %w", err)
+	}
+
+	vms, err := h.proxmoxCli.ListVMs()
+	if err != nil {
+		return fmt.Errorf("failed to list VMs: %w", err)
+	}
+	used := make(map[int]bool, len(vms))
+	for _, vm := range vms {
+		used[vm.VMID] = true
+	}
+	if !h.pool.loaded {
+		h.pool.mu.Lock()
+		for _, vm := range vms {
+			templateID, size, ok := parsePoolVMName(vm.Name)
+			if ok && templateID == template.VMID && vm.Status == VMStatusStopped {
+				h.pool.vms[size] = append(h.pool.vms[size], vm)
+			}
+		}
+		h.pool.template = template.VMID
+		h.pool.loaded = true
+		h.pool.mu.Unlock()
+	}
+
+	nextID := poolVMIDBase
+	for _, size := range []NodeSize{NodeSizeS1, NodeSizeS2, NodeSizeS4} {
+		for h.poolCount(size) < h.pool.Sizes[size] {
+			for used[nextID] {
+				nextID++
+			}
+			used[nextID] = true
+			vm, err := h.createPoolVM(template.VMID, size, nextID)
+			if err != nil {
+				return err
+			}
+			h.pool.mu.Lock()
+			h.pool.vms[size] = append(h.pool.vms[size], *vm)
+			h.pool.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// createPoolVM clones a pool VM on the template host and configures it for a node size
+func (h *JobHandler) createPoolVM(templateID int, size NodeSize, vmID int) (*VMInfo, error) {
+	if err := h.cloneVM(templateID, vmID, poolVMName(templateID, size, vmID), ""); err != nil {
+		return nil, err
+	}
+	cores, memory := size.Attrs()
+	t, err := h.proxmoxCli.ConfigureVM(vmID, cores, memory, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure VM: %w", err)
+	}
+	if _, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+		return nil, fmt.Errorf("failed to configure VM: %w", err)
+	}
+	vm, err := h.proxmoxCli.GetVMInfo(vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM info: %w", err)
+	}
+	return vm, nil
+}
+
+func (h *JobHandler) poolCount(size NodeSize) int {
+	h.pool.mu.Lock()
+	defer h.pool.mu.Unlock()
+	return len(h.pool.vms[size])
+}
+
+// claimPoolVM takes a pool VM of a template and size on the host, any host if empty, and names it after the node
+func (h *JobHandler) claimPoolVM(templateID int, size NodeSize, host string, name string) (int, bool) {
+	if h.pool == nil {
+		return 0, false
+	}
+
+	h.pool.mu.Lock()
+	if templateID != h.pool.template {
+		h.pool.mu.Unlock()
+		return 0, false
+	}
+	vms := h.pool.vms[size]
+	i := slices.IndexFunc(vms, func(vm VMInfo) bool { return host == "" || vm.NodeName == host })
+	if i < 0 {
+		h.pool.mu.Unlock()
+		return 0, false
+	}
+	vm := vms[i]
+	h.pool.vms[size] = slices.Delete(vms, i, i+1)
+	h.pool.mu.Unlock()
+
+	// The VM is returned to the pool if it can not be renamed, the node is cloned instead
+	t, err := h.proxmoxCli.RenameVM(vm.VMID, name)
+	if err == nil {
+		_, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute)
+	}
+	if err != nil {
+		log.Printf("Failed to claim pool VM %d: %v", vm.VMID, err)
+		h.pool.mu.Lock()
+		h.pool.vms[size] = append(h.pool.vms[size], vm)
+		h.pool.mu.Unlock()
+		return 0, false
+	}
+
+	return vm.VMID, true
+}
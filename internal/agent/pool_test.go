@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerLinkedClones(t *testing.T) {
+	for _, tc := range []struct {
+		storageType string
+		linked      bool
+	}{
+		{"lvmthin", true},
+		{"lvm", false},
+	} {
+		t.Run(tc.storageType, func(t *testing.T) {
+			fulcrumCli := NewMockFulcrumClient()
+			proxmoxCli := NewMockProxmoxClient("test-node")
+			proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+			proxmoxCli.SetStorageStatus("local-lvm", StorageStatus{Type: tc.storageType, Total: 1 << 40, Available: 1 << 40})
+			jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(),
+				WithClones(CloneConfig{Linked: true, Storage: "local-lvm"}))
+
+			require.NoError(t, fulcrumCli.CreateService("clone-service", "clone-cluster", nil, &Properties{Nodes: []Node{{ID: "node1", Size: NodeSizeS1}}}))
+			require.NoError(t, jobHandler.PollAndProcessJobs())
+			require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+			service, err := fulcrumCli.GetService("clone-service")
+			require.NoError(t, err)
+			vm, ok := proxmoxCli.GetVM(service.Resources.Nodes["node1"])
+			require.True(t, ok)
+			require.Equal(t, tc.linked, vm.Linked)
+		})
+	}
+}
+
+func TestJobHandlerVMPool(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	pool := PoolConfig{Sizes: map[NodeSize]int{NodeSizeS2: 2}}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), WithVMPool(pool))
+	require.Equal(t, DefaultPoolRefillInterval, jobHandler.PoolRefillInterval())
+
+	// The pool VMs are configured for their size but not started
+	require.NoError(t, jobHandler.RefillPool())
+	for _, id := range []int{10000, 10001} {
+		vm, ok := proxmoxCli.GetVM(id)
+		require.True(t, ok)
+		require.Equal(t, poolVMName(100, NodeSizeS2, id), vm.Name)
+		require.Equal(t, VMStatusStopped, vm.Status)
+		require.Equal(t, 4, vm.Cores)
+		require.Equal(t, 4096, vm.Memory)
+	}
+
+	// Nodes of the pool sizes take a pool VM, the others are cloned
+	nodes := []Node{{ID: "node1", Size: NodeSizeS2}, {ID: "node2", Size: NodeSizeS1}}
+	require.NoError(t, fulcrumCli.CreateService("pool-service", "pool-cluster", nil, &Properties{Nodes: nodes}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err := fulcrumCli.GetService("pool-service")
+	require.NoError(t, err)
+	require.Equal(t, 10000, service.Resources.Nodes["node1"])
+	require.Equal(t, jobHandler.generateVMID("pool-cluster", "node2"), service.Resources.Nodes["node2"])
+	vm, ok := proxmoxCli.GetVM(10000)
+	require.True(t, ok)
+	require.Equal(t, vmName("pool-cluster", "node1"), vm.Name)
+	require.Contains(t, vm.CloudInit, "kube-agent-ci-pool-cluster-node-node1.yml")
+
+	// The claimed VM is replaced
+	require.NoError(t, jobHandler.RefillPool())
+	require.Equal(t, 2, jobHandler.poolCount(NodeSizeS2))
+	vm, ok = proxmoxCli.GetVM(10002)
+	require.True(t, ok)
+	require.Equal(t, poolVMName(100, NodeSizeS2, 10002), vm.Name)
+
+	// A restarted agent takes back the pool VMs
+	restarted := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), WithVMPool(pool))
+	require.NoError(t, restarted.RefillPool())
+	require.Equal(t, 2, restarted.poolCount(NodeSizeS2))
+	_, ok = proxmoxCli.GetVM(10003)
+	require.False(t, ok)
+}
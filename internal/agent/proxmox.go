@@ -6,18 +6,77 @@ import (
 
 // ProxmoxClient defines the interface for interacting with Proxmox VE API
 type ProxmoxClient interface {
-	// CloneVM creates a new VM by cloning from a template
-	CloneVM(templateID int, newVMID int, name string) (*TaskResponse, error)
+	// CloneVM creates a new VM by cloning from a template, on the target host or the template one if empty.
+	// Linked clones share the template disks, full clones copy them to the default storage.
+	CloneVM(templateID int, newVMID int, name string, target string, linked bool) (*TaskResponse, error)
+
+	// ListVMs lists the VMs of the Proxmox cluster, templates excluded
+	ListVMs() ([]VMInfo, error)
+
+	// GetHosts lists the hosts of the Proxmox cluster with their load
+	GetHosts() ([]HostStatus, error)
+
+	// GetStorageStatus retrieves the usage of a storage of a host, the default one if empty
+	GetStorageStatus(host string, storage string) (*StorageStatus, error)
 
 	// ConfigureVM configures a VM (CPU, memory, cloud-init)
 	ConfigureVM(vmID int, cores int, memory int, cloudInitConfig string) (*TaskResponse, error)
 
+	// RenameVM changes the name of a VM
+	RenameVM(vmID int, name string) (*TaskResponse, error)
+
+	// CreateVM creates an empty VM on the configured host, with a network device and a virtio SCSI controller
+	CreateVM(vmID int, name string, cores int, memory int, netConfig string) (*TaskResponse, error)
+
+	// ImportDisk imports a disk image volume, such as 'local:import/image.qcow2', into a new disk of a VM on a storage
+	ImportDisk(vmID int, drive string, storage string, volume string) (*TaskResponse, error)
+
+	// AttachCloudInitDrive adds a cloud-init drive to a VM on a storage
+	AttachCloudInitDrive(vmID int, drive string, storage string) (*TaskResponse, error)
+
+	// SetVMConfig sets options of the configuration of a VM, such as 'serial0' or 'agent'
+	SetVMConfig(vmID int, options map[string]string) (*TaskResponse, error)
+
+	// GetVMDescription retrieves the description of a VM
+	GetVMDescription(vmID int) (string, error)
+
+	// ConvertToTemplate converts a stopped VM into a template
+	ConvertToTemplate(vmID int) (*TaskResponse, error)
+
+	// ConfigureNIC configures the network device of a VM (net0)
+	ConfigureNIC(vmID int, netConfig string) (*TaskResponse, error)
+
+	// ConfigureNetwork configures the cloud-init network of a VM (ipconfig0 and name servers)
+	ConfigureNetwork(vmID int, ipConfig string, nameservers []string) (*TaskResponse, error)
+
+	// AttachCDROM attaches a volume to a drive of a VM as a CD-ROM
+	AttachCDROM(vmID int, drive string, volume string) (*TaskResponse, error)
+
+	// DeleteVMConfig removes options from the configuration of a VM, such as 'cicustom' or a drive
+	DeleteVMConfig(vmID int, options []string) (*TaskResponse, error)
+
+	// UploadISO uploads an ISO image to a storage
+	UploadISO(storage string, fileName string, content []byte) (*TaskResponse, error)
+
 	// StartVM starts a virtual machine
 	StartVM(vmID int) (*TaskResponse, error)
 
-	// StopVM stops a virtual machine
+	// StopVM stops a virtual machine right away, like pulling the power cord
 	StopVM(vmID int) (*TaskResponse, error)
 
+	// ShutdownVM asks the guest of a virtual machine to shut down, through ACPI or its guest agent.
+	// The task fails if the VM is still running after the timeout, unless forceStop stops it then.
+	ShutdownVM(vmID int, timeout time.Duration, forceStop bool) (*TaskResponse, error)
+
+	// RebootVM asks the guest of a virtual machine to reboot
+	RebootVM(vmID int) (*TaskResponse, error)
+
+	// SuspendVM pauses a virtual machine
+	SuspendVM(vmID int) (*TaskResponse, error)
+
+	// ResumeVM resumes a paused virtual machine
+	ResumeVM(vmID int) (*TaskResponse, error)
+
 	// DeleteVM deletes a virtual machine
 	DeleteVM(vmID int) (*TaskResponse, error)
 
@@ -29,6 +88,97 @@ type ProxmoxClient interface {
 
 	// GetVMInfo retrieves the current status of a virtual machine
 	GetVMInfo(vmID int) (*VMInfo, error)
+
+	// AgentPing checks that the QEMU guest agent of a VM responds
+	AgentPing(vmID int) error
+
+	// AgentNetworkInterfaces lists the network interfaces of a VM as reported by its QEMU guest agent
+	AgentNetworkInterfaces(vmID int) ([]GuestNetworkInterface, error)
+
+	// AgentExec starts a command in a VM through its QEMU guest agent and returns its PID
+	AgentExec(vmID int, command []string) (int, error)
+
+	// AgentExecStatus retrieves the status and output of a command started through the QEMU guest agent
+	AgentExecStatus(vmID int, pid int) (*GuestExecStatus, error)
+
+	// EnsureRole creates a role with the given privileges, or updates the privileges of an existing one
+	EnsureRole(roleID string, privileges []string) error
+
+	// CreateAPIToken creates a user and a privilege separated API token for it
+	CreateAPIToken(userID string, tokenName string) (*APIToken, error)
+
+	// GrantPermission grants a role to an API token on the given ACL path
+	GrantPermission(path string, tokenID string, roleID string) error
+
+	// DeleteUser deletes a user together with its API tokens and permissions
+	DeleteUser(userID string) error
+
+	// DeleteVolume deletes a storage volume through a host, the default one if empty.
+	// The returned task is nil if it completed synchronously.
+	DeleteVolume(host string, volumeID string) (*TaskResponse, error)
+
+	// SetHAResource registers a VM as an HA resource of the group with the requested state, or updates it
+	SetHAResource(vmID int, group string, state string) error
+
+	// RemoveHAResource unregisters a VM from HA, if it is registered
+	RemoveHAResource(vmID int) error
+
+	// EnsureIPSet creates a cluster IP set if it does not exist yet
+	EnsureIPSet(name string, comment string) error
+
+	// AddIPSetEntry adds an address to a cluster IP set if it is not there yet
+	AddIPSetEntry(name string, cidr string) error
+
+	// RemoveIPSetEntry removes an address from a cluster IP set
+	RemoveIPSetEntry(name string, cidr string) error
+
+	// DeleteIPSet deletes a cluster IP set together with its entries
+	DeleteIPSet(name string) error
+
+	// EnsureSecurityGroup creates a cluster security group, or replaces the rules of an existing one
+	EnsureSecurityGroup(name string, rules []FirewallRule) error
+
+	// DeleteSecurityGroup deletes a cluster security group together with its rules
+	DeleteSecurityGroup(name string) error
+
+	// EnableVMFirewall enables the firewall of a VM and applies the security group to it
+	EnableVMFirewall(vmID int, group string) error
+}
+
+// HostStatus represents a host of the Proxmox cluster
+type HostStatus struct {
+	Name      string
+	Online    bool
+	CPU       float64 // Current CPU usage (0-1 range)
+	MaxCPU    int     // Number of CPUs
+	Memory    int64   // Current memory usage in bytes
+	MaxMemory int64   // Memory size in bytes
+
+	AllocatedCPU    int   // vCPUs of the VMs of the host, stopped ones included
+	AllocatedMemory int64 // Memory of the VMs of the host in bytes, stopped ones included
+}
+
+// StorageStatus represents the usage of a storage
+type StorageStatus struct {
+	Type      string `json:"type"`   // Such as 'lvmthin', 'zfspool' or 'dir'
+	Total     int64  `json:"total"`  // Size in bytes
+	Used      int64  `json:"used"`   // Used space in bytes
+	Available int64  `json:"avail"`  // Free space in bytes
+	Shared    bool   `json:"shared"` // Whether the storage is shared by all the hosts
+}
+
+// FirewallRule represents a Proxmox firewall rule
+type FirewallRule struct {
+	Type    string // Such as 'in', 'out' or 'group'
+	Action  string // Such as 'ACCEPT' or 'DROP', or the security group name of the group rules
+	Source  string // Source address, CIDR or '+ipset', any if empty
+	Comment string
+}
+
+// APIToken represents a Proxmox API token
+type APIToken struct {
+	TokenID string `json:"full-tokenid"` // Such as 'user@pve!token'
+	Secret  string `json:"value"`        // Token secret, only returned on creation
 }
 
 // TaskResponse represents a Proxmox API response containing a task ID
@@ -80,4 +230,27 @@ type VMInfo struct {
 	MaxDisk   int64    `json:"maxdisk"`   // Maximum disk size in bytes
 	Uptime    int64    `json:"uptime"`    // Uptime in seconds
 	QMPStatus string   `json:"qmpstatus"` // QEMU Machine Protocol status
+	Template  int      `json:"template"`  // 1 for templates
+}
+
+// GuestNetworkInterface represents a network interface reported by the QEMU guest agent
+type GuestNetworkInterface struct {
+	Name            string           `json:"name"`
+	HardwareAddress string           `json:"hardware-address"`
+	IPAddresses     []GuestIPAddress `json:"ip-addresses"`
+}
+
+// GuestIPAddress represents an address of a network interface reported by the QEMU guest agent
+type GuestIPAddress struct {
+	Type    string `json:"ip-address-type"` // 'ipv4' or 'ipv6'
+	Address string `json:"ip-address"`
+	Prefix  int    `json:"prefix"`
+}
+
+// GuestExecStatus represents the status of a command started through the QEMU guest agent
+type GuestExecStatus struct {
+	Exited   bool
+	ExitCode int
+	OutData  string // Standard output, once exited
+	ErrData  string // Standard error, once exited
 }
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+)
+
+// QuotaResources holds amounts of the resources of the nodes
+type QuotaResources struct {
+	CPU    int // vCPUs
+	Memory int // Memory in MB
+	Disk   int // Disk in GB
+}
+
+func (r *QuotaResources) add(o QuotaResources) {
+	r.CPU += o.CPU
+	r.Memory += o.Memory
+	r.Disk += o.Disk
+}
+
+// exceeded describes the first resource exceeding its limit, the zero limits are unlimited
+func (r QuotaResources) exceeded(limits QuotaResources) string {
+	switch {
+	case limits.CPU > 0 && r.CPU > limits.CPU:
+		return fmt.Sprintf("%d vCPUs exceed the limit of %d", r.CPU, limits.CPU)
+	case limits.Memory > 0 && r.Memory > limits.Memory:
+		return fmt.Sprintf("%d MB of memory exceed the limit of %d MB", r.Memory, limits.Memory)
+	case limits.Disk > 0 && r.Disk > limits.Disk:
+		return fmt.Sprintf("%d GB of disk exceed the limit of %d GB", r.Disk, limits.Disk)
+	}
+	return ""
+}
+
+// QuotaConfig holds the limits of the resources the services request, zero values are unlimited.
+// Each service is a tenant with its own control plane.
+type QuotaConfig struct {
+	MaxNodesPerService int
+	MaxTenants         int
+	Tenant             QuotaResources // Limits of the nodes of each tenant
+	Agent              QuotaResources // Limits of the nodes of all the tenants of the agent
+}
+
+// WithQuotas returns an option that rejects the jobs exceeding the quotas before creating any resource
+func WithQuotas(cfg QuotaConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.quotas = &cfg
+	}
+}
+
+// nodeResources sums the resources of the nodes of a service, each one with the disk size of its template.
+// The disk sizes in GB are looked up once per template and kept in disks.
+func (h *JobHandler) nodeResources(props *Properties, disks map[int]int) (QuotaResources, error) {
+	var res QuotaResources
+	if len(props.Nodes) == 0 {
+		return res, nil
+	}
+	nodeTemplate, err := h.nodeTemplate(props)
+	if err != nil {
+		return res, err
+	}
+	disk, ok := disks[nodeTemplate.VMID]
+	if !ok {
+		template, err := h.proxmoxCli.GetVMInfo(nodeTemplate.VMID)
+		if err != nil {
+			return res, fmt.Errorf("failed to get template info: %w", err)
+		}
+		disk = int(template.MaxDisk >> 30)
+		disks[nodeTemplate.VMID] = disk
+	}
+	for _, node := range props.Nodes {
+		cores, memory := node.Size.Attrs()
+		res.add(QuotaResources{CPU: cores, Memory: memory, Disk: disk})
+	}
+	return res, nil
+}
+
+// checkQuotas fails if the target properties of a service exceed the quotas, given the other services of the agent
+func (h *JobHandler) checkQuotas(job *Job, props *Properties) error {
+	if h.quotas == nil {
+		return nil
+	}
+
+	if h.quotas.MaxNodesPerService > 0 && len(props.Nodes) > h.quotas.MaxNodesPerService {
+		return fmt.Errorf("quota exceeded: %d nodes exceed the limit of %d per service",
+			len(props.Nodes), h.quotas.MaxNodesPerService)
+	}
+
+	disks := make(map[int]int)
+	tenant, err := h.nodeResources(props, disks)
+	if err != nil {
+		return err
+	}
+	if msg := tenant.exceeded(h.quotas.Tenant); msg != "" {
+		return fmt.Errorf("quota exceeded: tenant %s", msg)
+	}
+
+	// The other services are accounted for with the properties they were last created or updated with
+	var total QuotaResources
+	tenants := 1
+	page := 1
+	for {
+		services, err := h.fulcrumCli.GetServices(page)
+		if err != nil {
+			return fmt.Errorf("failed to list services: %w", err)
+		}
+		for _, service := range services.Items {
+			if service.ID == job.Service.ID || service.CurrentProperties == nil || service.CurrentStatus == ServiceDeleted {
+				continue
+			}
+			tenants++
+			// A service whose template left the registry must not fail the jobs of the others
+			res, err := h.nodeResources(service.CurrentProperties, disks)
+			if err != nil {
+				log.Printf("Skipping the nodes of service %s from the agent quotas: %v", service.ID, err)
+				continue
+			}
+			total.add(res)
+		}
+		if !services.HasNext {
+			break
+		}
+		page++
+	}
+
+	if job.Action == JobActionServiceCreate && h.quotas.MaxTenants > 0 && tenants > h.quotas.MaxTenants {
+		return fmt.Errorf("quota exceeded: the agent already serves %d tenants", h.quotas.MaxTenants)
+	}
+	total.add(tenant)
+	if msg := total.exceeded(h.quotas.Agent); msg != "" {
+		return fmt.Errorf("quota exceeded: agent %s", msg)
+	}
+
+	return nil
+}
+
+// quotaMetrics returns the consumption of the quotas of a service
+func (h *JobHandler) quotaMetrics(service *Service) ([]MetricEntry, error) {
+	res, err := h.nodeResources(service.CurrentProperties, make(map[int]int))
+	if err != nil {
+		return nil, err
+	}
+	return []MetricEntry{
+		{ExternalID: *service.ExternalID, ResourceID: quotaResourceID, Value: float64(len(service.CurrentProperties.Nodes)), TypeName: MetricTypeQuotaNodes},
+		{ExternalID: *service.ExternalID, ResourceID: quotaResourceID, Value: float64(res.CPU), TypeName: MetricTypeQuotaCPU},
+		{ExternalID: *service.ExternalID, ResourceID: quotaResourceID, Value: float64(res.Memory), TypeName: MetricTypeQuotaMemory},
+		{ExternalID: *service.ExternalID, ResourceID: quotaResourceID, Value: float64(res.Disk), TypeName: MetricTypeQuotaDisk},
+	}, nil
+}
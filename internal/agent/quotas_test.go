@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerQuotas(t *testing.T) {
+	newHandler := func(cfg QuotaConfig) (*MockFulcrumClient, *MockProxmoxClient, *JobHandler) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("test-node")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), WithQuotas(cfg))
+		return fulcrumCli, proxmoxCli, jobHandler
+	}
+	nodes := func(size NodeSize, ids ...string) []Node {
+		var nodes []Node
+		for _, id := range ids {
+			nodes = append(nodes, Node{ID: id, Size: size, Status: NodeStatusOff})
+		}
+		return nodes
+	}
+	requireFailed := func(t *testing.T, fulcrumCli *MockFulcrumClient, msg string) {
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, msg)
+	}
+
+	t.Run("nodes per service", func(t *testing.T) {
+		fulcrumCli, proxmoxCli, jobHandler := newHandler(QuotaConfig{MaxNodesPerService: 2})
+
+		require.NoError(t, fulcrumCli.CreateService("nodes-service", "nodes-cluster", nil, &Properties{Nodes: nodes(NodeSizeS1, "node1", "node2", "node3")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		requireFailed(t, fulcrumCli, "quota exceeded: 3 nodes exceed the limit of 2 per service")
+		require.Len(t, proxmoxCli.vms, 1)
+	})
+
+	t.Run("tenant resources", func(t *testing.T) {
+		fulcrumCli, _, jobHandler := newHandler(QuotaConfig{Tenant: QuotaResources{CPU: 8, Disk: 30}})
+
+		require.NoError(t, fulcrumCli.CreateService("tenant-service", "tenant-cluster", nil, &Properties{Nodes: nodes(NodeSizeS4, "node1")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		require.NoError(t, fulcrumCli.StartService("tenant-service"))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		// Updates are checked against all the target nodes
+		require.NoError(t, fulcrumCli.UpdateService("tenant-service", &Properties{Nodes: nodes(NodeSizeS4, "node1", "node2")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		requireFailed(t, fulcrumCli, "quota exceeded: tenant 16 vCPUs exceed the limit of 8")
+
+		// Each node takes the 10 GB disk of the template
+		require.NoError(t, fulcrumCli.CreateService("disk-service", "disk-cluster", nil, &Properties{Nodes: nodes(NodeSizeS1, "node1", "node2", "node3", "node4")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		requireFailed(t, fulcrumCli, "quota exceeded: tenant 40 GB of disk exceed the limit of 30 GB")
+	})
+
+	t.Run("agent resources and tenants", func(t *testing.T) {
+		fulcrumCli, _, jobHandler := newHandler(QuotaConfig{MaxTenants: 2, Agent: QuotaResources{Memory: 10240}})
+
+		require.NoError(t, fulcrumCli.CreateService("service1", "cluster1", nil, &Properties{Nodes: nodes(NodeSizeS2, "node1", "node2")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		// The memory of the nodes of the other tenants counts towards the agent quota
+		require.NoError(t, fulcrumCli.CreateService("service2", "cluster2", nil, &Properties{Nodes: nodes(NodeSizeS2, "node1")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		requireFailed(t, fulcrumCli, "quota exceeded: agent 12288 MB of memory exceed the limit of 10240 MB")
+
+		require.NoError(t, fulcrumCli.CreateService("service3", "cluster3", nil, &Properties{Nodes: nodes(NodeSizeS1, "node1")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		require.NoError(t, fulcrumCli.CreateService("service4", "cluster4", nil, nil))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		requireFailed(t, fulcrumCli, "quota exceeded: the agent already serves 2 tenants")
+	})
+
+	t.Run("unresolved templates", func(t *testing.T) {
+		fulcrumCli, proxmoxCli, _ := newHandler(QuotaConfig{MaxTenants: 2, Agent: QuotaResources{Memory: 10240}})
+		registry := TemplateRegistry{Templates: []NodeTemplate{
+			{OS: OSUbuntu, KubeVersion: "v1.30.2", VMID: 200},
+			{OS: OSUbuntu, KubeVersion: "v1.31.1", VMID: 201},
+		}}
+		for _, tmpl := range registry.Templates {
+			proxmoxCli.AddVM(tmpl.VMID, "template-"+tmpl.KubeVersion, VMStatusStopped, 2, 2048).Template = true
+		}
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(),
+			WithQuotas(QuotaConfig{MaxTenants: 2, Agent: QuotaResources{Memory: 10240}}), WithTemplateRegistry(registry))
+
+		props := &Properties{Nodes: nodes(NodeSizeS2, "node1", "node2"), KubeVersion: "v1.31.1"}
+		require.NoError(t, fulcrumCli.CreateService("service1", "cluster1", nil, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		// A service whose template left the registry still counts as a tenant, without failing the other jobs
+		jobHandler.templates.Templates = jobHandler.templates.Templates[:1]
+		require.NoError(t, fulcrumCli.CreateService("service2", "cluster2", nil, &Properties{Nodes: nodes(NodeSizeS2, "node1")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		require.NoError(t, fulcrumCli.CreateService("service3", "cluster3", nil, nil))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		requireFailed(t, fulcrumCli, "quota exceeded: the agent already serves 2 tenants")
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		fulcrumCli, proxmoxCli, jobHandler := newHandler(QuotaConfig{MaxTenants: 10})
+		reporter := NewMetricsReporter(fulcrumCli, proxmoxCli)
+		reporter.quotas = jobHandler
+
+		externalID := "metrics-cluster"
+		require.NoError(t, fulcrumCli.CreateService("metrics-service", "metrics-cluster", &externalID, &Properties{Nodes: nodes(NodeSizeS2, "node1", "node2")}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		// The consumption of the created services is reported, whether started or not
+		require.NoError(t, reporter.Report())
+		require.ElementsMatch(t, []MetricEntry{
+			{ExternalID: externalID, ResourceID: quotaResourceID, Value: 2, TypeName: MetricTypeQuotaNodes},
+			{ExternalID: externalID, ResourceID: quotaResourceID, Value: 8, TypeName: MetricTypeQuotaCPU},
+			{ExternalID: externalID, ResourceID: quotaResourceID, Value: 8192, TypeName: MetricTypeQuotaMemory},
+			{ExternalID: externalID, ResourceID: quotaResourceID, Value: 20, TypeName: MetricTypeQuotaDisk},
+		}, fulcrumCli.PullMetrics())
+	})
+}
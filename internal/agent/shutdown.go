@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long a node has to shut down by default before it is stopped
+const DefaultShutdownTimeout = 2 * time.Minute
+
+// ShutdownConfig holds how the nodes are powered off when they are stopped or deleted
+type ShutdownConfig struct {
+	Timeout  time.Duration // How long a node has to shut down before it is stopped
+	HardStop bool          // Stop the nodes right away instead of shutting them down
+}
+
+// WithShutdown returns an option configuring how the nodes are powered off.
+// By default they are shut down, and stopped if still running after DefaultShutdownTimeout.
+func WithShutdown(cfg ShutdownConfig) JobHandlerOption {
+	return func(h *JobHandler) {
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = DefaultShutdownTimeout
+		}
+		h.shutdown = cfg
+	}
+}
+
+// powerOff shuts a running node down, letting the kubelet and containers stop cleanly, and stops it if it is
+// still running after the timeout
+func (h *JobHandler) powerOff(vmID int) error {
+	if h.shutdown.HardStop {
+		t, err := h.proxmoxCli.StopVM(vmID)
+		if err != nil {
+			return fmt.Errorf("failed to stop VM: %w", err)
+		}
+		if _, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+			return fmt.Errorf("failed to stop VM: %w", err)
+		}
+		return nil
+	}
+
+	t, err := h.proxmoxCli.ShutdownVM(vmID, h.shutdown.Timeout, true)
+	if err != nil {
+		return fmt.Errorf("failed to shut down VM: %w", err)
+	}
+	// The task lasts up to the timeout, then stops the VM
+	status, err := h.proxmoxCli.WaitForTask(t.TaskID, h.shutdown.Timeout+1*time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed to shut down VM: %w", err)
+	}
+	if status.ExitStatus != "" && status.ExitStatus != "OK" {
+		return fmt.Errorf("failed to shut down VM: %s", status.ExitStatus)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerShutdown(t *testing.T) {
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}
+	startService := func(t *testing.T, options ...JobHandlerOption) (*MockFulcrumClient, *JobHandler, *VM) {
+		fulcrumCli := NewMockFulcrumClient()
+		proxmoxCli := NewMockProxmoxClient("test-node")
+		proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+		jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), options...)
+
+		require.NoError(t, fulcrumCli.CreateService("shutdown-service", "shutdown-cluster", nil, &Properties{Nodes: []Node{node}}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		require.NoError(t, fulcrumCli.StartService("shutdown-service"))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+		service, err := fulcrumCli.GetService("shutdown-service")
+		require.NoError(t, err)
+		vm, ok := proxmoxCli.GetVM(service.Resources.Nodes[node.ID])
+		require.True(t, ok)
+		require.Equal(t, VMStatusRunning, vm.Status)
+		return fulcrumCli, jobHandler, vm
+	}
+	stopService := func(t *testing.T, fulcrumCli *MockFulcrumClient, jobHandler *JobHandler) {
+		require.NoError(t, fulcrumCli.StopService("shutdown-service"))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		fulcrumCli, jobHandler, vm := startService(t)
+		stopService(t, fulcrumCli, jobHandler)
+		require.Equal(t, VMStatusStopped, vm.Status)
+		require.Equal(t, "shutdown", vm.StopMode)
+	})
+
+	t.Run("forced after the timeout", func(t *testing.T) {
+		fulcrumCli, jobHandler, vm := startService(t)
+		vm.IgnoreShutdown = true
+		stopService(t, fulcrumCli, jobHandler)
+		require.Equal(t, VMStatusStopped, vm.Status)
+		require.Equal(t, "stop", vm.StopMode)
+	})
+
+	t.Run("hard stop", func(t *testing.T) {
+		fulcrumCli, jobHandler, vm := startService(t, WithShutdown(ShutdownConfig{HardStop: true}))
+		stopService(t, fulcrumCli, jobHandler)
+		require.Equal(t, VMStatusStopped, vm.Status)
+		require.Equal(t, "stop", vm.StopMode)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		fulcrumCli, jobHandler, vm := startService(t)
+		vm.IgnoreShutdown = true
+		stopService(t, fulcrumCli, jobHandler)
+
+		// A node started again outside of the agent is shut down before being deleted
+		vm.Status = VMStatusRunning
+		vm.IgnoreShutdown = false
+		require.NoError(t, fulcrumCli.DeleteService("shutdown-service"))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		require.Equal(t, "shutdown", vm.StopMode)
+	})
+}
@@ -0,0 +1,225 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"fulcrumproject.org/kube-agent/internal/cloudinit"
+)
+
+// DefaultSeedDrive is the drive the NoCloud seed ISOs are attached to, replacing the cloud-init drive of the template
+const DefaultSeedDrive = "ide2"
+
+// CloudInitSeed holds the cloud-init configuration of a node
+type CloudInitSeed struct {
+	UserData      string
+	MetaData      string
+	NetworkConfig string // Network configuration version 2
+}
+
+// CloudInitDrive describes how a stored cloud-init configuration is attached to its VM
+type CloudInitDrive struct {
+	CICustom string `json:"cicustom,omitempty"` // Custom cloud-init files of the Proxmox cloud-init drive, such as 'user=local:snippets/file.yml'
+	Drive    string `json:"drive,omitempty"`    // Drive the seed volume is attached to as a CD-ROM, such as 'ide2'
+	Volume   string `json:"volume,omitempty"`   // Seed volume, such as 'local:iso/file.iso'
+}
+
+// SnippetStore stores the cloud-init configurations of the nodes where Proxmox reads them from
+type SnippetStore interface {
+	// Upload stores the cloud-init configuration of a VM
+	Upload(vmName string, seed *CloudInitSeed) (*CloudInitDrive, error)
+
+	// Delete deletes the stored cloud-init configuration of a VM
+	Delete(vmName string) error
+}
+
+// WithSnippetStore returns an option that stores the cloud-init configurations in the given store.
+// Without it they are copied into the snippets directory of the Proxmox host through SSH.
+func WithSnippetStore(store SnippetStore) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.snippets = store
+	}
+}
+
+// sshSnippetStore copies the cloud-init configurations into a snippets enabled storage through SSH
+type sshSnippetStore struct {
+	cli  SSHClient
+	path string // Snippets directory of the 'local' storage on the Proxmox host
+}
+
+func snippetFileName(vmName string) string {
+	return fmt.Sprintf("kube-agent-ci-%s.yml", vmName)
+}
+
+// Upload copies the user data, Proxmox generates the meta data and the network configuration from the VM options
+func (s *sshSnippetStore) Upload(vmName string, seed *CloudInitSeed) (*CloudInitDrive, error) {
+	fileName := snippetFileName(vmName)
+	if err := s.cli.Copy(seed.UserData, fmt.Sprintf("%s/%s", s.path, fileName)); err != nil {
+		return nil, fmt.Errorf("failed to copy cloud-init configuration: %w", err)
+	}
+	return &CloudInitDrive{CICustom: "user=local:snippets/" + fileName}, nil
+}
+
+// Delete deletes the copied user data
+func (s *sshSnippetStore) Delete(vmName string) error {
+	return s.cli.DeleteFile(fmt.Sprintf("%s/%s", s.path, snippetFileName(vmName)))
+}
+
+// ISOSnippetStore uploads the cloud-init configurations as NoCloud seed ISOs through the Proxmox API,
+// so that neither SSH access to the host nor a snippets enabled storage are needed
+type ISOSnippetStore struct {
+	cli     ProxmoxClient
+	storage string // Storage holding ISO images
+	drive   string // Drive the seed ISOs are attached to
+}
+
+// NewISOSnippetStore creates a store uploading seed ISOs to the storage, attached to the drive of the VMs
+func NewISOSnippetStore(cli ProxmoxClient, storage, drive string) *ISOSnippetStore {
+	if drive == "" {
+		drive = DefaultSeedDrive
+	}
+	return &ISOSnippetStore{cli: cli, storage: storage, drive: drive}
+}
+
+func seedFileName(vmName string) string {
+	return fmt.Sprintf("kube-agent-ci-%s.iso", vmName)
+}
+
+// Upload builds the seed ISO of a VM and uploads it
+func (s *ISOSnippetStore) Upload(vmName string, seed *CloudInitSeed) (*CloudInitDrive, error) {
+	iso, err := cloudinit.NewNoCloudSeed(seed.UserData, seed.MetaData, seed.NetworkConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build cloud-init seed ISO: %w", err)
+	}
+
+	// Proxmox refuses to overwrite the image left by a failed attempt for the same VM, which is usually missing
+	if t, err := s.cli.DeleteVolume("", s.volume(vmName)); err == nil && t != nil {
+		_, _ = s.cli.WaitForTask(t.TaskID, 1*time.Minute)
+	}
+
+	t, err := s.cli.UploadISO(s.storage, seedFileName(vmName), iso)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload cloud-init seed ISO: %w", err)
+	}
+	if _, err := s.cli.WaitForTask(t.TaskID, 5*time.Minute); err != nil {
+		return nil, fmt.Errorf("failed to upload cloud-init seed ISO: %w", err)
+	}
+
+	return &CloudInitDrive{Drive: s.drive, Volume: s.volume(vmName)}, nil
+}
+
+// Delete deletes the seed ISO of a VM
+func (s *ISOSnippetStore) Delete(vmName string) error {
+	t, err := s.cli.DeleteVolume("", s.volume(vmName))
+	if err != nil {
+		return fmt.Errorf("failed to delete cloud-init seed ISO: %w", err)
+	}
+	if t != nil {
+		if _, err := s.cli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+			return fmt.Errorf("failed to delete cloud-init seed ISO: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateShared checks that the seed ISOs can be read by all the hosts, as the VMs placed on any host need them
+func (s *ISOSnippetStore) ValidateShared() error {
+	status, err := s.cli.GetStorageStatus("", s.storage)
+	if err != nil {
+		return fmt.Errorf("failed to get storage %s status: %w", s.storage, err)
+	}
+	if !status.Shared {
+		return fmt.Errorf("storage %s is not shared by the hosts", s.storage)
+	}
+	return nil
+}
+
+func (s *ISOSnippetStore) volume(vmName string) string {
+	return fmt.Sprintf("%s:iso/%s", s.storage, seedFileName(vmName))
+}
+
+// uploadCloudInit stores the cloud-init configuration of a VM and attaches it.
+// The stored configuration is deleted again if it can not be attached.
+func (h *JobHandler) uploadCloudInit(vmID int, vmName string, seed *CloudInitSeed, cores, memory int) (*CloudInitDrive, error) {
+	if h.snippets == nil {
+		return nil, fmt.Errorf("no cloud-init snippet store configured")
+	}
+	drive, err := h.snippets.Upload(vmName, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.attachCloudInit(vmID, drive, cores, memory); err != nil {
+		h.deleteCloudInit(vmName)
+		return nil, err
+	}
+
+	return drive, nil
+}
+
+// attachCloudInit configures a VM with its stored cloud-init configuration
+func (h *JobHandler) attachCloudInit(vmID int, drive *CloudInitDrive, cores, memory int) error {
+	t, err := h.proxmoxCli.ConfigureVM(vmID, cores, memory, drive.CICustom)
+	if err != nil {
+		return fmt.Errorf("failed to configure VM: %w", err)
+	}
+	if _, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+		return fmt.Errorf("failed to configure VM: %w", err)
+	}
+
+	if drive.Volume != "" {
+		t, err := h.proxmoxCli.AttachCDROM(vmID, drive.Drive, drive.Volume)
+		if err != nil {
+			return fmt.Errorf("failed to attach cloud-init seed: %w", err)
+		}
+		if _, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+			return fmt.Errorf("failed to attach cloud-init seed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteCloudInit deletes the stored cloud-init configuration of a VM whose creation failed
+func (h *JobHandler) deleteCloudInit(vmName string) {
+	if err := h.snippets.Delete(vmName); err != nil {
+		log.Printf("Failed to delete cloud-init configuration of %s: %v", vmName, err)
+	}
+}
+
+// removeSnippet deletes the stored cloud-init configuration of a node, which holds its bootstrap token.
+// It is detached from the VM first, unless the VM is deleted as well.
+func (h *JobHandler) removeSnippet(resources *Resources, vmID int, serviceName, nodeID string, detach bool) error {
+	drive, ok := resources.Snippets[nodeID]
+	if !ok {
+		return nil
+	}
+	if h.snippets == nil {
+		return fmt.Errorf("no cloud-init snippet store configured")
+	}
+
+	if detach {
+		var options []string
+		if drive.CICustom != "" {
+			options = append(options, "cicustom")
+		}
+		if drive.Volume != "" {
+			options = append(options, drive.Drive)
+		}
+		t, err := h.proxmoxCli.DeleteVMConfig(vmID, options)
+		if err != nil {
+			return fmt.Errorf("failed to detach cloud-init configuration: %w", err)
+		}
+		if _, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute); err != nil {
+			return fmt.Errorf("failed to detach cloud-init configuration: %w", err)
+		}
+	}
+
+	if err := h.snippets.Delete(vmName(serviceName, nodeID)); err != nil {
+		return fmt.Errorf("failed to delete cloud-init configuration: %w", err)
+	}
+	delete(resources.Snippets, nodeID)
+
+	return nil
+}
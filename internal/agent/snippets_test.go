@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerISOSnippetStore(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	store := NewISOSnippetStore(proxmoxCli, "local", "")
+	subnet := Subnet{Name: "default", CIDR: "10.0.0.0/24", Gateway: "10.0.0.254", DNS: []string{"10.0.0.53"}}
+
+	// The agent works without SSH client
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "", kamajiCli, nil, WithSnippetStore(store), WithIPAM(NewMockIPAM()), WithNodeSubnets(subnet))
+
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}
+	require.NoError(t, fulcrumCli.CreateService("iso-service", "iso-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	// The seed ISO replaces the cloud-init drive of the template, without custom snippets
+	volume := "local:iso/kube-agent-ci-" + vmName("iso-cluster", "node1") + ".iso"
+	vm, ok := proxmoxCli.GetVM(jobHandler.generateVMID("iso-cluster", "node1"))
+	require.True(t, ok)
+	require.Empty(t, vm.CloudInit)
+	require.Equal(t, map[string]string{DefaultSeedDrive: volume}, vm.CDROM)
+
+	iso, ok := proxmoxCli.GetISO(volume)
+	require.True(t, ok)
+	require.True(t, bytes.Contains(iso, []byte("kind: JoinConfiguration")))
+	require.True(t, bytes.Contains(iso, []byte(`local-hostname: "`+vmName("iso-cluster", "node1")+`"`)))
+
+	// The seed ISO carries the static address, Proxmox only writes it into its own cloud-init drive
+	require.True(t, bytes.Contains(iso, []byte("- 10.0.0.1/24")))
+	require.True(t, bytes.Contains(iso, []byte("via: 10.0.0.254")))
+
+	// A stale image of a failed attempt is replaced
+	_, err := store.Upload(vmName("iso-cluster", "node1"), &CloudInitSeed{UserData: "#cloud-config\n"})
+	require.NoError(t, err)
+	iso, ok = proxmoxCli.GetISO(volume)
+	require.True(t, ok)
+	require.False(t, bytes.Contains(iso, []byte("kind: JoinConfiguration")))
+
+	require.NoError(t, store.Delete(vmName("iso-cluster", "node1")))
+	require.False(t, proxmoxCli.HasVolume(volume))
+
+	// The VMs placed on other hosts need the seed ISOs on a shared storage
+	require.ErrorContains(t, store.ValidateShared(), "storage local is not shared by the hosts")
+	proxmoxCli.SetStorageStatus("local", StorageStatus{Type: "cephfs", Shared: true})
+	require.NoError(t, store.ValidateShared())
+
+	// Nodes can not be created without snippet store
+	jobHandler = NewJobHandler(fulcrumCli, proxmoxCli, 100, "", kamajiCli, nil)
+	require.NoError(t, fulcrumCli.CreateService("no-store-service", "no-store-cluster", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+}
+
+func TestJobHandlerSnippetCleanup(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	sshCli := NewMockSSHClient()
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), sshCli)
+
+	serviceID := "snippet-service"
+	serviceName := "snippet-cluster"
+	node1 := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOn}
+	node2 := Node{ID: "node2", Size: NodeSizeS1, Status: NodeStatusOff}
+	snippet := func(nodeID string) string { return "path/" + snippetFileName(vmName(serviceName, nodeID)) }
+
+	// The snippets are tracked until the nodes join
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, &Properties{Nodes: []Node{node1}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Contains(t, service.Resources.Snippets, "node1")
+	require.True(t, sshCli.FileExists(snippet("node1")))
+
+	// Once joined the snippet holding the bootstrap token is detached and deleted
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	service, err = fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Empty(t, service.Resources.Snippets)
+	require.False(t, sshCli.FileExists(snippet("node1")))
+	vm, ok := proxmoxCli.GetVM(service.Resources.Nodes["node1"])
+	require.True(t, ok)
+	require.Empty(t, vm.CloudInit)
+
+	// A node added to the stopped service keeps its snippet until the service is deleted
+	require.NoError(t, fulcrumCli.StopService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, &Properties{Nodes: []Node{node1, node2}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	service, err = fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Contains(t, service.Resources.Snippets, "node2")
+	require.True(t, sshCli.FileExists(snippet("node2")))
+
+	// The snippets of the nodes that never joined are deleted with the service, even when another node fails
+	_, err = proxmoxCli.DeleteVM(service.Resources.Nodes["node1"])
+	require.NoError(t, err)
+	require.NoError(t, fulcrumCli.DeleteService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+	require.False(t, sshCli.FileExists(snippet("node2")))
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"fulcrumproject.org/kube-agent/internal/cloudinit"
+)
+
+// Subnet represents a network the worker node addresses are statically allocated from
+type Subnet struct {
+	Name    string
+	CIDR    string   // Such as '192.168.1.0/24'
+	Gateway string   // Default gateway of the nodes
+	DNS     []string // Name servers of the nodes
+}
+
+// SubnetPool returns the IPAM pool of a subnet
+func SubnetPool(name string) string {
+	return fmt.Sprintf("subnet-%s", name)
+}
+
+// WithNodeSubnets returns an option that assigns static addresses to the worker nodes.
+// Services select one of the subnets by name, the first one is used by default.
+// The addresses are allocated through the IPAM, which is required as well.
+func WithNodeSubnets(subnets ...Subnet) JobHandlerOption {
+	return func(h *JobHandler) {
+		h.subnets = subnets
+	}
+}
+
+// nodeSubnet returns the subnet selected by a service, nil if static addressing is not enabled
+func (h *JobHandler) nodeSubnet(name string) (*Subnet, error) {
+	if len(h.subnets) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("subnets are not enabled on this agent")
+		}
+		return nil, nil
+	}
+	if h.ipam == nil {
+		return nil, fmt.Errorf("subnets require IPAM to be enabled")
+	}
+	if name == "" {
+		return &h.subnets[0], nil
+	}
+	for i := range h.subnets {
+		if h.subnets[i].Name == name {
+			return &h.subnets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown subnet %s", name)
+}
+
+// nodeAddress is the static address of a node
+type nodeAddress struct {
+	subnet *Subnet
+	ip     string
+	bits   int // Prefix length of the subnet
+}
+
+// allocateNodeIP allocates the address of a node from the subnet, nil if static addressing is not enabled
+func (h *JobHandler) allocateNodeIP(ctx context.Context, subnetName, serviceName, nodeID string) (*nodeAddress, error) {
+	subnet, err := h.nodeSubnet(subnetName)
+	if err != nil || subnet == nil {
+		return nil, err
+	}
+
+	prefix, err := netip.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %s CIDR: %w", subnet.Name, err)
+	}
+
+	r, err := h.ipam.Allocate(ctx, SubnetPool(subnet.Name), vmName(serviceName, nodeID), 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate node address: %w", err)
+	}
+
+	return &nodeAddress{subnet: subnet, ip: r.First, bits: prefix.Bits()}, nil
+}
+
+// lookupNodeAddress returns the address allocated to a node from the subnet, nil if it has none
+func (h *JobHandler) lookupNodeAddress(subnetName, ip string) (*nodeAddress, error) {
+	if ip == "" {
+		return nil, nil
+	}
+	subnet, err := h.nodeSubnet(subnetName)
+	if err != nil {
+		return nil, err
+	}
+	if subnet == nil {
+		return nil, fmt.Errorf("subnets are not enabled on this agent")
+	}
+
+	prefix, err := netip.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %s CIDR: %w", subnet.Name, err)
+	}
+
+	return &nodeAddress{subnet: subnet, ip: ip, bits: prefix.Bits()}, nil
+}
+
+// networkParams returns the parameters of the NoCloud network configuration, DHCP if the address is nil
+func (a *nodeAddress) networkParams() cloudinit.NetworkParams {
+	if a == nil {
+		return cloudinit.NetworkParams{}
+	}
+	return cloudinit.NetworkParams{
+		Address: fmt.Sprintf("%s/%d", a.ip, a.bits),
+		Gateway: a.subnet.Gateway,
+		DNS:     a.subnet.DNS,
+	}
+}
+
+// configureNodeIP configures the static address of a node on its VM.
+// It returns the address, or an empty string if static addressing is not enabled.
+func (h *JobHandler) configureNodeIP(vmID int, addr *nodeAddress) (string, error) {
+	if addr == nil {
+		return "", nil
+	}
+
+	// Proxmox generates the cloud-init network configuration from ipconfig0, the seed ISOs carry their own
+	ipConfig := fmt.Sprintf("ip=%s/%d", addr.ip, addr.bits)
+	if addr.subnet.Gateway != "" {
+		ipConfig += ",gw=" + addr.subnet.Gateway
+	}
+	if netip.MustParseAddr(addr.ip).Is6() {
+		ipConfig = fmt.Sprintf("ip6=%s/%d", addr.ip, addr.bits)
+		if addr.subnet.Gateway != "" {
+			ipConfig += ",gw6=" + addr.subnet.Gateway
+		}
+	}
+
+	t, err := h.proxmoxCli.ConfigureNetwork(vmID, ipConfig, addr.subnet.DNS)
+	if err != nil {
+		return "", fmt.Errorf("failed to configure VM network: %w", err)
+	}
+	_, err = h.proxmoxCli.WaitForTask(t.TaskID, 1*time.Minute)
+	if err != nil {
+		return "", fmt.Errorf("failed to configure VM network: %w", err)
+	}
+
+	return addr.ip, nil
+}
+
+// releaseNodeIP releases the address of a node
+func (h *JobHandler) releaseNodeIP(ctx context.Context, subnetName, serviceName, nodeID string) error {
+	subnet, err := h.nodeSubnet(subnetName)
+	if err != nil || subnet == nil {
+		return err
+	}
+
+	if err := h.ipam.Release(ctx, SubnetPool(subnet.Name), vmName(serviceName, nodeID)); err != nil {
+		return fmt.Errorf("failed to release node address: %w", err)
+	}
+	return nil
+}
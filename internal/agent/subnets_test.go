@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandlerNodeSubnets(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	ipam := NewMockIPAM()
+	subnets := []Subnet{
+		{Name: "default", CIDR: "10.0.0.0/16", Gateway: "10.0.0.254", DNS: []string{"10.0.0.53"}},
+		{Name: "dmz", CIDR: "10.1.0.0/16"},
+	}
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient(), WithIPAM(ipam), WithNodeSubnets(subnets...))
+
+	serviceID := "ip-service"
+	serviceName := "ip-cluster"
+	node1 := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}
+	node2 := Node{ID: "node2", Size: NodeSizeS1, Status: NodeStatusOff}
+
+	// Create the service in the default subnet
+	require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, &Properties{Nodes: []Node{node1}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err := fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"node1": "10.0.0.1"}, service.Resources.NodeIPs)
+	vm, ok := proxmoxCli.GetVM(service.Resources.Nodes["node1"])
+	require.True(t, ok)
+	require.Equal(t, "ip=10.0.0.1/16,gw=10.0.0.254", vm.IPConfig)
+	require.Equal(t, []string{"10.0.0.53"}, vm.DNS)
+
+	// Replace the node, the new node gets a new address and the old one is released
+	require.NoError(t, fulcrumCli.StartService(serviceID))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, &Properties{Nodes: []Node{node2}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+
+	service, err = fulcrumCli.GetService(serviceID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"node2": "10.0.0.2"}, service.Resources.NodeIPs)
+	_, ok = ipam.GetAllocation(SubnetPool("default"), vmName(serviceName, "node1"))
+	require.False(t, ok)
+
+	// The subnet of a service cannot be changed
+	require.NoError(t, fulcrumCli.UpdateService(serviceID, &Properties{Nodes: []Node{node2}, Subnet: "dmz"}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+
+	// Unknown subnets are rejected before creating anything
+	require.NoError(t, fulcrumCli.CreateService("other-service", "other-cluster", nil, &Properties{Nodes: []Node{node1}, Subnet: "unknown"}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullFailedJobs(), 1)
+}
+
+func TestJobHandlerNodeSubnetsServiceDelete(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	ipam := NewMockIPAM()
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, NewMockSSHClient(),
+		WithIPAM(ipam), WithNodeSubnets(Subnet{Name: "default", CIDR: "10.0.0.0/16"}))
+
+	serviceName := "delete-cluster"
+	nodes := []Node{
+		{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff},
+		{ID: "node2", Size: NodeSizeS1, Status: NodeStatusOff},
+	}
+	createService := func(serviceID string) Service {
+		require.NoError(t, fulcrumCli.CreateService(serviceID, serviceName, nil, &Properties{Nodes: nodes}))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		require.NoError(t, fulcrumCli.StartService(serviceID))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		require.NoError(t, fulcrumCli.StopService(serviceID))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+		service, err := fulcrumCli.GetService(serviceID)
+		require.NoError(t, err)
+		return service
+	}
+
+	// Every node is deleted from the tenant cluster by name, and its VM and address are released
+	service := createService("delete-service")
+	tcp, ok := kamajiCli.GetTenantControlPlane(serviceName)
+	require.True(t, ok)
+	require.NoError(t, fulcrumCli.DeleteService("delete-service"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Equal(t, []string{vmName(serviceName, "node1"), vmName(serviceName, "node2")}, tcp.DeletedNodes)
+	for _, node := range nodes {
+		_, ok := proxmoxCli.GetVM(service.Resources.Nodes[node.ID])
+		require.False(t, ok)
+		_, ok = ipam.GetAllocation(SubnetPool("default"), vmName(serviceName, node.ID))
+		require.False(t, ok)
+	}
+
+	// A node failing to be deleted fails the job once the other nodes were cleaned up
+	service = createService("failing-service")
+	_, err := proxmoxCli.DeleteVM(service.Resources.Nodes["node1"])
+	require.NoError(t, err)
+	require.NoError(t, fulcrumCli.DeleteService("failing-service"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	failed := fulcrumCli.PullFailedJobs()
+	require.Len(t, failed, 1)
+	require.Contains(t, failed[0].ErrorMessage, "failed to delete node node1")
+	_, ok = proxmoxCli.GetVM(service.Resources.Nodes["node2"])
+	require.False(t, ok)
+	_, ok = ipam.GetAllocation(SubnetPool("default"), vmName(serviceName, "node2"))
+	require.False(t, ok)
+}
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// templateImportTimeout bounds the import of the cloud image, which copies the whole disk
+const templateImportTimeout = 30 * time.Minute
+
+// templateDescriptionHeader starts the description of the templates built by the agent
+const templateDescriptionHeader = "Built by fulcrum-kube-agent"
+
+var imageDigestRegex = regexp.MustCompile(`^sha(256|512):[0-9a-f]+$`)
+
+// TemplateBuildConfig describes a template to build from a cloud image
+type TemplateBuildConfig struct {
+	VMID        int
+	Name        string // VM name, derived from the OS and version if empty
+	OS          string
+	KubeVersion string
+	Image       string // Volume of the cloud image, such as 'local:import/noble-server-cloudimg-amd64.qcow2'
+	// Digest published with the cloud image, such as 'sha256:<hex>', as given by the operator. It is recorded in the
+	// template description but not verified against the imported image.
+	PublishedDigest string
+	Storage         string // Storage of the template disks
+	Bridge          string // Bridge of the network device
+}
+
+// Validate checks the template build configuration
+func (cfg TemplateBuildConfig) Validate() error {
+	if cfg.VMID <= 0 {
+		return fmt.Errorf("template VM ID is required")
+	}
+	if !slices.Contains(nodeOSes, cfg.OS) {
+		return fmt.Errorf("unknown OS %s", cfg.OS)
+	}
+	if _, err := version.ParseSemantic(cfg.KubeVersion); err != nil {
+		return fmt.Errorf("invalid Kubernetes version %s: %w", cfg.KubeVersion, err)
+	}
+	if storage, path, ok := strings.Cut(cfg.Image, ":"); !ok || storage == "" || path == "" {
+		return fmt.Errorf("invalid image volume %s, expected <storage>:<path>", cfg.Image)
+	}
+	if !imageDigestRegex.MatchString(cfg.PublishedDigest) {
+		return fmt.Errorf("invalid published digest %s, expected sha256:<hex> or sha512:<hex>", cfg.PublishedDigest)
+	}
+	if cfg.Storage == "" {
+		return fmt.Errorf("template storage is required")
+	}
+	if cfg.Bridge == "" {
+		return fmt.Errorf("network bridge is required")
+	}
+	return nil
+}
+
+// templateDescription returns the description of a template, recording what it was built from
+func (cfg TemplateBuildConfig) templateDescription() string {
+	return fmt.Sprintf("%s\nos: %s\nkubeVersion: %s\nimage: %s\npublishedDigest: %s (operator supplied, not verified)\n",
+		templateDescriptionHeader, cfg.OS, cfg.KubeVersion, cfg.Image, cfg.PublishedDigest)
+}
+
+// parseTemplateDescription returns the OS and Kubernetes version recorded in the description of a template,
+// empty if it was not built by the agent
+func parseTemplateDescription(description string) (string, string) {
+	if !strings.HasPrefix(description, templateDescriptionHeader) {
+		return "", ""
+	}
+	var os, kubeVersion string
+	scanner := bufio.NewScanner(strings.NewReader(description))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "os":
+			os = strings.TrimSpace(value)
+		case "kubeVersion":
+			kubeVersion = strings.TrimSpace(value)
+		}
+	}
+	return os, kubeVersion
+}
+
+// BuildTemplate builds a template from a cloud image: it creates a VM, imports the image as its disk,
+// adds a cloud-init drive, a serial console and the QEMU guest agent device, and converts it to a template.
+// The VM is deleted if any step fails.
+func BuildTemplate(cli ProxmoxClient, cfg TemplateBuildConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Name == "" {
+		cfg.Name = fmt.Sprintf("kube-template-%s-%s", cfg.OS, strings.ReplaceAll(cfg.KubeVersion, ".", "-"))
+	}
+
+	// The cloned nodes are resized, the template only needs to boot
+	log.Printf("Creating VM %d %s", cfg.VMID, cfg.Name)
+	t, err := cli.CreateVM(cfg.VMID, cfg.Name, 2, 2048, "virtio,bridge="+cfg.Bridge)
+	if err == nil {
+		err = completeTask(cli, t, 1*time.Minute)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create VM: %w", err)
+	}
+
+	if err := buildTemplate(cli, cfg); err != nil {
+		t, delErr := cli.DeleteVM(cfg.VMID)
+		if delErr == nil {
+			delErr = completeTask(cli, t, 2*time.Minute)
+		}
+		if delErr != nil {
+			log.Printf("Failed to delete VM %d: %v", cfg.VMID, delErr)
+		}
+		return err
+	}
+
+	log.Printf("Template %d built from %s", cfg.VMID, cfg.Image)
+	return nil
+}
+
+func buildTemplate(cli ProxmoxClient, cfg TemplateBuildConfig) error {
+	log.Printf("Importing %s into VM %d", cfg.Image, cfg.VMID)
+	t, err := cli.ImportDisk(cfg.VMID, "scsi0", cfg.Storage, cfg.Image)
+	if err == nil {
+		err = completeTask(cli, t, templateImportTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import disk: %w", err)
+	}
+
+	t, err = cli.AttachCloudInitDrive(cfg.VMID, "ide2", cfg.Storage)
+	if err == nil {
+		err = completeTask(cli, t, 1*time.Minute)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to attach cloud-init drive: %w", err)
+	}
+
+	// Cloud images log to the serial console and expect it as display
+	t, err = cli.SetVMConfig(cfg.VMID, map[string]string{
+		"boot":        "order=scsi0",
+		"serial0":     "socket",
+		"vga":         "serial0",
+		"agent":       "enabled=1",
+		"description": cfg.templateDescription(),
+	})
+	if err == nil {
+		err = completeTask(cli, t, 1*time.Minute)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to configure VM: %w", err)
+	}
+
+	t, err = cli.ConvertToTemplate(cfg.VMID)
+	if err == nil {
+		err = completeTask(cli, t, 2*time.Minute)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to convert VM to template: %w", err)
+	}
+	return nil
+}
+
+// completeTask waits for a task and fails if it did not complete successfully
+func completeTask(cli ProxmoxClient, t *TaskResponse, timeout time.Duration) error {
+	status, err := cli.WaitForTask(t.TaskID, timeout)
+	if err != nil {
+		return err
+	}
+	if status.ExitStatus != "" && status.ExitStatus != "OK" {
+		return fmt.Errorf("task %s failed: %s", t.TaskID, status.ExitStatus)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTemplate(t *testing.T) {
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVolume("local:import/noble-server-cloudimg-amd64.qcow2")
+	cfg := TemplateBuildConfig{
+		VMID:            9000,
+		OS:              OSUbuntu,
+		KubeVersion:     "v1.31.1",
+		Image:           "local:import/noble-server-cloudimg-amd64.qcow2",
+		PublishedDigest: "sha256:0123456789abcdef",
+		Storage:         "local-lvm",
+		Bridge:          "vmbr0",
+	}
+
+	// The image is imported as the boot disk of a template with a cloud-init drive, a serial console and the agent
+	require.NoError(t, BuildTemplate(proxmoxCli, cfg))
+	vm, ok := proxmoxCli.GetVM(9000)
+	require.True(t, ok)
+	require.True(t, vm.Template)
+	require.Equal(t, "kube-template-ubuntu-v1-31-1", vm.Name)
+	require.Equal(t, "virtio,bridge=vmbr0", vm.NIC)
+	require.Equal(t, "local-lvm:vm-9000-disk-0", vm.Config["scsi0"])
+	require.Equal(t, "local-lvm:cloudinit", vm.Config["ide2"])
+	require.Equal(t, "order=scsi0", vm.Config["boot"])
+	require.Equal(t, "socket", vm.Config["serial0"])
+	require.Equal(t, "enabled=1", vm.Config["agent"])
+	require.Contains(t, vm.Config["description"], "publishedDigest: sha256:0123456789abcdef (operator supplied, not verified)")
+
+	// The registry takes the OS and version of the template from its description
+	registry := TemplateRegistry{Templates: []NodeTemplate{{VMID: 9000}}}
+	require.NoError(t, registry.Validate(proxmoxCli))
+	require.Equal(t, OSUbuntu, registry.Templates[0].OS)
+	require.Equal(t, "v1.31.1", registry.Templates[0].KubeVersion)
+	registry = TemplateRegistry{Templates: []NodeTemplate{{OS: OSDebian, KubeVersion: "v1.31.1", VMID: 9000}}}
+	require.ErrorContains(t, registry.Validate(proxmoxCli), "template 9000 was built for ubuntu and Kubernetes v1.31.1")
+
+	// The VM is deleted when a step fails
+	cfg.VMID = 9001
+	cfg.Image = "local:import/missing.qcow2"
+	require.ErrorContains(t, BuildTemplate(proxmoxCli, cfg), "failed to import disk")
+	_, ok = proxmoxCli.GetVM(9001)
+	require.False(t, ok)
+
+	// Invalid configurations are rejected before creating anything
+	cfg.Image = "noble-server-cloudimg-amd64.qcow2"
+	require.ErrorContains(t, BuildTemplate(proxmoxCli, cfg), "invalid image volume")
+	cfg.Image = "local:import/noble-server-cloudimg-amd64.qcow2"
+	cfg.PublishedDigest = "0123456789abcdef"
+	require.ErrorContains(t, BuildTemplate(proxmoxCli, cfg), "invalid published digest")
+	_, ok = proxmoxCli.GetVM(9001)
+	require.False(t, ok)
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// DefaultKubeVersion is the Kubernetes version of the services not selecting one
+const DefaultKubeVersion = "v1.30.2"
+
+// Operating systems of the node templates
+const (
+	OSUbuntu  = "ubuntu"
+	OSDebian  = "debian"
+	OSFlatcar = "flatcar"
+)
+
+var nodeOSes = []string{OSUbuntu, OSDebian, OSFlatcar}
+
+// FlatcarCloudInitTemplate is the name of the embedded cloud-init template of the Flatcar nodes
+const FlatcarCloudInitTemplate = "flatcar"
+
+// NodeTemplate is a Proxmox template the nodes of an OS and Kubernetes version are cloned from
+type NodeTemplate struct {
+	OS          string `json:"os"`          // Read from the description of the templates built by the agent if empty
+	KubeVersion string `json:"kubeVersion"` // Read from the description of the templates built by the agent if empty
+	VMID        int    `json:"vmid"`
+	CloudInit   string `json:"cloudInit,omitempty"` // Cloud-init template of the nodes, the one of the OS if empty
+}
+
+// cloudInitTemplate returns the name of the cloud-init template of the nodes, empty for the agent default
+func (t *NodeTemplate) cloudInitTemplate() string {
+	if t.CloudInit != "" {
+		return t.CloudInit
+	}
+	if t.OS == OSFlatcar {
+		return FlatcarCloudInitTemplate
+	}
+	return ""
+}
+
+// TemplateRegistry maps the OS and Kubernetes version selected by the services to the templates
+type TemplateRegistry struct {
+	Templates []NodeTemplate
+	DefaultOS string // OS of the services not selecting one
+}
+
+// WithTemplateRegistry returns an option that clones the nodes from the template of the OS and Kubernetes version
+// of their service. Without it they are all cloned from the agent template.
+func WithTemplateRegistry(registry TemplateRegistry) JobHandlerOption {
+	return func(h *JobHandler) {
+		if registry.DefaultOS == "" {
+			registry.DefaultOS = OSUbuntu
+		}
+		h.templates = &registry
+	}
+}
+
+// Validate checks that the VMs of the registered templates exist and are templates, and the templates themselves.
+// The OS and Kubernetes version missing from an entry are taken from the description of its template, when the
+// agent built it.
+func (r *TemplateRegistry) Validate(cli ProxmoxClient) error {
+	if r.DefaultOS != "" && !slices.Contains(nodeOSes, r.DefaultOS) {
+		return fmt.Errorf("unknown default OS %s", r.DefaultOS)
+	}
+	seen := make(map[string]bool)
+	for i := range r.Templates {
+		t := &r.Templates[i]
+		info, err := cli.GetVMInfo(t.VMID)
+		if err != nil {
+			return fmt.Errorf("template %d not found: %w", t.VMID, err)
+		}
+		if info.Template != 1 {
+			return fmt.Errorf("VM %d is not a template", t.VMID)
+		}
+		description, err := cli.GetVMDescription(t.VMID)
+		if err != nil {
+			return fmt.Errorf("failed to get template %d description: %w", t.VMID, err)
+		}
+		if os, kubeVersion := parseTemplateDescription(description); os != "" {
+			if t.OS == "" && t.KubeVersion == "" {
+				t.OS, t.KubeVersion = os, kubeVersion
+			} else if t.OS != os || t.KubeVersion != kubeVersion {
+				return fmt.Errorf("template %d was built for %s and Kubernetes %s", t.VMID, os, kubeVersion)
+			}
+		}
+
+		if !slices.Contains(nodeOSes, t.OS) {
+			return fmt.Errorf("unknown OS %s of template %d", t.OS, t.VMID)
+		}
+		if _, err := version.ParseSemantic(t.KubeVersion); err != nil {
+			return fmt.Errorf("invalid Kubernetes version %s of template %d: %w", t.KubeVersion, t.VMID, err)
+		}
+		key := t.OS + "/" + t.KubeVersion
+		if seen[key] {
+			return fmt.Errorf("duplicate template of %s and Kubernetes %s", t.OS, t.KubeVersion)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// kubeVersion returns the Kubernetes version of a service
+func kubeVersion(props *Properties) string {
+	if props != nil && props.KubeVersion != "" {
+		return props.KubeVersion
+	}
+	return DefaultKubeVersion
+}
+
+// nodeTemplate returns the template the nodes of a service are cloned from
+func (h *JobHandler) nodeTemplate(props *Properties) (*NodeTemplate, error) {
+	kubeVersion := kubeVersion(props)
+	if _, err := version.ParseSemantic(kubeVersion); err != nil {
+		return nil, fmt.Errorf("invalid Kubernetes version %s: %w", kubeVersion, err)
+	}
+	os := ""
+	if props != nil {
+		os = props.OS
+	}
+
+	if h.templates == nil {
+		if os != "" {
+			return nil, fmt.Errorf("OS selection is not enabled on this agent")
+		}
+		return &NodeTemplate{KubeVersion: kubeVersion, VMID: h.templateID}, nil
+	}
+
+	if os == "" {
+		os = h.templates.DefaultOS
+	}
+	for _, t := range h.templates.Templates {
+		if t.OS == os && t.KubeVersion == kubeVersion {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("no template of %s and Kubernetes %s", os, kubeVersion)
+}
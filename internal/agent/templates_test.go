@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateRegistryValidate(t *testing.T) {
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(200, "ubuntu-template", VMStatusStopped, 2, 2048).Template = true
+	proxmoxCli.AddVM(201, "debian-vm", VMStatusStopped, 2, 2048)
+
+	ubuntu := NodeTemplate{OS: OSUbuntu, KubeVersion: "v1.30.2", VMID: 200}
+	registry := TemplateRegistry{Templates: []NodeTemplate{ubuntu}}
+	require.NoError(t, registry.Validate(proxmoxCli))
+
+	tests := []struct {
+		name     string
+		registry TemplateRegistry
+		err      string
+	}{
+		{"missing VM", TemplateRegistry{Templates: []NodeTemplate{{OS: OSUbuntu, KubeVersion: "v1.30.2", VMID: 300}}}, "template 300 not found"},
+		{"not a template", TemplateRegistry{Templates: []NodeTemplate{{OS: OSDebian, KubeVersion: "v1.30.2", VMID: 201}}}, "VM 201 is not a template"},
+		{"duplicate", TemplateRegistry{Templates: []NodeTemplate{ubuntu, ubuntu}}, "duplicate template of ubuntu and Kubernetes v1.30.2"},
+		{"unknown OS", TemplateRegistry{Templates: []NodeTemplate{{OS: "windows", KubeVersion: "v1.30.2", VMID: 200}}}, "unknown OS windows"},
+		{"invalid version", TemplateRegistry{Templates: []NodeTemplate{{OS: OSUbuntu, KubeVersion: "latest", VMID: 200}}}, "invalid Kubernetes version latest"},
+		{"unknown default OS", TemplateRegistry{DefaultOS: "windows"}, "unknown default OS windows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorContains(t, tt.registry.Validate(proxmoxCli), tt.err)
+		})
+	}
+}
+
+func TestJobHandlerNodeTemplates(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	kamajiCli := NewMockKamajiClient()
+	sshCli := NewMockSSHClient()
+	registry := TemplateRegistry{Templates: []NodeTemplate{
+		{OS: OSUbuntu, KubeVersion: "v1.30.2", VMID: 200},
+		{OS: OSUbuntu, KubeVersion: "v1.31.1", VMID: 201},
+		{OS: OSFlatcar, KubeVersion: "v1.30.2", VMID: 202},
+	}}
+	for _, tmpl := range registry.Templates {
+		proxmoxCli.AddVM(tmpl.VMID, fmt.Sprintf("%s-%s", tmpl.OS, tmpl.KubeVersion), VMStatusStopped, 2, 2048).Template = true
+	}
+	require.NoError(t, registry.Validate(proxmoxCli))
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", kamajiCli, sshCli, WithTemplateRegistry(registry))
+	node := Node{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}
+	cloudInitFile := func(serviceName string) string {
+		content, ok := sshCli.GetFile(fmt.Sprintf("path/kube-agent-ci-%s.yml", vmName(serviceName, node.ID)))
+		require.True(t, ok)
+		return content
+	}
+	clonedFrom := func(serviceName string) int {
+		service, err := fulcrumCli.GetService(serviceName + "-service")
+		require.NoError(t, err)
+		vm, ok := proxmoxCli.GetVM(service.Resources.Nodes[node.ID])
+		require.True(t, ok)
+		return vm.Source
+	}
+
+	// Services without OS and version get the default ones
+	require.NoError(t, fulcrumCli.CreateService("default-service", "default", nil, &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Equal(t, 200, clonedFrom("default"))
+	require.Contains(t, cloudInitFile("default"), "kind: JoinConfiguration")
+
+	// The control plane runs the version of the service
+	props := &Properties{Nodes: []Node{node}, KubeVersion: "v1.31.1"}
+	require.NoError(t, fulcrumCli.CreateService("version-service", "version", nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Equal(t, 201, clonedFrom("version"))
+	tcp, ok := kamajiCli.GetTenantControlPlane("version")
+	require.True(t, ok)
+	require.Equal(t, "v1.31.1", tcp.Version)
+
+	// Flatcar nodes get the Flatcar cloud-init variant
+	props = &Properties{Nodes: []Node{node}, OS: OSFlatcar}
+	require.NoError(t, fulcrumCli.CreateService("flatcar-service", "flatcar", nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.Equal(t, 202, clonedFrom("flatcar"))
+	require.Contains(t, cloudInitFile("flatcar"), "coreos:")
+
+	// Services selecting an OS and version without template are rejected before creating anything
+	invalidProps := []*Properties{
+		{Nodes: []Node{node}, OS: OSDebian},
+		{Nodes: []Node{node}, KubeVersion: "v1.29.0"},
+	}
+	for i, props := range invalidProps {
+		name := fmt.Sprintf("invalid%d", i)
+		require.NoError(t, fulcrumCli.CreateService(name+"-service", name, nil, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "no template of")
+		_, exists := kamajiCli.GetTenantControlPlane(name)
+		require.False(t, exists)
+	}
+
+	// Services selecting an embedded cloud-init template not fitting their OS are rejected
+	mismatchProps := []*Properties{
+		{Nodes: []Node{node}, OS: OSFlatcar, CloudInitTemplate: DefaultCloudInitTemplate},
+		{Nodes: []Node{node}, CloudInitTemplate: FlatcarCloudInitTemplate},
+	}
+	for i, props := range mismatchProps {
+		name := fmt.Sprintf("mismatch%d", i)
+		require.NoError(t, fulcrumCli.CreateService(name+"-service", name, nil, props))
+		require.NoError(t, jobHandler.PollAndProcessJobs())
+		failed := fulcrumCli.PullFailedJobs()
+		require.Len(t, failed, 1)
+		require.Contains(t, failed[0].ErrorMessage, "does not fit")
+		_, exists := kamajiCli.GetTenantControlPlane(name)
+		require.False(t, exists)
+	}
+
+	// The OS and version of a service can not change
+	require.NoError(t, fulcrumCli.StartService("flatcar-service"))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	require.Len(t, fulcrumCli.PullCompletedJobs(), 1)
+	require.NoError(t, fulcrumCli.UpdateService("flatcar-service", &Properties{Nodes: []Node{node}}))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	failed := fulcrumCli.PullFailedJobs()
+	require.Len(t, failed, 1)
+	require.Contains(t, failed[0].ErrorMessage, "changing OS is not supported")
+}
+
+func TestJobHandlerNodeTemplatesDisabled(t *testing.T) {
+	fulcrumCli := NewMockFulcrumClient()
+	proxmoxCli := NewMockProxmoxClient("test-node")
+	proxmoxCli.AddVM(100, "template-vm", VMStatusStopped, 2, 2048)
+	jobHandler := NewJobHandler(fulcrumCli, proxmoxCli, 100, "path", NewMockKamajiClient(), NewMockSSHClient())
+
+	props := &Properties{Nodes: []Node{{ID: "node1", Size: NodeSizeS1, Status: NodeStatusOff}}, OS: OSFlatcar}
+	require.NoError(t, fulcrumCli.CreateService("os-service", "os-cluster", nil, props))
+	require.NoError(t, jobHandler.PollAndProcessJobs())
+	failed := fulcrumCli.PullFailedJobs()
+	require.Len(t, failed, 1)
+	require.Contains(t, failed[0].ErrorMessage, "OS selection is not enabled")
+}
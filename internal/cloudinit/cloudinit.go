@@ -3,6 +3,10 @@ package cloudinit
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"text/template"
 )
 
@@ -11,6 +15,12 @@ type Template string
 //go:embed cloudinit.gotmpl
 var CloudInitTempl Template
 
+// FlatcarTempl configures Flatcar nodes through coreos-cloudinit, which supports a subset of cloud-config.
+// The Kubernetes binaries come with the template image, such as from the Kubernetes sysext.
+//
+//go:embed flatcar.gotmpl
+var FlatcarTempl Template
+
 //go:embed cloudinit_test.gotmpl
 var CloudInitTestTempl Template
 
@@ -19,7 +29,7 @@ type CloudInitParams struct {
 	Hostname       string
 	FQDN           string
 	Username       string
-	Password       string
+	PasswordHash   string // Crypt hash of the user password, password login is disabled if empty
 	SSHKeys        []string
 	ExpirePassword bool
 	PackageUpgrade bool
@@ -27,12 +37,34 @@ type CloudInitParams struct {
 	JoinToken      string
 	CACertHash     string
 	KubeVersion    string
+
+	PackageRepository string // Kubernetes deb repository of the minor version, ending with a slash
+	PackageVersion    string // Kubernetes package version the installation is pinned to, such as '1.30.2'
+	RegistryMirror    string // Registry the container images are pulled through, directly from the upstream registries if empty
+
+	Vars map[string]string // Extra variables of the custom templates
+}
+
+// funcs are the functions available to the templates
+var funcs = template.FuncMap{
+	"quote": quote,
+}
+
+// quote returns a string as a YAML double quoted scalar, which is a JSON string
+func quote(value string) string {
+	b, _ := json.Marshal(value)
+	return string(b)
 }
 
 // GenerateCloudInit generates a cloud-init configuration from the embedded template
-// using the provided parameters
+// using the provided parameters. The parameters are checked before rendering and the
+// rendered configuration is validated.
 func GenerateCloudInit(templ Template, params CloudInitParams) (string, error) {
-	tmpl, err := template.New("cloudinit").Parse(string(templ))
+	if err := params.validate(); err != nil {
+		return "", err
+	}
+
+	tmpl, err := templ.parse()
 	if err != nil {
 		return "", err
 	}
@@ -43,5 +75,29 @@ func GenerateCloudInit(templ Template, params CloudInitParams) (string, error) {
 		return "", err
 	}
 
+	if err := Validate(buf.String()); err != nil {
+		return "", fmt.Errorf("invalid rendered cloud-init configuration: %w", err)
+	}
+
 	return buf.String(), nil
 }
+
+// validate checks that the string parameters are single lines, as some are rendered into line based files
+func (p CloudInitParams) validate() error {
+	v := reflect.ValueOf(p)
+	for i := 0; i < v.NumField(); i++ {
+		var values []string
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			values = []string{field.String()}
+		case reflect.Slice:
+			values, _ = field.Interface().([]string)
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("cloud-init parameter %s must be a single line", v.Type().Field(i).Name)
+			}
+		}
+	}
+	return nil
+}
@@ -10,7 +10,7 @@ func TestRenderCloudInit(t *testing.T) {
 		Hostname:       "test-worker-node",
 		FQDN:           "test-worker-node",
 		Username:       "ubuntu",
-		Password:       "ubuntu",
+		PasswordHash:   "$2a$10$abcdefghijklmnopqrstuu5kE7YH0Ryzcne5lJqZ4CwQ6dLF4aC6e",
 		SSHKeys:        []string{"ssh-rsa AAAAB3NzaC1yc2EAAAA... test@example.com"},
 		ExpirePassword: false,
 		PackageUpgrade: true,
@@ -29,8 +29,11 @@ func TestRenderCloudInit(t *testing.T) {
 	expectedStrings := []string{
 		"#cloud-config",
 		"hostname: test-worker-node",
-		"user: ubuntu",
-		"password: ubuntu",
+		"- name: ubuntu",
+		"lock_passwd: false",
+		"hashed_passwd: \"$2a$10$abcdefghijklmnopqrstuu5kE7YH0Ryzcne5lJqZ4CwQ6dLF4aC6e\"",
+		"ssh_pwauth: true",
+		"- ssh-rsa AAAAB3NzaC1yc2EAAAA... test@example.com",
 		"package_upgrade: true",
 		"JOIN_URL=172.30.232.66:6443",
 		"JOIN_TOKEN=08f863.6357ad0f550c8e04",
@@ -44,3 +47,171 @@ func TestRenderCloudInit(t *testing.T) {
 		}
 	}
 }
+
+func TestRenderCloudInitWithoutPassword(t *testing.T) {
+	params := CloudInitParams{
+		Hostname: "test-worker-node",
+		FQDN:     "test-worker-node",
+		Username: "ubuntu",
+		SSHKeys:  []string{"ssh-rsa AAAAB3NzaC1yc2EAAAA... test@example.com"},
+	}
+
+	result, err := GenerateCloudInit(CloudInitTempl, params)
+	if err != nil {
+		t.Fatalf("Failed to render cloud-init: %v", err)
+	}
+
+	for _, expected := range []string{"lock_passwd: true", "ssh_pwauth: false"} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected rendered cloud-init to contain '%s', but it doesn't.\nGot: %s", expected, result)
+		}
+	}
+	if strings.Contains(result, "hashed_passwd") {
+		t.Errorf("Expected rendered cloud-init not to set a password.\nGot: %s", result)
+	}
+}
+
+func TestRenderCloudInitJoinConfiguration(t *testing.T) {
+	params := CloudInitParams{
+		Hostname:          "test-worker-node",
+		FQDN:              "test-worker-node",
+		Username:          "ubuntu",
+		JoinURL:           "172.30.232.66:6443",
+		JoinToken:         "08f863.6357ad0f550c8e04",
+		CACertHash:        "sha256:1992ff0cf2bc550fd67ad3238e1355a47ce6b2f32a009f433139b5985066db54",
+		KubeVersion:       "v1.30.5",
+		PackageRepository: "https://mirror.example.com/kubernetes/v1.30/deb/",
+		PackageVersion:    "1.30.5",
+		RegistryMirror:    "https://registry.example.com",
+	}
+
+	result, err := GenerateCloudInit(CloudInitTempl, params)
+	if err != nil {
+		t.Fatalf("Failed to render cloud-init: %v", err)
+	}
+
+	expectedStrings := []string{
+		"kind: JoinConfiguration",
+		`apiServerEndpoint: "172.30.232.66:6443"`,
+		`token: "08f863.6357ad0f550c8e04"`,
+		`- "sha256:1992ff0cf2bc550fd67ad3238e1355a47ce6b2f32a009f433139b5985066db54"`,
+		`name: "test-worker-node"`,
+		"deb [signed-by=/etc/apt/keyrings/kubernetes-apt-keyring.gpg] https://mirror.example.com/kubernetes/v1.30/deb/ /",
+		"curl -fsSL https://mirror.example.com/kubernetes/v1.30/deb/Release.key",
+		"Pin: version 1.30.5-*",
+		"SystemdCgroup = true",
+		`[host."https://registry.example.com"]`,
+		"systemctl enable --now qemu-guest-agent",
+		"kubeadm join --config /etc/kubeadm/join.yaml",
+	}
+	for _, expected := range expectedStrings {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected rendered cloud-init to contain '%s', but it doesn't.\nGot: %s", expected, result)
+		}
+	}
+	if strings.Contains(result, "| bash") {
+		t.Errorf("Expected rendered cloud-init not to pipe a remote script to a shell.\nGot: %s", result)
+	}
+}
+
+func TestRenderFlatcarCloudInit(t *testing.T) {
+	params := CloudInitParams{
+		Hostname:   "test-worker-node",
+		FQDN:       "test-worker-node",
+		Username:   "core",
+		SSHKeys:    []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA test@example.com"},
+		JoinURL:    "172.30.232.66:6443",
+		JoinToken:  "08f863.6357ad0f550c8e04",
+		CACertHash: "sha256:1992ff0cf2bc550fd67ad3238e1355a47ce6b2f32a009f433139b5985066db54",
+	}
+
+	result, err := GenerateCloudInit(FlatcarTempl, params)
+	if err != nil {
+		t.Fatalf("Failed to render cloud-init: %v", err)
+	}
+
+	expectedStrings := []string{
+		`- name: "core"`,
+		`- "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA test@example.com"`,
+		"kind: JoinConfiguration",
+		`token: "08f863.6357ad0f550c8e04"`,
+		"name: kubeadm-join.service",
+		"ExecStart=/usr/bin/kubeadm join --config /etc/kubeadm/join.yaml",
+	}
+	for _, expected := range expectedStrings {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected rendered cloud-init to contain '%s', but it doesn't.\nGot: %s", expected, result)
+		}
+	}
+	// coreos-cloudinit ignores the modules it does not support, such as apt and runcmd
+	for _, unexpected := range []string{"apt", "runcmd:", "passwd:"} {
+		if strings.Contains(result, unexpected) {
+			t.Errorf("Expected rendered cloud-init not to contain '%s'.\nGot: %s", unexpected, result)
+		}
+	}
+}
+
+func TestRenderCloudInitEscaping(t *testing.T) {
+	params := CloudInitParams{
+		Hostname: "test-worker-node",
+		FQDN:     "test-worker-node",
+		Username: "ubuntu",
+		SSHKeys:  []string{`ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBeZfPGgiVw7zMpOhs7RQMCL3+jxfA8U1iiGSiYDSXWy admin: "ops" #1`},
+	}
+
+	// Colons, quotes and comment signs are escaped
+	result, err := GenerateCloudInit(CloudInitTempl, params)
+	if err != nil {
+		t.Fatalf("Failed to render cloud-init: %v", err)
+	}
+	if !strings.Contains(result, `- "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBeZfPGgiVw7zMpOhs7RQMCL3+jxfA8U1iiGSiYDSXWy admin: \"ops\" #1"`) {
+		t.Errorf("Expected the SSH key to be escaped.\nGot: %s", result)
+	}
+
+	// Newlines are rejected
+	params.Hostname = "test-worker-node\nruncmd: [reboot]"
+	if _, err := GenerateCloudInit(CloudInitTempl, params); err == nil {
+		t.Error("Expected multi-line parameter to be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"#cloud-config\nhostname: node\nruncmd:\n  - echo hello\n",
+		"#cloud-config\n",
+	}
+	for _, userData := range valid {
+		if err := Validate(userData); err != nil {
+			t.Errorf("Expected user data to be valid: %v\n%s", err, userData)
+		}
+	}
+
+	invalid := map[string]string{
+		"missing header": "hostname: node\n",
+		"invalid yaml":   "#cloud-config\nhostname: node: other\n",
+		"unknown key":    "#cloud-config\nhostnam: node\n",
+		"invalid type":   "#cloud-config\nruncmd: echo hello\n",
+		"not a mapping":  "#cloud-config\n- hostname\n",
+	}
+	for name, userData := range invalid {
+		if err := Validate(userData); err == nil {
+			t.Errorf("Expected %s user data to be rejected", name)
+		}
+	}
+
+	// Each part of a multipart user data is validated
+	merged, err := MergeUserData("#cloud-config\nhostname: node\n", []string{"#cloud-config\npackages:\n  - htop\n"})
+	if err != nil {
+		t.Fatalf("Failed to merge user data: %v", err)
+	}
+	if err := Validate(merged); err != nil {
+		t.Errorf("Expected merged user data to be valid: %v", err)
+	}
+	merged, err = MergeUserData("#cloud-config\nhostname: node\n", []string{"#cloud-config\nunknown: true\n"})
+	if err != nil {
+		t.Fatalf("Failed to merge user data: %v", err)
+	}
+	if err := Validate(merged); err == nil {
+		t.Error("Expected merged user data with an unknown key to be rejected")
+	}
+}
@@ -0,0 +1,77 @@
+package cloudinit
+
+import (
+	"fmt"
+	"net/netip"
+
+	"sigs.k8s.io/yaml"
+)
+
+// NetworkParams contains the parameters of the network configuration of a node
+type NetworkParams struct {
+	Address string   // Static address with its prefix length, such as '192.168.1.10/24', DHCP if empty
+	Gateway string   // Default gateway of the static address
+	DNS     []string // Name servers of the static address
+}
+
+// networkConfig is the network configuration version 2, in the netplan format
+type networkConfig struct {
+	Version   int                 `json:"version"`
+	Ethernets map[string]ethernet `json:"ethernets"`
+}
+
+type ethernet struct {
+	Match       map[string]string `json:"match"`
+	DHCP4       bool              `json:"dhcp4"`
+	Addresses   []string          `json:"addresses,omitempty"`
+	Routes      []route           `json:"routes,omitempty"`
+	Nameservers *nameservers      `json:"nameservers,omitempty"`
+}
+
+type route struct {
+	To  string `json:"to"`
+	Via string `json:"via"`
+}
+
+type nameservers struct {
+	Addresses []string `json:"addresses"`
+}
+
+// GenerateNetworkConfig generates the NoCloud network configuration of a node.
+// The nodes have a single network device, matched by its name whatever the template machine type.
+func GenerateNetworkConfig(params NetworkParams) (string, error) {
+	eth := ethernet{Match: map[string]string{"name": "e*"}}
+	if params.Address == "" {
+		eth.DHCP4 = true
+	} else {
+		prefix, err := netip.ParsePrefix(params.Address)
+		if err != nil {
+			return "", fmt.Errorf("invalid node address %s: %w", params.Address, err)
+		}
+		eth.Addresses = []string{prefix.String()}
+		if params.Gateway != "" {
+			gateway, err := netip.ParseAddr(params.Gateway)
+			if err != nil {
+				return "", fmt.Errorf("invalid gateway %s: %w", params.Gateway, err)
+			}
+			if gateway.Is6() != prefix.Addr().Is6() {
+				return "", fmt.Errorf("gateway %s is not in the address family of %s", params.Gateway, params.Address)
+			}
+			eth.Routes = []route{{To: "default", Via: gateway.String()}}
+		}
+		if len(params.DNS) > 0 {
+			for _, dns := range params.DNS {
+				if _, err := netip.ParseAddr(dns); err != nil {
+					return "", fmt.Errorf("invalid name server %s: %w", dns, err)
+				}
+			}
+			eth.Nameservers = &nameservers{Addresses: params.DNS}
+		}
+	}
+
+	b, err := yaml.Marshal(networkConfig{Version: 2, Ethernets: map[string]ethernet{"primary": eth}})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode network configuration: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,48 @@
+package cloudinit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateNetworkConfig(t *testing.T) {
+	// Without address the node uses DHCP
+	config, err := GenerateNetworkConfig(NetworkParams{})
+	require.NoError(t, err)
+	require.Equal(t, "ethernets:\n  primary:\n    dhcp4: true\n    match:\n      name: e*\nversion: 2\n", config)
+
+	config, err = GenerateNetworkConfig(NetworkParams{Address: "10.0.0.1/24", Gateway: "10.0.0.254", DNS: []string{"10.0.0.53"}})
+	require.NoError(t, err)
+	require.Equal(t, `ethernets:
+  primary:
+    addresses:
+    - 10.0.0.1/24
+    dhcp4: false
+    match:
+      name: e*
+    nameservers:
+      addresses:
+      - 10.0.0.53
+    routes:
+    - to: default
+      via: 10.0.0.254
+version: 2
+`, config)
+
+	config, err = GenerateNetworkConfig(NetworkParams{Address: "fd00::1/64", Gateway: "fd00::ffff"})
+	require.NoError(t, err)
+	require.Contains(t, config, "- fd00::1/64")
+	require.Contains(t, config, "via: fd00::ffff")
+
+	invalid := []NetworkParams{
+		{Address: "10.0.0.1"},
+		{Address: "10.0.0.1/24", Gateway: "gateway"},
+		{Address: "10.0.0.1/24", Gateway: "fd00::ffff"},
+		{Address: "10.0.0.1/24", DNS: []string{"dns"}},
+	}
+	for _, params := range invalid {
+		_, err := GenerateNetworkConfig(params)
+		require.Error(t, err, params)
+	}
+}
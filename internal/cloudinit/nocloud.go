@@ -0,0 +1,251 @@
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// VolumeID is the label the NoCloud datasource looks for
+const VolumeID = "cidata"
+
+const (
+	sectorSize = 2048
+
+	// Sectors of the fixed part of the image, the file data follows them
+	pvdSector         = 16
+	jolietSector      = 17
+	terminatorSector  = 18
+	pathTableSector   = 19 // L and M path tables of the primary, then of the Joliet descriptor
+	rootSector        = 23
+	jolietRootSector  = 24
+	firstFileSector   = 25
+	maxDirectorySize  = sectorSize
+	dirRecordBaseSize = 33
+)
+
+// File is a file of the seed image
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// NewSeedISO builds an ISO 9660 image labeled 'cidata' holding the files in its root directory.
+// The files get Joliet names, which Linux uses as is, besides the upper-case primary names.
+func NewSeedISO(files []File) ([]byte, error) {
+	files = append([]File(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	// The file data is laid out sector aligned after the directories
+	extents := make([]uint32, len(files))
+	sector := uint32(firstFileSector)
+	for i, f := range files {
+		if f.Name == "" || len(f.Name) > 64 {
+			return nil, fmt.Errorf("invalid file name %q", f.Name)
+		}
+		extents[i] = sector
+		sector += sectors(len(f.Content))
+	}
+	totalSectors := sector
+
+	now := time.Now().UTC()
+	primaryNames := make([][]byte, len(files))
+	jolietNames := make([][]byte, len(files))
+	for i, f := range files {
+		primaryNames[i] = []byte(primaryName(f.Name, i))
+		jolietNames[i] = ucs2(f.Name)
+	}
+
+	root, err := directory(rootSector, files, extents, primaryNames, now)
+	if err != nil {
+		return nil, err
+	}
+	jolietRoot, err := directory(jolietRootSector, files, extents, jolietNames, now)
+	if err != nil {
+		return nil, err
+	}
+
+	image := make([]byte, int(totalSectors)*sectorSize)
+	copy(image[pvdSector*sectorSize:], volumeDescriptor(1, totalSectors, pathTableSector, rootSector, now))
+	copy(image[jolietSector*sectorSize:], volumeDescriptor(2, totalSectors, pathTableSector+2, jolietRootSector, now))
+	copy(image[terminatorSector*sectorSize:], []byte{255, 'C', 'D', '0', '0', '1', 1})
+	copy(image[pathTableSector*sectorSize:], pathTable(rootSector, binary.LittleEndian))
+	copy(image[(pathTableSector+1)*sectorSize:], pathTable(rootSector, binary.BigEndian))
+	copy(image[(pathTableSector+2)*sectorSize:], pathTable(jolietRootSector, binary.LittleEndian))
+	copy(image[(pathTableSector+3)*sectorSize:], pathTable(jolietRootSector, binary.BigEndian))
+	copy(image[rootSector*sectorSize:], root)
+	copy(image[jolietRootSector*sectorSize:], jolietRoot)
+	for i, f := range files {
+		copy(image[int(extents[i])*sectorSize:], f.Content)
+	}
+
+	return image, nil
+}
+
+// GenerateMetaData generates the NoCloud meta data of an instance
+func GenerateMetaData(instanceID, hostname string) string {
+	return fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", quote(instanceID), quote(hostname))
+}
+
+// NewNoCloudSeed builds the NoCloud seed ISO of an instance, without network configuration if empty
+func NewNoCloudSeed(userData, metaData, networkConfig string) ([]byte, error) {
+	files := []File{
+		{Name: "user-data", Content: []byte(userData)},
+		{Name: "meta-data", Content: []byte(metaData)},
+	}
+	if networkConfig != "" {
+		files = append(files, File{Name: "network-config", Content: []byte(networkConfig)})
+	}
+	return NewSeedISO(files)
+}
+
+// sectors returns the number of sectors holding size bytes
+func sectors(size int) uint32 {
+	return uint32((size + sectorSize - 1) / sectorSize)
+}
+
+// primaryName returns an ISO 9660 level 1 file name, made unique with the file index if truncated
+func primaryName(name string, index int) string {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if len(base) > 8 {
+		base = fmt.Sprintf("%s%d", base[:6], index)
+	}
+	return base + ".;1"
+}
+
+// ucs2 encodes a Joliet name as UCS-2 big endian
+func ucs2(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+// directory builds the root directory holding the files
+func directory(sector uint32, files []File, extents []uint32, names [][]byte, t time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(dirRecord([]byte{0}, sector, maxDirectorySize, true, t))
+	buf.Write(dirRecord([]byte{1}, sector, maxDirectorySize, true, t))
+	for i, f := range files {
+		buf.Write(dirRecord(names[i], extents[i], uint32(len(f.Content)), false, t))
+	}
+	if buf.Len() > maxDirectorySize {
+		return nil, fmt.Errorf("too many files for the seed image")
+	}
+	return buf.Bytes(), nil
+}
+
+// dirRecord builds a directory record
+func dirRecord(name []byte, extent, size uint32, dir bool, t time.Time) []byte {
+	length := dirRecordBaseSize + len(name)
+	if length%2 == 1 {
+		length++
+	}
+	r := make([]byte, length)
+	r[0] = byte(length)
+	putBothEndian32(r[2:], extent)
+	putBothEndian32(r[10:], size)
+	copy(r[18:], recordingDate(t))
+	if dir {
+		r[25] = 2
+	}
+	putBothEndian16(r[28:], 1)
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+// pathTable builds the path table of a single root directory
+func pathTable(rootSector uint32, order binary.ByteOrder) []byte {
+	t := make([]byte, 10)
+	t[0] = 1
+	order.PutUint32(t[2:], rootSector)
+	order.PutUint16(t[6:], 1)
+	return t
+}
+
+// volumeDescriptor builds the primary (type 1) or the Joliet supplementary (type 2) volume descriptor
+func volumeDescriptor(kind byte, totalSectors, pathTableSector, rootSector uint32, t time.Time) []byte {
+	d := make([]byte, sectorSize)
+	d[0] = kind
+	copy(d[1:], "CD001")
+	d[6] = 1
+
+	text := func(offset, size int, s string) {
+		field := d[offset : offset+size]
+		if kind == 2 {
+			// Joliet identifiers are UCS-2, padded with spaces
+			for i := 0; i+1 < size; i += 2 {
+				field[i], field[i+1] = 0, ' '
+			}
+			copy(field, ucs2(s))
+			return
+		}
+		copy(field, strings.Repeat(" ", size))
+		copy(field, s)
+	}
+	text(8, 32, "")
+	text(40, 32, VolumeID)
+	putBothEndian32(d[80:], totalSectors)
+	if kind == 2 {
+		copy(d[88:], "%/E") // UCS-2 level 3
+	}
+	putBothEndian16(d[120:], 1)
+	putBothEndian16(d[124:], 1)
+	putBothEndian16(d[128:], sectorSize)
+	putBothEndian32(d[132:], 10)
+	binary.LittleEndian.PutUint32(d[140:], pathTableSector)
+	binary.BigEndian.PutUint32(d[148:], pathTableSector+1)
+	copy(d[156:], dirRecord([]byte{0}, rootSector, maxDirectorySize, true, t))
+	text(190, 128, "")
+	text(318, 128, "")
+	text(446, 128, "")
+	text(574, 128, "FULCRUM KUBE AGENT")
+	text(702, 37, "")
+	text(739, 37, "")
+	text(776, 37, "")
+	copy(d[813:], volumeDate(t))
+	copy(d[830:], volumeDate(t))
+	copy(d[847:], volumeDate(time.Time{}))
+	copy(d[864:], volumeDate(t))
+	d[881] = 1
+	return d
+}
+
+// recordingDate encodes the 7 bytes date of a directory record
+func recordingDate(t time.Time) []byte {
+	return []byte{byte(t.Year() - 1900), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0}
+}
+
+// volumeDate encodes the 17 bytes date of a volume descriptor, unspecified for the zero time
+func volumeDate(t time.Time) []byte {
+	if t.IsZero() {
+		return append([]byte(strings.Repeat("0", 16)), 0)
+	}
+	return append([]byte(fmt.Sprintf("%04d%02d%02d%02d%02d%02d00", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())), 0)
+}
+
+func putBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
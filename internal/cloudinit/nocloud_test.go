@@ -0,0 +1,79 @@
+package cloudinit
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readSeedISO reads the root directory of the descriptor at the sector, returning the files by name
+func readSeedISO(t *testing.T, image []byte, descriptorSector int) map[string]string {
+	d := image[descriptorSector*sectorSize:]
+	require.Equal(t, "CD001", string(d[1:6]))
+	require.Equal(t, uint32(len(image)/sectorSize), binary.LittleEndian.Uint32(d[80:]))
+	require.Equal(t, uint32(len(image)/sectorSize), binary.BigEndian.Uint32(d[84:]))
+	joliet := d[0] == 2
+
+	root := d[156:]
+	dir := image[int(binary.LittleEndian.Uint32(root[2:]))*sectorSize:]
+	files := make(map[string]string)
+	for offset := 0; dir[offset] != 0; offset += int(dir[offset]) {
+		r := dir[offset:]
+		name := r[33 : 33+int(r[32])]
+		if len(name) == 1 && name[0] <= 1 {
+			continue // Self and parent records
+		}
+		extent := int(binary.LittleEndian.Uint32(r[2:]))
+		size := int(binary.LittleEndian.Uint32(r[10:]))
+		require.Equal(t, uint32(size), binary.BigEndian.Uint32(r[14:]))
+
+		fileName := string(name)
+		if joliet {
+			units := make([]uint16, len(name)/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(name[2*i:])
+			}
+			fileName = string(utf16.Decode(units))
+		}
+		files[fileName] = string(image[extent*sectorSize : extent*sectorSize+size])
+	}
+	return files
+}
+
+func TestNewSeedISO(t *testing.T) {
+	userData := "#cloud-config\nhostname: node\n" + strings.Repeat("#\n", 2000) // Spans two sectors
+	metaData := "instance-id: node\n"
+	image, err := NewSeedISO([]File{
+		{Name: "user-data", Content: []byte(userData)},
+		{Name: "meta-data", Content: []byte(metaData)},
+	})
+	require.NoError(t, err)
+	require.Zero(t, len(image)%sectorSize)
+
+	// The primary descriptor holds the volume label and the upper-case names, truncated to 8 characters
+	require.Equal(t, byte(1), image[pvdSector*sectorSize])
+	require.Equal(t, VolumeID, strings.TrimRight(string(image[pvdSector*sectorSize+40:pvdSector*sectorSize+72]), " "))
+	require.Equal(t, map[string]string{"META_D0.;1": metaData, "USER_D1.;1": userData}, readSeedISO(t, image, pvdSector))
+
+	// The Joliet descriptor holds the original names
+	require.Equal(t, byte(2), image[jolietSector*sectorSize])
+	require.Equal(t, "%/E", string(image[jolietSector*sectorSize+88:jolietSector*sectorSize+91]))
+	require.Equal(t, map[string]string{"user-data": userData, "meta-data": metaData}, readSeedISO(t, image, jolietSector))
+	require.Equal(t, byte(255), image[terminatorSector*sectorSize])
+
+	// The network configuration is optional
+	image, err = NewNoCloudSeed("#cloud-config\n", GenerateMetaData("node", "node"), "version: 2\n")
+	require.NoError(t, err)
+	files := readSeedISO(t, image, jolietSector)
+	require.Equal(t, "version: 2\n", files["network-config"])
+	require.Equal(t, "instance-id: \"node\"\nlocal-hostname: \"node\"\n", files["meta-data"])
+	image, err = NewNoCloudSeed("#cloud-config\n", GenerateMetaData("node", "node"), "")
+	require.NoError(t, err)
+	require.NotContains(t, readSeedISO(t, image, jolietSector), "network-config")
+
+	_, err = NewSeedISO([]File{{Name: ""}})
+	require.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package cloudinit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateExt is the file extension of the templates loaded from disk
+const TemplateExt = ".gotmpl"
+
+// Validate checks that the template parses
+func (t Template) Validate() error {
+	_, err := t.parse()
+	return err
+}
+
+// parse parses the template with the functions available to the templates
+func (t Template) parse() (*template.Template, error) {
+	return template.New("cloudinit").Funcs(funcs).Parse(string(t))
+}
+
+// LoadTemplates loads the templates of a directory, named after their file name without extension
+func LoadTemplates(dir string) (map[string]Template, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates directory: %w", err)
+	}
+
+	templates := make(map[string]Template)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != TemplateExt {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", entry.Name(), err)
+		}
+		templ := Template(content)
+		if err := templ.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", entry.Name(), err)
+		}
+		templates[strings.TrimSuffix(entry.Name(), TemplateExt)] = templ
+	}
+
+	return templates, nil
+}
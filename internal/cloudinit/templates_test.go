@@ -0,0 +1,53 @@
+package cloudinit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "large.gotmpl"), []byte("#cloud-config\nhostname: {{.Hostname}}\nswap: {{.Vars.swap}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "quoted.gotmpl"), []byte("#cloud-config\nhostname: {{quote .Hostname}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+	if len(templates) != 2 {
+		t.Fatalf("Expected 2 templates, got %d", len(templates))
+	}
+
+	result, err := GenerateCloudInit(templates["large"], CloudInitParams{Hostname: "node", Vars: map[string]string{"swap": "off"}})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+	if result != "#cloud-config\nhostname: node\nswap: off\n" {
+		t.Errorf("Unexpected rendered template: %s", result)
+	}
+
+	// The templates can use the template functions
+	result, err = GenerateCloudInit(templates["quoted"], CloudInitParams{Hostname: "node"})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+	if result != "#cloud-config\nhostname: \"node\"\n" {
+		t.Errorf("Unexpected rendered template: %s", result)
+	}
+
+	// Templates that do not parse are rejected on load
+	if err := os.WriteFile(filepath.Join(dir, "broken.gotmpl"), []byte("{{.Hostname"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("Expected broken template to be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// cloudConfigHeader is the first line of the cloud-config user data
+	cloudConfigHeader = "#cloud-config"
+
+	// fragmentMergeType appends the lists of the fragments to the generated ones and never replaces
+	// the generated values, so the fragments cannot alter the node bootstrap
+	fragmentMergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+)
+
+// FragmentKeys are the cloud-config keys user data fragments can set
+var FragmentKeys = []string{
+	"bootcmd",
+	"ca_certs",
+	"ntp",
+	"packages",
+	"runcmd",
+	"timezone",
+	"write_files",
+}
+
+// ValidateFragment checks that a user data fragment is a cloud-config only setting the allowed keys
+func ValidateFragment(fragment string) error {
+	if err := validateCloudConfig(fragment); err != nil {
+		return fmt.Errorf("invalid user data fragment: %w", err)
+	}
+
+	var config map[string]any
+	if err := yaml.Unmarshal([]byte(fragment), &config); err != nil {
+		return fmt.Errorf("invalid user data fragment: %w", err)
+	}
+	for key := range config {
+		if !slices.Contains(FragmentKeys, key) {
+			return fmt.Errorf("user data fragment cannot set %s", key)
+		}
+	}
+
+	return nil
+}
+
+// MergeUserData combines the generated cloud-config with user data fragments into a multipart MIME
+// user data, which cloud-init merges in order. The config is returned as is if there is no fragment.
+func MergeUserData(config string, fragments []string) (string, error) {
+	if len(fragments) == 0 {
+		return config, nil
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	parts := append([]string{config}, fragments...)
+	for i, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", `text/cloud-config; charset="utf-8"`)
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="part-%03d.yaml"`, i))
+		if i > 0 {
+			header.Set("Merge-Type", fragmentMergeType)
+		}
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return "", fmt.Errorf("failed to create user data part: %w", err)
+		}
+		if _, err := pw.Write([]byte(part)); err != nil {
+			return "", fmt.Errorf("failed to write user data part: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to close user data: %w", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\n", w.Boundary())
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\n\n")
+	buf.Write(body.Bytes())
+
+	return buf.String(), nil
+}
@@ -0,0 +1,86 @@
+package cloudinit
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestValidateFragment(t *testing.T) {
+	valid := "#cloud-config\npackages:\n  - htop\nruncmd:\n  - echo hello\n"
+	if err := ValidateFragment(valid); err != nil {
+		t.Errorf("Expected fragment to be valid: %v", err)
+	}
+
+	invalid := map[string]string{
+		"missing header": "packages:\n  - htop\n",
+		"shell script":   "#!/bin/sh\necho hello\n",
+		"invalid yaml":   "#cloud-config\npackages: [htop\n",
+		"forbidden key":  "#cloud-config\nusers:\n  - name: intruder\n",
+	}
+	for name, fragment := range invalid {
+		if err := ValidateFragment(fragment); err == nil {
+			t.Errorf("Expected %s fragment to be rejected", name)
+		}
+	}
+}
+
+func TestMergeUserData(t *testing.T) {
+	config := "#cloud-config\nhostname: node\n"
+
+	// The config is kept as is without fragments
+	result, err := MergeUserData(config, nil)
+	if err != nil {
+		t.Fatalf("Failed to merge user data: %v", err)
+	}
+	if result != config {
+		t.Errorf("Expected config to be unchanged, got: %s", result)
+	}
+
+	fragment := "#cloud-config\npackages:\n  - htop\n"
+	result, err = MergeUserData(config, []string{fragment})
+	if err != nil {
+		t.Fatalf("Failed to merge user data: %v", err)
+	}
+
+	// The generated config comes first, the fragments are appended without replacing anything
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(result)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("Failed to read user data header: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Expected multipart user data, got: %s", header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(reader.R, params["boundary"])
+	var parts []string
+	var mergeTypes []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read user data part: %v", err)
+		}
+		content, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("Failed to read user data part: %v", err)
+		}
+		parts = append(parts, string(content))
+		mergeTypes = append(mergeTypes, p.Header.Get("Merge-Type"))
+	}
+
+	if len(parts) != 2 || parts[0] != config || parts[1] != fragment {
+		t.Fatalf("Unexpected user data parts: %q", parts)
+	}
+	if mergeTypes[0] != "" || mergeTypes[1] != fragmentMergeType {
+		t.Errorf("Unexpected merge types: %q", mergeTypes)
+	}
+}
@@ -0,0 +1,104 @@
+package cloudinit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// KnownKeys are the top-level cloud-config keys of the cloud-init modules
+var KnownKeys = []string{
+	"ansible", "apk_repos", "apt", "apt_pipelining", "bootcmd", "byobu_by_default", "ca_certs", "chef",
+	"chpasswd", "create_hostname_file", "device_aliases", "disable_ec2_metadata", "disable_root",
+	"disable_root_opts", "disk_setup", "drivers", "fan", "final_message", "fqdn", "fs_setup", "groups",
+	"growpart", "grub_dpkg", "hostname", "keyboard", "landscape", "locale", "locale_configfile", "lxd",
+	"manage_etc_hosts", "manage_resolv_conf", "mcollective", "merge_how", "merge_type", "mounts",
+	"mount_default_fields", "no_ssh_fingerprints", "ntp", "output", "package_reboot_if_required",
+	"package_update", "package_upgrade", "packages", "password", "phone_home", "power_state",
+	"prefer_fqdn_over_hostname", "preserve_hostname", "puppet", "random_seed", "reporting",
+	"resize_rootfs", "resolv_conf", "rh_subscription", "rsyslog", "runcmd", "salt_minion", "seed_random",
+	"snap", "spacewalk", "ssh", "ssh_authorized_keys", "ssh_deletekeys", "ssh_fp_console_blacklist",
+	"ssh_genkeytypes", "ssh_key_console_blacklist", "ssh_keys", "ssh_publish_hostkeys", "ssh_pwauth",
+	"ssh_quiet_keygen", "swap", "timezone", "ubuntu_advantage", "ubuntu_pro", "updates", "user", "users",
+	"vendor_data", "wireguard", "write_files", "yum_repo_dir", "yum_repos", "zypper",
+}
+
+// coreOSKeys are the top-level keys of the coreos-cloudinit of Flatcar, besides the cloud-init ones
+var coreOSKeys = []string{"coreos"}
+
+// listKeys are the cloud-config keys holding a list
+var listKeys = []string{"bootcmd", "groups", "mounts", "packages", "runcmd", "ssh_authorized_keys", "users", "write_files"}
+
+// Validate checks that a user data is a cloud-config, or a multipart MIME user data of cloud-configs,
+// holding valid YAML with only known keys
+func Validate(userData string) error {
+	if strings.HasPrefix(userData, "Content-Type: multipart/") {
+		return validateMultipart(userData)
+	}
+	return validateCloudConfig(userData)
+}
+
+// validateCloudConfig checks a single cloud-config document
+func validateCloudConfig(config string) error {
+	if !strings.HasPrefix(config, cloudConfigHeader+"\n") {
+		return fmt.Errorf("cloud-config must start with %s", cloudConfigHeader)
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+		return fmt.Errorf("invalid cloud-config YAML: %w", err)
+	}
+	for key, value := range doc {
+		if !slices.Contains(KnownKeys, key) && !slices.Contains(coreOSKeys, key) {
+			return fmt.Errorf("unknown cloud-config key %s", key)
+		}
+		if _, ok := value.([]any); !ok && value != nil && slices.Contains(listKeys, key) {
+			return fmt.Errorf("cloud-config key %s must be a list", key)
+		}
+	}
+
+	return nil
+}
+
+// validateMultipart checks each part of a multipart MIME user data
+func validateMultipart(userData string) error {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(userData)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return fmt.Errorf("invalid user data header: %w", err)
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("invalid user data content type: %w", err)
+	}
+
+	mr := multipart.NewReader(reader.R, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid user data part: %w", err)
+		}
+		mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil || mediaType != "text/cloud-config" {
+			return fmt.Errorf("unsupported user data part type %s", part.Header.Get("Content-Type"))
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return fmt.Errorf("invalid user data part: %w", err)
+		}
+		if err := validateCloudConfig(string(content)); err != nil {
+			return err
+		}
+	}
+}
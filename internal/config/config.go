@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/netip"
 	"os"
 	"time"
 )
@@ -24,20 +25,138 @@ type Config struct {
 	ProxmoxHost     string `json:"proxmoxHost" env:"PROXMOX_HOST"`
 	ProxmoxStorage  string `json:"proxmoxStorage" env:"PROXMOX_STORAGE"`
 
-	// Proxmox Cloud-Init SCP configuration
+	// Placement of the VMs on the hosts of the Proxmox cluster, disabled if the policy is empty
+	ProxmoxPlacement  string              `json:"proxmoxPlacement" env:"PROXMOX_PLACEMENT"` // 'least-loaded', 'spread' or 'label'
+	ProxmoxHostLabels map[string][]string `json:"proxmoxHostLabels"`                        // Labels of the hosts the services can select
+
+	// Proxmox HA registration of the VMs
+	ProxmoxHAGroup string `json:"proxmoxHaGroup" env:"PROXMOX_HA_GROUP"` // HA group the VMs are registered in, HA disabled if empty
+
+	// VM clones and pool
+	ProxmoxLinkedClones    bool           `json:"proxmoxLinkedClones" env:"PROXMOX_LINKED_CLONES"`        // Use linked clones when the storage supports them
+	NodePoolSizes          map[string]int `json:"nodePoolSizes"`                                          // Number of stopped VMs kept ready for each node size
+	NodePoolRefillInterval time.Duration  `json:"nodePoolRefillInterval" env:"NODE_POOL_REFILL_INTERVAL"` // How often the VM pool is refilled
+
+	// Parallel node provisioning
+	NodeConcurrency  int `json:"nodeConcurrency" env:"NODE_CONCURRENCY"`   // Nodes of a job provisioned concurrently
+	CloneConcurrency int `json:"cloneConcurrency" env:"CLONE_CONCURRENCY"` // Clone tasks running concurrently
+
+	// Capacity check of the jobs adding nodes
+	ProxmoxCapacityCheck bool    `json:"proxmoxCapacityCheck" env:"PROXMOX_CAPACITY_CHECK"` // Fail the jobs the hosts lack the capacity for up front
+	ProxmoxOvercommit    float64 `json:"proxmoxOvercommit" env:"PROXMOX_OVERCOMMIT"`        // Ratio of the vCPUs and memory of the VMs to the ones of the hosts
+
+	// Proxmox Cloud-Init SCP configuration, only used by the 'ssh' snippet store
 	ProxmoxCIHost   string `json:"proxmoxCiHost" env:"PROXMOX_CI_HOST"`
 	ProxmoxCIUser   string `json:"proxmoxCiUser" env:"PROXMOX_CI_USER"`
 	ProxmoxCIPKPath string `json:"proxmoxCiPk" env:"PROXMOX_CI_PK_PATH"`
 	ProxmoxCIPath   string `json:"proxmoxCiPath" env:"PROXMOX_CI_PATH"`
 
+	// Cloud-init snippet store
+	SnippetStore      string `json:"snippetStore" env:"SNIPPET_STORE"`            // 'ssh' copies the snippets through SSH, 'iso' uploads seed ISOs through the Proxmox API
+	SnippetISOStorage string `json:"snippetIsoStorage" env:"SNIPPET_ISO_STORAGE"` // Storage the seed ISOs are uploaded to
+	SnippetISODrive   string `json:"snippetIsoDrive" env:"SNIPPET_ISO_DRIVE"`     // Drive the seed ISOs are attached to, replacing the cloud-init drive of the template
+
 	// Kubernetes
 	KubeAPIURL   string `json:"kubeApiUrl" env:"KUBE_API_URL"`
 	KubeAPIToken string `json:"kubeApiToken" env:"KUBE_API_SECRET"`
 
+	// Tenant addons
+	AddonsPath string `json:"addonsPath" env:"ADDONS_PATH"` // Directory of the addon catalog, addons are disabled if empty
+
+	// Tenant Helm charts
+	HelmChartsPath string `json:"helmChartsPath" env:"HELM_CHARTS_PATH"` // Directory of the local charts
+	HelmCachePath  string `json:"helmCachePath" env:"HELM_CACHE_PATH"`   // Directory caching the charts pulled from OCI registries
+
+	// Proxmox CSI driver deployed into the tenant clusters
+	ProxmoxCSIEnabled bool   `json:"proxmoxCsiEnabled" env:"PROXMOX_CSI_ENABLED"`
+	ProxmoxCSIChart   string `json:"proxmoxCsiChart" env:"PROXMOX_CSI_CHART"`     // Chart reference of the CSI plugin
+	ProxmoxCSIVersion string `json:"proxmoxCsiVersion" env:"PROXMOX_CSI_VERSION"` // Chart version of the CSI plugin
+	ProxmoxCSIAPIURL  string `json:"proxmoxCsiApiUrl" env:"PROXMOX_CSI_API_URL"`  // Proxmox API URL reachable from the tenant nodes, defaults to the agent one
+	ProxmoxCSIRegion  string `json:"proxmoxCsiRegion" env:"PROXMOX_CSI_REGION"`   // Proxmox cluster name used as topology region
+
+	// Worker node network, nodes keep the template network if no bridge or VNet is configured
+	NetworkBridge   string `json:"networkBridge" env:"NETWORK_BRIDGE"`     // Default bridge of the nodes
+	NetworkVNet     string `json:"networkVnet" env:"NETWORK_VNET"`         // Default SDN VNet of the nodes, exclusive with the bridge
+	NetworkVLAN     int    `json:"networkVlan" env:"NETWORK_VLAN"`         // Default VLAN tag of the nodes
+	NetworkAllowed  string `json:"networkAllowed" env:"NETWORK_ALLOWED"`   // Comma separated bridges and VNets the services can select, any if empty
+	NetworkFirewall bool   `json:"networkFirewall" env:"NETWORK_FIREWALL"` // Isolate the tenants with Proxmox firewall security groups
+
+	// Tenant load balancers
+	LoadBalancerCIDR      string `json:"loadBalancerCidr" env:"LOAD_BALANCER_CIDR"`            // Pool the tenant ranges are allocated from, load balancers are disabled if empty
+	LoadBalancerRangeSize int    `json:"loadBalancerRangeSize" env:"LOAD_BALANCER_RANGE_SIZE"` // Number of addresses allocated to each tenant
+	MetalLBChart          string `json:"metallbChart" env:"METALLB_CHART"`                     // Chart reference of MetalLB
+	MetalLBVersion        string `json:"metallbVersion" env:"METALLB_VERSION"`                 // Chart version of MetalLB
+
+	// Worker node static addressing, nodes use DHCP if no subnet is configured
+	NodeSubnetCIDR    string         `json:"nodeSubnetCidr" env:"NODE_SUBNET_CIDR"`       // Default subnet of the nodes
+	NodeSubnetGateway string         `json:"nodeSubnetGateway" env:"NODE_SUBNET_GATEWAY"` // Default gateway of the default subnet
+	NodeSubnetDNS     string         `json:"nodeSubnetDns" env:"NODE_SUBNET_DNS"`         // Comma separated name servers of the default subnet
+	NodeSubnets       []SubnetConfig `json:"nodeSubnets"`                                 // Additional subnets the services can select
+
+	// Worker node access
+	NodeUser           string `json:"nodeUser" env:"NODE_USER"`                      // Login user of the nodes
+	NodeAuthorizedKeys string `json:"nodeAuthorizedKeys" env:"NODE_AUTHORIZED_KEYS"` // Comma separated SSH public keys authorized on the nodes of all the tenants
+	NodePasswordLogin  bool   `json:"nodePasswordLogin" env:"NODE_PASSWORD_LOGIN"`   // Enable the password login with a generated password per tenant
+
+	// Worker node bootstrap
+	NodePackageRepository string        `json:"nodePackageRepository" env:"NODE_PACKAGE_REPOSITORY"` // Base URL of the Kubernetes deb repositories, such as a local mirror
+	NodeRegistryMirror    string        `json:"nodeRegistryMirror" env:"NODE_REGISTRY_MIRROR"`       // Registry the container images are pulled through
+	NodeTokenTTL          time.Duration `json:"nodeTokenTtl" env:"NODE_TOKEN_TTL"`                   // Validity of the bootstrap token of each node
+
+	// Worker node power off
+	NodeShutdownTimeout time.Duration `json:"nodeShutdownTimeout" env:"NODE_SHUTDOWN_TIMEOUT"` // How long a node has to shut down before it is stopped
+	NodeHardStop        bool          `json:"nodeHardStop" env:"NODE_HARD_STOP"`               // Stop the nodes right away instead of shutting them down
+
+	// Worker node QEMU guest agent, which the templates must run
+	GuestAgentEnabled     bool          `json:"guestAgentEnabled" env:"GUEST_AGENT_ENABLED"`          // Follow the nodes through their guest agent
+	GuestAgentBootTimeout time.Duration `json:"guestAgentBootTimeout" env:"GUEST_AGENT_BOOT_TIMEOUT"` // How long a started node has to answer
+	GuestAgentLogLines    int           `json:"guestAgentLogLines" env:"GUEST_AGENT_LOG_LINES"`       // Lines of each log collected from a node failing to join
+
+	// Worker node cloud-init templates
+	CloudInitTemplatesPath   string            `json:"cloudInitTemplatesPath" env:"CLOUD_INIT_TEMPLATES_PATH"`     // Directory of the named templates
+	CloudInitDefaultTemplate string            `json:"cloudInitDefaultTemplate" env:"CLOUD_INIT_DEFAULT_TEMPLATE"` // Template of the nodes not selecting one
+	CloudInitSizeTemplates   map[string]string `json:"cloudInitSizeTemplates"`                                     // Template of the nodes of each size
+
+	// Worker node Proxmox templates, the PROXMOX_TEMPLATE one for all the nodes if empty
+	NodeTemplates []TemplateConfig `json:"nodeTemplates"`                       // Template of each OS and Kubernetes version
+	NodeDefaultOS string           `json:"nodeDefaultOs" env:"NODE_DEFAULT_OS"` // OS of the services not selecting one
+
+	// Resource quotas, unlimited if zero
+	QuotaMaxNodesPerService int `json:"quotaMaxNodesPerService" env:"QUOTA_MAX_NODES_PER_SERVICE"` // Nodes of each service
+	QuotaMaxTenants         int `json:"quotaMaxTenants" env:"QUOTA_MAX_TENANTS"`                   // Services of the agent
+	QuotaTenantCPU          int `json:"quotaTenantCpu" env:"QUOTA_TENANT_CPU"`                     // vCPUs of the nodes of each service
+	QuotaTenantMemory       int `json:"quotaTenantMemory" env:"QUOTA_TENANT_MEMORY"`               // Memory in MB of the nodes of each service
+	QuotaTenantDisk         int `json:"quotaTenantDisk" env:"QUOTA_TENANT_DISK"`                   // Disk in GB of the nodes of each service
+	QuotaAgentCPU           int `json:"quotaAgentCpu" env:"QUOTA_AGENT_CPU"`                       // vCPUs of the nodes of all the services
+	QuotaAgentMemory        int `json:"quotaAgentMemory" env:"QUOTA_AGENT_MEMORY"`                 // Memory in MB of the nodes of all the services
+	QuotaAgentDisk          int `json:"quotaAgentDisk" env:"QUOTA_AGENT_DISK"`                     // Disk in GB of the nodes of all the services
+
 	// Client HTTP
 	SkipTLSVerify bool `json:"skipTlsVerify" env:"SKIP_TLS_VERIFY"` // Skip TLS certificate validation
 }
 
+// Snippet stores
+const (
+	SnippetStoreSSH = "ssh"
+	SnippetStoreISO = "iso"
+)
+
+// SubnetConfig holds the configuration of a subnet the worker node addresses are allocated from
+type SubnetConfig struct {
+	Name    string   `json:"name"`
+	CIDR    string   `json:"cidr"`
+	Gateway string   `json:"gateway"`
+	DNS     []string `json:"dns"`
+}
+
+// TemplateConfig holds the Proxmox template the nodes of an OS and Kubernetes version are cloned from
+type TemplateConfig struct {
+	OS          string `json:"os"`
+	KubeVersion string `json:"kubeVersion"`
+	VMID        int    `json:"vmid"`
+	CloudInit   string `json:"cloudInit"` // Cloud-init template of the nodes, the one of the OS if empty
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.FulcrumAPIToken == "" {
@@ -48,18 +167,12 @@ func (c *Config) Validate() error {
 	}
 
 	// Validate Proxmox configuration - all properties are mandatory
-	if c.ProxmoxAPIURL == "" {
-		return fmt.Errorf("Proxmox API URL is required")
-	}
-	if c.ProxmoxAPIToken == "" {
-		return fmt.Errorf("Proxmox API token is required")
+	if err := c.ValidateProxmox(); err != nil {
+		return err
 	}
 	if c.ProxmoxTemplate <= 0 {
 		return fmt.Errorf("Proxmox template ID must be greater than 0")
 	}
-	if c.ProxmoxHost == "" {
-		return fmt.Errorf("Proxmox host is required")
-	}
 	if c.ProxmoxStorage == "" {
 		return fmt.Errorf("Proxmox storage is required")
 	}
@@ -72,6 +185,100 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("Kubernetes API token is required")
 	}
 
+	if c.SnippetStore != SnippetStoreSSH && c.SnippetStore != SnippetStoreISO {
+		return fmt.Errorf("unknown snippet store %s", c.SnippetStore)
+	}
+	if c.SnippetStore == SnippetStoreISO && c.SnippetISOStorage == "" {
+		return fmt.Errorf("the iso snippet store requires an ISO storage")
+	}
+	// The snippets copied through SSH are only found by the VMs of the SSH host
+	if c.ProxmoxPlacement != "" && c.SnippetStore == SnippetStoreSSH {
+		return fmt.Errorf("the VM placement requires the iso snippet store")
+	}
+
+	// The CSI driver is installed as a Helm release
+	if c.ProxmoxCSIEnabled && c.HelmChartsPath == "" && c.HelmCachePath == "" {
+		return fmt.Errorf("Proxmox CSI requires Helm charts to be enabled")
+	}
+
+	if err := c.validateSubnets(); err != nil {
+		return err
+	}
+
+	// The firewall matches the static node addresses
+	if c.NetworkFirewall && c.NodeSubnetCIDR == "" && len(c.NodeSubnets) == 0 {
+		return fmt.Errorf("network firewall requires node subnets")
+	}
+	// The firewall is enabled on the network device the agent configures
+	if c.NetworkFirewall && c.NetworkBridge == "" && c.NetworkVNet == "" {
+		return fmt.Errorf("network firewall requires a network bridge or VNet")
+	}
+
+	// MetalLB is installed as a Helm release
+	if c.LoadBalancerCIDR != "" && c.HelmChartsPath == "" && c.HelmCachePath == "" {
+		return fmt.Errorf("load balancers require Helm charts to be enabled")
+	}
+
+	return nil
+}
+
+// validateSubnets checks that the node subnets have unique names, and that their CIDRs overlap neither
+// each other nor the load balancer pool, as the IPAM would hand out the same address twice
+func (c *Config) validateSubnets() error {
+	subnets := c.NodeSubnets
+	if c.NodeSubnetCIDR != "" {
+		subnets = append([]SubnetConfig{{Name: "default", CIDR: c.NodeSubnetCIDR}}, subnets...)
+	}
+
+	type namedPrefix struct {
+		name   string
+		prefix netip.Prefix
+	}
+	var prefixes []namedPrefix
+	names := make(map[string]bool)
+	for _, subnet := range subnets {
+		if subnet.Name == "" || subnet.CIDR == "" {
+			return fmt.Errorf("node subnets require a name and a CIDR")
+		}
+		if names[subnet.Name] {
+			return fmt.Errorf("duplicate node subnet %s", subnet.Name)
+		}
+		names[subnet.Name] = true
+		prefix, err := netip.ParsePrefix(subnet.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR of node subnet %s: %w", subnet.Name, err)
+		}
+		prefixes = append(prefixes, namedPrefix{"node subnet " + subnet.Name, prefix})
+	}
+	if c.LoadBalancerCIDR != "" {
+		prefix, err := netip.ParsePrefix(c.LoadBalancerCIDR)
+		if err != nil {
+			return fmt.Errorf("invalid load balancer CIDR: %w", err)
+		}
+		prefixes = append(prefixes, namedPrefix{"the load balancer pool", prefix})
+	}
+
+	for i, p := range prefixes {
+		for _, other := range prefixes[i+1:] {
+			if p.prefix.Overlaps(other.prefix) {
+				return fmt.Errorf("%s overlaps %s", p.name, other.name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateProxmox checks the Proxmox connection configuration
+func (c *Config) ValidateProxmox() error {
+	if c.ProxmoxAPIURL == "" {
+		return fmt.Errorf("Proxmox API URL is required")
+	}
+	if c.ProxmoxAPIToken == "" {
+		return fmt.Errorf("Proxmox API token is required")
+	}
+	if c.ProxmoxHost == "" {
+		return fmt.Errorf("Proxmox host is required")
+	}
 	return nil
 }
 
@@ -85,11 +292,29 @@ type ConfigBuilder struct {
 func Builder() *ConfigBuilder {
 	return &ConfigBuilder{
 		config: &Config{
-			FulcrumAPIToken:      "", // Must be provided
-			FulcrumAPIURL:        "http://localhost:3000",
-			SkipTLSVerify:        false, // By default, verify TLS certificates
-			JobPollInterval:      5 * time.Second,
-			MetricReportInterval: 30 * time.Second,
+			FulcrumAPIToken:        "", // Must be provided
+			FulcrumAPIURL:          "http://localhost:3000",
+			SkipTLSVerify:          false, // By default, verify TLS certificates
+			JobPollInterval:        5 * time.Second,
+			MetricReportInterval:   30 * time.Second,
+			ProxmoxCSIChart:        "oci://ghcr.io/sergelogvinov/charts/proxmox-csi-plugin",
+			ProxmoxCSIVersion:      "0.3.5",
+			ProxmoxCSIRegion:       "pve",
+			LoadBalancerRangeSize:  8,
+			MetalLBChart:           "metallb",
+			NodeUser:               "ubuntu",
+			NodePackageRepository:  "https://pkgs.k8s.io/core:/stable:",
+			NodeTokenTTL:           2 * time.Hour,
+			NodeShutdownTimeout:    2 * time.Minute,
+			GuestAgentBootTimeout:  5 * time.Minute,
+			GuestAgentLogLines:     50,
+			ProxmoxOvercommit:      1,
+			NodePoolRefillInterval: time.Minute,
+			NodeConcurrency:        4,
+			CloneConcurrency:       2,
+			SnippetStore:           SnippetStoreSSH,
+			SnippetISOStorage:      "local",
+			SnippetISODrive:        "ide2",
 		},
 	}
 }
@@ -150,3 +375,17 @@ func (b *ConfigBuilder) Build() (*Config, error) {
 
 	return b.config, nil
 }
+
+// BuildProxmox validates the Proxmox connection only and returns the final Config,
+// for the commands running against Proxmox instead of the agent
+func (b *ConfigBuilder) BuildProxmox() (*Config, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if err := b.config.ValidateProxmox(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return b.config, nil
+}
@@ -0,0 +1,338 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// ManagedByLabel is the release label identifying the releases installed by the agent
+	ManagedByLabel = "managed-by"
+
+	// ManagedByValue is the value of the ManagedByLabel release label
+	ManagedByValue = "fulcrum-kube-agent"
+
+	// storageDriver is the Helm storage driver, releases are tracked as secrets in the cluster
+	storageDriver = "secret"
+
+	// DefaultTimeout is the default timeout for installing and uninstalling a release
+	DefaultTimeout = 5 * time.Minute
+)
+
+// Options holds the configuration options for creating a Helm client
+type Options struct {
+	ChartsPath string        // Directory the local chart references are resolved from
+	CachePath  string        // Directory where the charts pulled from OCI registries are cached
+	Timeout    time.Duration // Timeout of the install, upgrade and uninstall operations
+}
+
+// Client implements the agent.HelmClient interface using the Helm SDK
+type Client struct {
+	opts Options
+}
+
+// NewClient creates a new Helm client with the given options
+func NewClient(opts Options) *Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Client{opts: opts}
+}
+
+// InstallRelease installs the release, or upgrades it if it already exists.
+// Releases already deployed with the same chart version and values are left untouched.
+// The workloads are not waited for, as the worker nodes of a new service are not running yet.
+func (c *Client) InstallRelease(ctx context.Context, kubeConfig string, rel agent.HelmRelease) error {
+	chrt, err := c.loadChart(rel.Chart, rel.Version)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := newActionConfig(kubeConfig, rel.Namespace)
+	if err != nil {
+		return err
+	}
+
+	values := rel.Values
+	if values == nil {
+		values = make(map[string]any)
+	}
+	labels := map[string]string{ManagedByLabel: ManagedByValue}
+
+	last, err := cfg.Releases.Last(rel.Name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		install := action.NewInstall(cfg)
+		install.ReleaseName = rel.Name
+		install.Namespace = rel.Namespace
+		install.CreateNamespace = true
+		install.Timeout = c.opts.Timeout
+		install.Labels = labels
+		if _, err := install.RunWithContext(ctx, chrt, values); err != nil {
+			return fmt.Errorf("failed to install release %s: %w", rel.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get release %s: %w", rel.Name, err)
+	}
+
+	if last.Info.Status == release.StatusDeployed &&
+		last.Chart.Metadata.Version == chrt.Metadata.Version &&
+		sameValues(last.Config, values) {
+		return nil
+	}
+
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = rel.Namespace
+	upgrade.Timeout = c.opts.Timeout
+	upgrade.Labels = labels
+	if _, err := upgrade.RunWithContext(ctx, rel.Name, chrt, values); err != nil {
+		return fmt.Errorf("failed to upgrade release %s: %w", rel.Name, err)
+	}
+
+	return nil
+}
+
+// UninstallRelease uninstalls a release, waiting for its resources to be deleted until the timeout or the
+// deadline of the context
+func (c *Client) UninstallRelease(ctx context.Context, kubeConfig string, name, namespace string) error {
+	cfg, err := newActionConfig(kubeConfig, namespace)
+	if err != nil {
+		return err
+	}
+
+	uninstall := action.NewUninstall(cfg)
+	uninstall.Wait = true
+	uninstall.Timeout = c.timeout(ctx)
+	uninstall.IgnoreNotFound = true
+	if _, err := runWithContext(ctx, func() (*release.UninstallReleaseResponse, error) { return uninstall.Run(name) }); err != nil {
+		return fmt.Errorf("failed to uninstall release %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// ListReleases lists the releases installed by the agent in all the namespaces
+func (c *Client) ListReleases(ctx context.Context, kubeConfig string) ([]agent.HelmRelease, error) {
+	cfg, err := newActionConfig(kubeConfig, "")
+	if err != nil {
+		return nil, err
+	}
+
+	list := action.NewList(cfg)
+	list.AllNamespaces = true
+	list.All = true
+	list.SetStateMask()
+	list.Selector = fmt.Sprintf("%s=%s", ManagedByLabel, ManagedByValue)
+	releases, err := runWithContext(ctx, list.Run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+
+	result := make([]agent.HelmRelease, 0, len(releases))
+	for _, rel := range releases {
+		result = append(result, agent.HelmRelease{
+			Name:      rel.Name,
+			Namespace: rel.Namespace,
+			Chart:     rel.Chart.Metadata.Name,
+			Version:   rel.Chart.Metadata.Version,
+			Values:    rel.Config,
+		})
+	}
+
+	return result, nil
+}
+
+// timeout returns the timeout of the operations, shortened to the deadline of the context
+func (c *Client) timeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return max(min(c.opts.Timeout, time.Until(deadline)), 0)
+	}
+	return c.opts.Timeout
+}
+
+// runWithContext runs a Helm action that takes no context, returning as soon as the context is done.
+// The action is left to complete in the background, bounded by its own timeout.
+func runWithContext[T any](ctx context.Context, run func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := run()
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// loadChart loads a chart from the local charts directory or from the OCI cache,
+// pulling it into the cache if it is not there yet
+func (c *Client) loadChart(ref, version string) (*chart.Chart, error) {
+	var path string
+	if registry.IsOCI(ref) {
+		if version == "" {
+			return nil, fmt.Errorf("chart %s requires a version", ref)
+		}
+		cached, err := c.pullChart(ref, version)
+		if err != nil {
+			return nil, err
+		}
+		path = cached
+	} else {
+		if c.opts.ChartsPath == "" {
+			return nil, fmt.Errorf("local charts are not enabled, cannot load chart %s", ref)
+		}
+		// Resolve the reference inside the charts directory only
+		path = filepath.Join(c.opts.ChartsPath, filepath.Clean("/"+ref))
+	}
+
+	chrt, err := loader.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart %s: %w", ref, err)
+	}
+	if version != "" && chrt.Metadata.Version != version {
+		return nil, fmt.Errorf("chart %s has version %s, expected %s", ref, chrt.Metadata.Version, version)
+	}
+
+	return chrt, nil
+}
+
+// pullChart returns the cached archive of an OCI chart, pulling it on a cache miss
+func (c *Client) pullChart(ref, version string) (string, error) {
+	if c.opts.CachePath == "" {
+		return "", fmt.Errorf("OCI charts are not enabled, cannot load chart %s", ref)
+	}
+
+	repo := strings.TrimPrefix(ref, fmt.Sprintf("%s://", registry.OCIScheme))
+	path := filepath.Join(c.opts.CachePath, filepath.Clean("/"+repo), version+".tgz")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	log.Printf("Pulling chart %s:%s", ref, version)
+	regCli, err := registry.NewClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create registry client: %w", err)
+	}
+	res, err := regCli.Pull(fmt.Sprintf("%s:%s", repo, version))
+	if err != nil {
+		return "", fmt.Errorf("failed to pull chart %s:%s: %w", ref, version, err)
+	}
+
+	// Write to a temporary file first so that an interrupted pull never leaves a broken archive in the cache
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create chart cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pull-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to cache chart %s: %w", ref, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(res.Chart.Data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to cache chart %s: %w", ref, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to cache chart %s: %w", ref, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to cache chart %s: %w", ref, err)
+	}
+
+	return path, nil
+}
+
+// newActionConfig creates a Helm action configuration for the cluster of the given kubeconfig
+func newActionConfig(kubeConfig, namespace string) (*action.Configuration, error) {
+	rawConfig, err := clientcmd.Load([]byte(kubeConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.Context.Namespace = namespace
+
+	getter := &restClientGetter{
+		clientConfig: clientcmd.NewDefaultClientConfig(*rawConfig, overrides),
+	}
+
+	cfg := &action.Configuration{}
+	if err := cfg.Init(getter, namespace, storageDriver, log.Printf); err != nil {
+		return nil, fmt.Errorf("failed to initialize helm: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// sameValues reports whether two sets of values are equal, treating nil and empty as the same
+func sameValues(a, b map[string]any) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// restClientGetter implements the RESTClientGetter interface required by Helm on top of an in-memory kubeconfig
+type restClientGetter struct {
+	clientConfig clientcmd.ClientConfig
+}
+
+func (g *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return g.clientConfig.ClientConfig()
+}
+
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := g.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(discoveryClient), nil
+}
+
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	discoveryClient, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	return restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient), nil
+}
+
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return g.clientConfig
+}
@@ -0,0 +1,97 @@
+package helm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestLoadChart(t *testing.T) {
+	chartsPath := t.TempDir()
+	_, err := chartutil.Create("demo", chartsPath)
+	require.NoError(t, err)
+
+	cli := NewClient(Options{ChartsPath: chartsPath})
+
+	chrt, err := cli.loadChart("demo", "")
+	require.NoError(t, err)
+	require.Equal(t, "demo", chrt.Metadata.Name)
+
+	_, err = cli.loadChart("demo", chrt.Metadata.Version)
+	require.NoError(t, err)
+
+	_, err = cli.loadChart("demo", "99.0.0")
+	require.Error(t, err)
+
+	// References cannot escape the charts directory
+	outside := t.TempDir()
+	_, err = chartutil.Create("outside", outside)
+	require.NoError(t, err)
+	rel, err := filepath.Rel(chartsPath, filepath.Join(outside, "outside"))
+	require.NoError(t, err)
+	_, err = cli.loadChart(rel, "")
+	require.Error(t, err)
+
+	// Local charts are disabled without a charts directory
+	_, err = NewClient(Options{}).loadChart("demo", "")
+	require.Error(t, err)
+}
+
+func TestLoadChartFromCache(t *testing.T) {
+	cachePath := t.TempDir()
+	src := t.TempDir()
+	chartPath, err := chartutil.Create("cached", src)
+	require.NoError(t, err)
+
+	// Seed the cache as a previous pull would have
+	chrt, err := NewClient(Options{ChartsPath: src}).loadChart("cached", "")
+	require.NoError(t, err)
+	dest := filepath.Join(cachePath, "registry.example.com", "charts", "cached")
+	require.NoError(t, os.MkdirAll(dest, 0o755))
+	archive, err := chartutil.Save(chrt, dest)
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(archive, filepath.Join(dest, chrt.Metadata.Version+".tgz")))
+	require.NoError(t, os.RemoveAll(chartPath))
+
+	cli := NewClient(Options{CachePath: cachePath})
+	cached, err := cli.loadChart("oci://registry.example.com/charts/cached", chrt.Metadata.Version)
+	require.NoError(t, err)
+	require.Equal(t, "cached", cached.Metadata.Name)
+
+	_, err = cli.loadChart("oci://registry.example.com/charts/cached", "")
+	require.Error(t, err)
+}
+
+func TestSameValues(t *testing.T) {
+	require.True(t, sameValues(nil, map[string]any{}))
+	require.True(t, sameValues(map[string]any{"a": 1}, map[string]any{"a": 1}))
+	require.False(t, sameValues(map[string]any{"a": 1}, map[string]any{"a": 2}))
+}
+
+func TestContext(t *testing.T) {
+	client := NewClient(Options{})
+
+	// The actions taking no context are not started once it is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.ListReleases(ctx, "")
+	require.ErrorIs(t, err, context.Canceled)
+
+	// and they return when it is done, without waiting for the action
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = runWithContext(ctx, func() (any, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The timeout of the operations is shortened to the deadline
+	require.Equal(t, DefaultTimeout, client.timeout(context.Background()))
+	require.LessOrEqual(t, client.timeout(ctx), 10*time.Millisecond)
+}
@@ -101,15 +101,26 @@ func (c *Client) formatAuthHeader() string {
 	}
 }
 
+// endpointURL joins the endpoint to the base URL, keeping the query the endpoint may carry
+func (c *Client) endpointURL(endpoint string) (string, error) {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	endpoint, query, _ := strings.Cut(endpoint, "?")
+	u.Path = path.Join(u.Path, endpoint)
+	u.RawQuery = query
+	return u.String(), nil
+}
+
 // Get performs an HTTP GET request to the specified endpoint
 func (c *Client) Get(endpoint string) (*http.Response, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -122,13 +133,12 @@ func (c *Client) Get(endpoint string) (*http.Response, error) {
 
 // Post performs an HTTP POST request to the specified endpoint with the given body
 func (c *Client) Post(endpoint string, body []byte) (*http.Response, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
-	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -141,14 +151,32 @@ func (c *Client) Post(endpoint string, body []byte) (*http.Response, error) {
 
 // PostForm performs an HTTP POST request with form data to the specified endpoint
 func (c *Client) PostForm(endpoint string, formData url.Values) (*http.Response, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
 	body := strings.NewReader(formData.Encode())
-	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	req, err := http.NewRequest(http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", c.formatAuthHeader())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.HTTPClient.Do(req)
+}
+
+// PutForm performs an HTTP PUT request with form data to the specified endpoint
+func (c *Client) PutForm(endpoint string, formData url.Values) (*http.Response, error) {
+	u, err := c.endpointURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	body := strings.NewReader(formData.Encode())
+	req, err := http.NewRequest(http.MethodPut, u, body)
 	if err != nil {
 		return nil, err
 	}
@@ -161,13 +189,12 @@ func (c *Client) PostForm(endpoint string, formData url.Values) (*http.Response,
 
 // Put performs an HTTP PUT request to the specified endpoint with the given body
 func (c *Client) Put(endpoint string, body []byte) (*http.Response, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
-	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -180,13 +207,12 @@ func (c *Client) Put(endpoint string, body []byte) (*http.Response, error) {
 
 // Delete performs an HTTP DELETE request to the specified endpoint
 func (c *Client) Delete(endpoint string) (*http.Response, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
-	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -199,13 +225,12 @@ func (c *Client) Delete(endpoint string) (*http.Response, error) {
 
 // Patch performs an HTTP PATCH request to the specified endpoint with the given body
 func (c *Client) Patch(endpoint string, body []byte) (*http.Response, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
-	req, err := http.NewRequest(http.MethodPatch, u.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequest(http.MethodPatch, u, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -218,13 +243,12 @@ func (c *Client) Patch(endpoint string, body []byte) (*http.Response, error) {
 
 // NewRequest creates a new HTTP request with the given method, endpoint, and body
 func (c *Client) NewRequest(method string, endpoint string, body io.Reader) (*http.Request, error) {
-	u, err := url.Parse(c.BaseURL)
+	u, err := c.endpointURL(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path.Join(u.Path, endpoint)
 
-	req, err := http.NewRequest(method, u.String(), body)
+	req, err := http.NewRequest(method, u, body)
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +122,7 @@ func TestKamajiProxmoxIntegration(t *testing.T) {
 
 		// Create a join token for nodes
 		t.Logf("Creating join token for tenant: %s", testTenantName)
-		tokenResponse, err := tenantClient.CreateJoinToken(ctx, testTenantName, 24)
+		tokenResponse, err := tenantClient.CreateJoinToken(ctx, "kube-agent integration test token", time.Hour)
 		require.NoError(t, err, "CreateJoinToken should not return an error")
 		require.NotNil(t, tokenResponse, "Token response should not be nil")
 		require.NotEmpty(t, tokenResponse.FullToken, "Full token should not be empty")
@@ -196,7 +196,7 @@ func TestKamajiProxmoxIntegration(t *testing.T) {
 		}()
 
 		// Clone the VM from template
-		cloneResp, err := proxmoxClient.CloneVM(cfg.ProxmoxTemplate, testVMID, vmName)
+		cloneResp, err := proxmoxClient.CloneVM(cfg.ProxmoxTemplate, testVMID, vmName, "", false)
 		require.NoError(t, err, "CloneVM should not return an error")
 		require.NotNil(t, cloneResp, "CloneVM should return a response")
 		require.NotEmpty(t, cloneResp.TaskID, "CloneVM should return a task ID")
@@ -219,7 +219,6 @@ func TestKamajiProxmoxIntegration(t *testing.T) {
 			Hostname:       vmName,
 			FQDN:           vmName,
 			Username:       "ubuntu",
-			Password:       "ubuntu",
 			SSHKeys:        []string{"ssh-rsa AAAAB3NzaC1yc2EAAAA... test@example.com"}, // Use a real key in prod
 			ExpirePassword: false,
 			PackageUpgrade: true,
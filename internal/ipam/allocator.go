@@ -0,0 +1,235 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// poolNamePattern restricts the pool names to the ones usable in Kubernetes object names
+var poolNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Store defines the interface for persisting the allocations of the pools
+type Store interface {
+	// LoadIPAllocations loads the allocations of a pool as ranges keyed by owner
+	LoadIPAllocations(ctx context.Context, pool string) (map[string]string, error)
+
+	// SaveIPAllocations saves the allocations of a pool as ranges keyed by owner
+	SaveIPAllocations(ctx context.Context, pool string, allocations map[string]string) error
+}
+
+// Allocator implements the agent.IPAM interface, allocating consecutive addresses of the configured pools
+type Allocator struct {
+	store Store
+	pools map[string]pool
+	mu    sync.Mutex
+}
+
+// pool is a CIDR the addresses are allocated from, except the reserved ones
+type pool struct {
+	prefix   netip.Prefix
+	reserved []addrRange
+}
+
+// NewAllocator creates a new allocator persisting its allocations in the given store
+func NewAllocator(store Store) *Allocator {
+	return &Allocator{
+		store: store,
+		pools: make(map[string]pool),
+	}
+}
+
+// AddPool adds a pool of the addresses of the given CIDR, never allocating the reserved ones (such as a gateway)
+func (a *Allocator) AddPool(name string, cidr string, reserved ...string) error {
+	if !poolNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid pool name %s", name)
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid pool %s CIDR: %w", name, err)
+	}
+
+	p := pool{prefix: prefix.Masked()}
+	for _, s := range reserved {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return fmt.Errorf("invalid pool %s reserved address: %w", name, err)
+		}
+		p.reserved = append(p.reserved, addrRange{first: addr, last: addr})
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pools[name] = p
+	return nil
+}
+
+// Allocate allocates the first free range of size addresses of the pool to the owner.
+// The current allocation of the owner is returned if it already has one.
+func (a *Allocator) Allocate(ctx context.Context, pool string, owner string, size int) (*agent.IPRange, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid range size %d", size)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, ok := a.pools[pool]
+	if !ok {
+		return nil, fmt.Errorf("unknown pool %s", pool)
+	}
+
+	allocations, err := a.store.LoadIPAllocations(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	if allocations == nil {
+		allocations = make(map[string]string)
+	}
+	if curr, ok := allocations[owner]; ok {
+		r, err := parseRange(curr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allocation of %s in pool %s: %w", owner, pool, err)
+		}
+		return &agent.IPRange{First: r.first.String(), Last: r.last.String()}, nil
+	}
+
+	used := append(make([]addrRange, 0, len(allocations)+len(p.reserved)), p.reserved...)
+	for o, s := range allocations {
+		r, err := parseRange(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allocation of %s in pool %s: %w", o, pool, err)
+		}
+		used = append(used, r)
+	}
+
+	r, ok := findFree(p.prefix, used, size)
+	if !ok {
+		return nil, fmt.Errorf("pool %s has no %d free consecutive addresses", pool, size)
+	}
+
+	allocations[owner] = r.String()
+	if err := a.store.SaveIPAllocations(ctx, pool, allocations); err != nil {
+		return nil, err
+	}
+
+	return &agent.IPRange{First: r.first.String(), Last: r.last.String()}, nil
+}
+
+// Release releases the addresses of the pool allocated to the owner, if any
+func (a *Allocator) Release(ctx context.Context, pool string, owner string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.pools[pool]; !ok {
+		return fmt.Errorf("unknown pool %s", pool)
+	}
+
+	allocations, err := a.store.LoadIPAllocations(ctx, pool)
+	if err != nil {
+		return err
+	}
+	if _, ok := allocations[owner]; !ok {
+		return nil
+	}
+
+	delete(allocations, owner)
+	return a.store.SaveIPAllocations(ctx, pool, allocations)
+}
+
+// addrRange is a range of consecutive addresses, bounds included
+type addrRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+func (r addrRange) String() string {
+	return fmt.Sprintf("%s-%s", r.first, r.last)
+}
+
+func parseRange(s string) (addrRange, error) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return addrRange{}, fmt.Errorf("invalid range %s", s)
+	}
+	firstAddr, err := netip.ParseAddr(first)
+	if err != nil {
+		return addrRange{}, err
+	}
+	lastAddr, err := netip.ParseAddr(last)
+	if err != nil {
+		return addrRange{}, err
+	}
+	if lastAddr.Less(firstAddr) {
+		return addrRange{}, fmt.Errorf("invalid range %s", s)
+	}
+	return addrRange{first: firstAddr, last: lastAddr}, nil
+}
+
+// findFree returns the first range of size addresses of the prefix not overlapping the used ones
+func findFree(prefix netip.Prefix, used []addrRange, size int) (addrRange, bool) {
+	sort.Slice(used, func(i, j int) bool {
+		return used[i].first.Less(used[j].first)
+	})
+
+	first, last := usableBounds(prefix)
+	candidate := first
+	for {
+		end, ok := advance(candidate, size-1)
+		if !ok || last.Less(end) {
+			return addrRange{}, false
+		}
+
+		// Skip past the first used range overlapping the candidate
+		overlap := false
+		for _, r := range used {
+			if !end.Less(r.first) && !r.last.Less(candidate) {
+				next := r.last.Next()
+				if !next.IsValid() {
+					return addrRange{}, false
+				}
+				candidate = next
+				overlap = true
+				break
+			}
+		}
+		if !overlap {
+			return addrRange{first: candidate, last: end}, true
+		}
+	}
+}
+
+// usableBounds returns the first and last assignable addresses of a prefix,
+// excluding the network and broadcast addresses of the IPv4 subnets
+func usableBounds(prefix netip.Prefix) (netip.Addr, netip.Addr) {
+	first := prefix.Addr()
+	b := first.AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	last, _ := netip.AddrFromSlice(b)
+
+	if first.Is4() && prefix.Bits() < 31 {
+		first = first.Next()
+		last = last.Prev()
+	}
+	return first, last
+}
+
+// advance returns the address n addresses after addr
+func advance(addr netip.Addr, n int) (netip.Addr, bool) {
+	for i := 0; i < n; i++ {
+		addr = addr.Next()
+		if !addr.IsValid() {
+			return addr, false
+		}
+	}
+	return addr, true
+}
@@ -0,0 +1,75 @@
+package ipam
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memStore struct {
+	pools map[string]map[string]string
+}
+
+func (s *memStore) LoadIPAllocations(_ context.Context, pool string) (map[string]string, error) {
+	allocations := make(map[string]string)
+	for k, v := range s.pools[pool] {
+		allocations[k] = v
+	}
+	return allocations, nil
+}
+
+func (s *memStore) SaveIPAllocations(_ context.Context, pool string, allocations map[string]string) error {
+	s.pools[pool] = allocations
+	return nil
+}
+
+func TestAllocator(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{pools: make(map[string]map[string]string)}
+	allocator := NewAllocator(store)
+	require.NoError(t, allocator.AddPool("lb", "192.168.10.0/28"))
+	require.Error(t, allocator.AddPool("Invalid_Name", "192.168.10.0/28"))
+
+	// The network address is skipped
+	r, err := allocator.Allocate(ctx, "lb", "tenant-a", 4)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.10.1-192.168.10.4", r.String())
+
+	r, err = allocator.Allocate(ctx, "lb", "tenant-b", 4)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.10.5-192.168.10.8", r.String())
+
+	// Allocating again returns the current allocation
+	r, err = allocator.Allocate(ctx, "lb", "tenant-a", 4)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.10.1-192.168.10.4", r.String())
+
+	// The broadcast address is skipped, leaving only 6 free addresses
+	_, err = allocator.Allocate(ctx, "lb", "tenant-c", 7)
+	require.Error(t, err)
+
+	// Released ranges are reused
+	require.NoError(t, allocator.Release(ctx, "lb", "tenant-a"))
+	r, err = allocator.Allocate(ctx, "lb", "tenant-c", 2)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.10.1-192.168.10.2", r.String())
+	r, err = allocator.Allocate(ctx, "lb", "tenant-d", 3)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.10.9-192.168.10.11", r.String())
+	require.Len(t, store.pools["lb"], 3)
+
+	_, err = allocator.Allocate(ctx, "unknown", "tenant-a", 1)
+	require.Error(t, err)
+}
+
+func TestAllocatorReserved(t *testing.T) {
+	ctx := context.Background()
+	allocator := NewAllocator(&memStore{pools: make(map[string]map[string]string)})
+	require.NoError(t, allocator.AddPool("nodes", "10.1.0.0/24", "10.1.0.1"))
+
+	r, err := allocator.Allocate(ctx, "nodes", "node-a", 1)
+	require.NoError(t, err)
+	require.Equal(t, "10.1.0.2", r.First)
+	require.Equal(t, "10.1.0.2", r.Last)
+}
@@ -0,0 +1,60 @@
+package kamaji
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// addonsConfigMap is the name of the ConfigMap tracking the addons installed in a tenant cluster
+const addonsConfigMap = "fulcrum-addons"
+
+// GetAddons retrieves the addons installed in the tenant cluster
+func (t *TenantClient) GetAddons(ctx context.Context) (map[string]agent.InstalledAddon, error) {
+	addons := make(map[string]agent.InstalledAddon)
+
+	cm, err := t.clientset.CoreV1().ConfigMaps(manifestInventoryNamespace).Get(ctx, addonsConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return addons, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installed addons: %w", err)
+	}
+
+	for name, data := range cm.Data {
+		var addon agent.InstalledAddon
+		if err := json.Unmarshal([]byte(data), &addon); err != nil {
+			return nil, fmt.Errorf("failed to parse installed addon %s: %w", name, err)
+		}
+		addons[name] = addon
+	}
+
+	return addons, nil
+}
+
+// SetAddons records the addons installed in the tenant cluster
+func (t *TenantClient) SetAddons(ctx context.Context, addons map[string]agent.InstalledAddon) error {
+	data := make(map[string]string, len(addons))
+	for name, addon := range addons {
+		b, err := json.Marshal(addon)
+		if err != nil {
+			return fmt.Errorf("failed to marshal installed addon %s: %w", name, err)
+		}
+		data[name] = string(b)
+	}
+
+	// Server-side apply replaces the whole data map, dropping the removed addons
+	cm := corev1ac.ConfigMap(addonsConfigMap, manifestInventoryNamespace).WithData(data)
+	_, err := t.clientset.CoreV1().ConfigMaps(manifestInventoryNamespace).Apply(ctx, cm, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to save installed addons: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package kamaji
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// FieldManager is the field manager used for server-side apply
+	FieldManager = "fulcrum-kube-agent"
+
+	// ManifestLabel is the label set on every object applied as part of a named manifest
+	ManifestLabel = "fulcrum.io/manifest"
+
+	// manifestInventoryNamespace is the namespace holding the manifest inventories
+	manifestInventoryNamespace = "kube-system"
+
+	// manifestInventoryPrefix is the name prefix of the ConfigMaps holding the manifest inventories
+	manifestInventoryPrefix = "fulcrum-manifest-"
+
+	// manifestInventoryKey is the ConfigMap key holding the applied object references
+	manifestInventoryKey = "objects"
+)
+
+var crdGVR = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// objectRef identifies an object applied as part of a manifest
+type objectRef struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (r objectRef) gvk() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// key identifies the object regardless of its API version
+func (r objectRef) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", r.Group, r.Kind, r.Namespace, r.Name)
+}
+
+// ApplyManifest applies a multi-document YAML manifest to the tenant cluster using server-side apply.
+// CustomResourceDefinitions are applied first and waited for until Established. Objects previously
+// applied under the same name and no longer present in the manifest are pruned.
+func (t *TenantClient) ApplyManifest(ctx context.Context, name string, manifest string) error {
+	objs, err := parseManifest(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to parse manifest %s: %w", name, err)
+	}
+
+	previous, err := t.getManifestInventory(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var crds []string
+	applied := make([]objectRef, 0, len(objs))
+	for i, u := range objs {
+		// Once all the CRDs are applied wait for them before applying the custom resources
+		if len(crds) > 0 && !isCRD(u) {
+			if err := t.waitForCRDsEstablished(ctx, crds); err != nil {
+				return err
+			}
+			t.restMapper.Reset()
+			crds = nil
+		}
+
+		labels := u.GetLabels()
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[ManifestLabel] = name
+		u.SetLabels(labels)
+
+		ref, err := t.applyObject(ctx, u)
+		if err != nil {
+			return fmt.Errorf("error applying manifest %s object %d: %w", name, i, err)
+		}
+		applied = append(applied, ref)
+		if isCRD(u) {
+			crds = append(crds, u.GetName())
+		}
+	}
+	if len(crds) > 0 {
+		if err := t.waitForCRDsEstablished(ctx, crds); err != nil {
+			return err
+		}
+		t.restMapper.Reset()
+	}
+
+	// Prune the objects that were removed from the manifest
+	if err := t.deleteObjects(ctx, pruneRefs(previous, applied)); err != nil {
+		return fmt.Errorf("failed to prune manifest %s: %w", name, err)
+	}
+
+	return t.saveManifestInventory(ctx, name, applied)
+}
+
+// DeleteManifest deletes all the objects previously applied under the given manifest name
+func (t *TenantClient) DeleteManifest(ctx context.Context, name string) error {
+	refs, err := t.getManifestInventory(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if err := t.deleteObjects(ctx, refs); err != nil {
+		return fmt.Errorf("failed to delete manifest %s: %w", name, err)
+	}
+
+	err = t.clientset.CoreV1().ConfigMaps(manifestInventoryNamespace).Delete(ctx, manifestInventoryPrefix+name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete manifest %s inventory: %w", name, err)
+	}
+
+	return nil
+}
+
+func (t *TenantClient) applyObject(ctx context.Context, u *unstructured.Unstructured) (objectRef, error) {
+	gvk := u.GroupVersionKind()
+	mapping, err := t.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may be served by CRDs created after the discovery, such as the ones of a Helm chart
+		t.restMapper.Reset()
+		mapping, err = t.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return objectRef{}, fmt.Errorf("failed to get REST mapping for %s: %w", gvk, err)
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if u.GetNamespace() == "" {
+			u.SetNamespace(metav1.NamespaceDefault)
+		}
+	} else {
+		u.SetNamespace("")
+	}
+
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return objectRef{}, fmt.Errorf("failed to marshal %s '%s': %w", u.GetKind(), u.GetName(), err)
+	}
+
+	force := true
+	_, err = t.dynamicClient.Resource(mapping.Resource).Namespace(u.GetNamespace()).Patch(
+		ctx,
+		u.GetName(),
+		types.ApplyPatchType,
+		data,
+		metav1.PatchOptions{FieldManager: FieldManager, Force: &force},
+	)
+	if err != nil {
+		return objectRef{}, fmt.Errorf("error applying %s '%s' in %s: %w", u.GetKind(), u.GetName(), u.GetNamespace(), err)
+	}
+
+	return objectRef{
+		Group:     gvk.Group,
+		Version:   gvk.Version,
+		Kind:      gvk.Kind,
+		Namespace: u.GetNamespace(),
+		Name:      u.GetName(),
+	}, nil
+}
+
+// deleteObjects deletes the referenced objects in reverse order, ignoring the ones already gone
+func (t *TenantClient) deleteObjects(ctx context.Context, refs []objectRef) error {
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := refs[i]
+		mapping, err := t.restMapper.RESTMapping(ref.gvk().GroupKind(), ref.Version)
+		if err != nil {
+			// The kind is not served anymore (e.g. its CRD is gone), so neither is the object
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get REST mapping for %s: %w", ref.gvk(), err)
+		}
+
+		err = t.dynamicClient.Resource(mapping.Resource).Namespace(ref.Namespace).Delete(ctx, ref.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting %s '%s' in %s: %w", ref.Kind, ref.Name, ref.Namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// waitForCRDsEstablished waits for the given CustomResourceDefinitions to be Established
+func (t *TenantClient) waitForCRDsEstablished(ctx context.Context, names []string) error {
+	for _, name := range names {
+		err := wait.PollUntilContextTimeout(ctx, PollInterval, DefaultTimeout, true, func(ctx context.Context) (bool, error) {
+			crd, err := t.dynamicClient.Resource(crdGVR).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil // Not visible yet, continue polling
+			}
+			return isEstablished(crd), nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to wait for CRD %s to be established: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (t *TenantClient) getManifestInventory(ctx context.Context, name string) ([]objectRef, error) {
+	cm, err := t.clientset.CoreV1().ConfigMaps(manifestInventoryNamespace).Get(ctx, manifestInventoryPrefix+name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest %s inventory: %w", name, err)
+	}
+
+	var refs []objectRef
+	if err := json.Unmarshal([]byte(cm.Data[manifestInventoryKey]), &refs); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s inventory: %w", name, err)
+	}
+
+	return refs, nil
+}
+
+func (t *TenantClient) saveManifestInventory(ctx context.Context, name string, refs []objectRef) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest %s inventory: %w", name, err)
+	}
+
+	cm := corev1ac.ConfigMap(manifestInventoryPrefix+name, manifestInventoryNamespace).
+		WithLabels(map[string]string{ManifestLabel: name}).
+		WithData(map[string]string{manifestInventoryKey: string(data)})
+	_, err = t.clientset.CoreV1().ConfigMaps(manifestInventoryNamespace).Apply(ctx, cm, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to save manifest %s inventory: %w", name, err)
+	}
+
+	return nil
+}
+
+// parseManifest decodes a multi-document YAML manifest and sorts its objects in apply order
+func parseManifest(manifest string) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest), 4096)
+	for {
+		var obj map[string]any
+		if err := decoder.Decode(&obj); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		u := &unstructured.Unstructured{Object: obj}
+		if u.GetKind() == "" || u.GetName() == "" {
+			return nil, fmt.Errorf("object %d is missing kind or name", len(objs))
+		}
+		objs = append(objs, u)
+	}
+
+	sort.SliceStable(objs, func(i, j int) bool {
+		return applyOrder(objs[i]) < applyOrder(objs[j])
+	})
+
+	return objs, nil
+}
+
+// applyOrder returns the dependency rank of an object: CRDs, then namespaces, then everything else
+func applyOrder(u *unstructured.Unstructured) int {
+	switch {
+	case isCRD(u):
+		return 0
+	case u.GroupVersionKind().GroupKind() == (schema.GroupKind{Kind: "Namespace"}):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func isCRD(u *unstructured.Unstructured) bool {
+	return u.GroupVersionKind().GroupKind() == schema.GroupKind{Group: crdGVR.Group, Kind: "CustomResourceDefinition"}
+}
+
+func isEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneRefs returns the previously applied objects that are not part of the current apply
+func pruneRefs(previous, current []objectRef) []objectRef {
+	keep := make(map[string]bool, len(current))
+	for _, ref := range current {
+		keep[ref.key()] = true
+	}
+
+	var prune []objectRef
+	for _, ref := range previous {
+		if !keep[ref.key()] {
+			prune = append(prune, ref)
+		}
+	}
+	return prune
+}
@@ -0,0 +1,70 @@
+package kamaji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	manifest := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: demo
+---
+# Only a comment
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: demo
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+`
+
+	objs, err := parseManifest(manifest)
+	require.NoError(t, err)
+	require.Len(t, objs, 3)
+	require.Equal(t, "CustomResourceDefinition", objs[0].GetKind())
+	require.Equal(t, "Namespace", objs[1].GetKind())
+	require.Equal(t, "Deployment", objs[2].GetKind())
+
+	_, err = parseManifest("apiVersion: v1\nkind: ConfigMap\n")
+	require.Error(t, err)
+}
+
+func TestParseCalicoManifest(t *testing.T) {
+	objs, err := parseManifest(calicoYamlContent)
+	require.NoError(t, err)
+	require.NotEmpty(t, objs)
+
+	// All the CRDs must come before any other object
+	seenOther := false
+	for _, u := range objs {
+		if isCRD(u) {
+			require.False(t, seenOther, "CRD %s applied after non CRD objects", u.GetName())
+		} else {
+			seenOther = true
+		}
+	}
+}
+
+func TestPruneRefs(t *testing.T) {
+	previous := []objectRef{
+		{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "demo", Name: "app"},
+		{Version: "v1", Kind: "ConfigMap", Namespace: "demo", Name: "old"},
+	}
+	current := []objectRef{
+		// A version bump of the same object must not prune it
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment", Namespace: "demo", Name: "app"},
+	}
+
+	prune := pruneRefs(previous, current)
+	require.Len(t, prune, 1)
+	require.Equal(t, "old", prune[0].Name)
+}
@@ -17,11 +17,11 @@ import (
 	"fulcrumproject.org/kube-agent/internal/agent"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/discovery"
 	memory "k8s.io/client-go/discovery/cached"
 	"k8s.io/client-go/dynamic"
@@ -457,20 +457,20 @@ func NewTenantClient(tenantName string, tenantConfig *rest.Config) (*TenantClien
 }
 
 // CreateJoinToken creates a bootstrap token for nodes to join the cluster
-func (t *TenantClient) CreateJoinToken(ctx context.Context, tenantName string, validityHours int) (*agent.JoinTokenResponse, error) {
+func (t *TenantClient) CreateJoinToken(ctx context.Context, description string, ttl time.Duration) (*agent.JoinTokenResponse, error) {
 	// Generate token ID and secret
 	tokenID := generateRandomString(6)
 	tokenSecret := generateRandomString(16)
 	fullToken := fmt.Sprintf("%s.%s", tokenID, tokenSecret)
 
 	// Calculate expiration time
-	if validityHours <= 0 {
-		validityHours = 24 // Default to 24 hours
+	if ttl <= 0 {
+		ttl = 24 * time.Hour // Default to 24 hours
 	}
-	expirationTime := time.Now().Add(time.Duration(validityHours) * time.Hour)
+	expirationTime := time.Now().Add(ttl)
 
 	// Create the bootstrap token secret
-	_, err := createBootstrapTokenSecret(t.clientset, tokenID, tokenSecret, expirationTime)
+	_, err := createBootstrapTokenSecret(ctx, t.clientset, tokenID, tokenSecret, description, expirationTime)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bootstrap token: %w", err)
 	}
@@ -483,6 +483,15 @@ func (t *TenantClient) CreateJoinToken(ctx context.Context, tenantName string, v
 	}, nil
 }
 
+// DeleteJoinToken revokes a bootstrap token by deleting its secret
+func (t *TenantClient) DeleteJoinToken(ctx context.Context, tokenID string) error {
+	err := t.clientset.CoreV1().Secrets("kube-system").Delete(ctx, bootstrapTokenSecretName(tokenID), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete bootstrap token %s: %w", tokenID, err)
+	}
+	return nil
+}
+
 // generateRandomString creates a random string of specified length containing lowercase letters and numbers
 func generateRandomString(length int) string {
 	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
@@ -502,18 +511,23 @@ func generateRandomString(length int) string {
 	return string(result)
 }
 
-func createBootstrapTokenSecret(clientset kubernetes.Interface, tokenID, tokenSecret string, expiration time.Time) (*corev1.Secret, error) {
+func bootstrapTokenSecretName(tokenID string) string {
+	return fmt.Sprintf("bootstrap-token-%s", tokenID)
+}
+
+func createBootstrapTokenSecret(ctx context.Context, clientset kubernetes.Interface, tokenID, tokenSecret, description string, expiration time.Time) (*corev1.Secret, error) {
 	// Create bootstrap token according to Kubernetes standards
 	// See https://kubernetes.io/docs/reference/access-authn-authz/bootstrap-tokens/
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("bootstrap-token-%s", tokenID),
+			Name:      bootstrapTokenSecretName(tokenID),
 			Namespace: "kube-system",
 		},
 		Type: corev1.SecretTypeBootstrapToken,
 		Data: map[string][]byte{
 			"token-id":                       []byte(tokenID),
 			"token-secret":                   []byte(tokenSecret),
+			"description":                    []byte(description),
 			"expiration":                     []byte(expiration.Format(time.RFC3339)),
 			"usage-bootstrap-authentication": []byte("true"),
 			"usage-bootstrap-signing":        []byte("true"),
@@ -521,19 +535,20 @@ func createBootstrapTokenSecret(clientset kubernetes.Interface, tokenID, tokenSe
 		},
 	}
 
-	return clientset.CoreV1().Secrets("kube-system").Create(context.Background(), secret, metav1.CreateOptions{})
+	return clientset.CoreV1().Secrets("kube-system").Create(ctx, secret, metav1.CreateOptions{})
 }
 
-// DeleteWorkerNode deletes a worker node from the tenant cluster
+// DeleteWorkerNode deletes a worker node from the tenant cluster.
+// A node that never joined the cluster is already deleted.
 func (t *TenantClient) DeleteWorkerNode(ctx context.Context, nodeName string) error {
 	// Delete the node from the Kubernetes cluster
 	err := t.clientset.CoreV1().Nodes().Delete(
-		context.Background(),
+		ctx,
 		nodeName,
 		metav1.DeleteOptions{},
 	)
 
-	if err != nil {
+	if err != nil && !apierrors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete worker node %s: %w", nodeName, err)
 	}
 
@@ -573,49 +588,7 @@ func (t *TenantClient) GetNodeStatus(ctx context.Context, nodeName string) (*age
 	}, nil
 }
 
+// CreateCalicoResources applies Calico networking resources to the tenant cluster
 func (t *TenantClient) CreateCalicoResources(ctx context.Context) error {
-	return t.createResources(ctx, calicoYamlContent)
-}
-
-func (t *TenantClient) createResources(ctx context.Context, yaml string) error {
-	docs := strings.Split(yaml, "---")
-
-	for _, doc := range docs {
-		if strings.TrimSpace(doc) == "" {
-			continue
-		}
-		err := t.createResource(ctx, doc)
-		if err != nil {
-			return fmt.Errorf("error creating resource: %w", err)
-		}
-	}
-
-	return nil
-}
-
-func (t *TenantClient) createResource(ctx context.Context, doc string) error {
-	var obj map[string]any
-	decoder := yaml.NewYAMLToJSONDecoder(strings.NewReader(doc))
-	if err := decoder.Decode(&obj); err != nil {
-		return err
-	}
-	if len(obj) == 0 {
-		return nil
-	}
-
-	u := &unstructured.Unstructured{Object: obj}
-
-	// Get the REST mapping from the RESTMapper
-	mapping, err := t.restMapper.RESTMapping(u.GroupVersionKind().GroupKind(), u.GroupVersionKind().Version)
-	if err != nil {
-		return fmt.Errorf("failed to get REST mapping for %s: %w", u.GroupVersionKind(), err)
-	}
-
-	resourceClient := t.dynamicClient.Resource(mapping.Resource).Namespace(u.GetNamespace())
-	_, err = resourceClient.Create(ctx, u, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("error creating %s '%s' in %s: %w", u.GetKind(), u.GetName(), u.GetNamespace(), err)
-	}
-
-	return nil
+	return t.ApplyManifest(ctx, "calico", calicoYamlContent)
 }
@@ -95,7 +95,7 @@ func TestKamajiClientIntegration(t *testing.T) {
 
 		// Create a join token
 		t.Logf("Creating join token for tenant: %s", testTenantName)
-		tokenResponse, err := tenantClient.CreateJoinToken(ctx, testTenantName, 24)
+		tokenResponse, err := tenantClient.CreateJoinToken(ctx, "kube-agent test token", time.Hour)
 
 		require.NoError(t, err, "CreateJoinToken should not return an error")
 		require.NotNil(t, tokenResponse, "Token response should not be nil")
@@ -104,6 +104,11 @@ func TestKamajiClientIntegration(t *testing.T) {
 		require.NotEmpty(t, tokenResponse.TokenSecret, "Token secret should not be empty")
 		t.Logf("Join token created successfully")
 
+		// Revoke the join token, twice as expired tokens may already be deleted
+		require.NoError(t, tenantClient.DeleteJoinToken(ctx, tokenResponse.TokenID), "DeleteJoinToken should not return an error")
+		require.NoError(t, tenantClient.DeleteJoinToken(ctx, tokenResponse.TokenID), "DeleteJoinToken of a deleted token should not return an error")
+		t.Logf("Join token revoked successfully")
+
 		// Delete the tenant control plane
 		t.Logf("Deleting tenant control plane: %s", testTenantName)
 		err = client.DeleteTenantControlPlane(ctx, testTenantName)
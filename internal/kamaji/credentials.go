@@ -0,0 +1,61 @@
+package kamaji
+
+import (
+	"context"
+	"fmt"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	credentialsPublicKey = "ssh-publickey"
+	credentialsPassword  = "password"
+)
+
+// GetNodeCredentials gets the generated node credentials of a tenant from the management cluster
+func (c *Client) GetNodeCredentials(ctx context.Context, name string) (*agent.NodeCredentials, error) {
+	secret, err := c.clientset.CoreV1().Secrets(KamajiNamespace).Get(ctx, agent.NodeCredentialsSecret(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant %s node credentials: %w", name, err)
+	}
+
+	return &agent.NodeCredentials{
+		SSHPublicKey:  string(secret.Data[credentialsPublicKey]),
+		SSHPrivateKey: string(secret.Data[corev1.SSHAuthPrivateKey]),
+		Password:      string(secret.Data[credentialsPassword]),
+	}, nil
+}
+
+// SaveNodeCredentials saves the generated node credentials of a tenant into the management cluster
+func (c *Client) SaveNodeCredentials(ctx context.Context, name string, creds *agent.NodeCredentials) error {
+	data := map[string][]byte{
+		credentialsPublicKey:     []byte(creds.SSHPublicKey),
+		corev1.SSHAuthPrivateKey: []byte(creds.SSHPrivateKey),
+		credentialsPassword:      []byte(creds.Password),
+	}
+	secret := corev1ac.Secret(agent.NodeCredentialsSecret(name), KamajiNamespace).
+		WithType(corev1.SecretTypeOpaque).
+		WithData(data)
+	_, err := c.clientset.CoreV1().Secrets(KamajiNamespace).Apply(ctx, secret, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to save tenant %s node credentials: %w", name, err)
+	}
+
+	return nil
+}
+
+// DeleteNodeCredentials deletes the generated node credentials of a tenant from the management cluster
+func (c *Client) DeleteNodeCredentials(ctx context.Context, name string) error {
+	err := c.clientset.CoreV1().Secrets(KamajiNamespace).Delete(ctx, agent.NodeCredentialsSecret(name), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete tenant %s node credentials: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package kamaji
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// ipamConfigMapPrefix is the name prefix of the ConfigMaps holding the IPAM pool allocations
+const ipamConfigMapPrefix = "fulcrum-ipam-"
+
+// LoadIPAllocations loads the allocations of an IPAM pool from the management cluster
+func (c *Client) LoadIPAllocations(ctx context.Context, pool string) (map[string]string, error) {
+	allocations := make(map[string]string)
+
+	cm, err := c.clientset.CoreV1().ConfigMaps(KamajiNamespace).Get(ctx, ipamConfigMapPrefix+pool, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return allocations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool %s allocations: %w", pool, err)
+	}
+
+	for owner, r := range cm.Data {
+		allocations[owner] = r
+	}
+
+	return allocations, nil
+}
+
+// SaveIPAllocations saves the allocations of an IPAM pool into the management cluster
+func (c *Client) SaveIPAllocations(ctx context.Context, pool string, allocations map[string]string) error {
+	// Server-side apply replaces the whole data map, dropping the released allocations
+	cm := corev1ac.ConfigMap(ipamConfigMapPrefix+pool, KamajiNamespace).WithData(allocations)
+	_, err := c.clientset.CoreV1().ConfigMaps(KamajiNamespace).Apply(ctx, cm, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to save pool %s allocations: %w", pool, err)
+	}
+
+	return nil
+}
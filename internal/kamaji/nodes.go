@@ -0,0 +1,46 @@
+package kamaji
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SetNodeLabels adds or updates labels on a node of the tenant cluster
+func (t *TenantClient) SetNodeLabels(ctx context.Context, nodeName string, labels map[string]string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": labels,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal node labels: %w", err)
+	}
+
+	_, err = t.clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to label node %s: %w", nodeName, err)
+	}
+
+	return nil
+}
+
+// ListVolumeHandles lists the handles of the persistent volumes provisioned by the given CSI driver
+func (t *TenantClient) ListVolumeHandles(ctx context.Context, driver string) ([]string, error) {
+	pvs, err := t.clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volumes: %w", err)
+	}
+
+	var handles []string
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == driver {
+			handles = append(handles, pv.Spec.CSI.VolumeHandle)
+		}
+	}
+
+	return handles, nil
+}
@@ -0,0 +1,142 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// EnsureRole creates a role with the given privileges, or updates the privileges of an existing one
+func (c *HTTPProxmoxClient) EnsureRole(roleID string, privileges []string) error {
+	resp, err := c.httpClient.Get(fmt.Sprintf("/api2/json/access/roles/%s", roleID))
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	resp.Body.Close()
+
+	form := url.Values{}
+	form.Add("privs", strings.Join(privileges, ","))
+	if resp.StatusCode == http.StatusOK {
+		resp, err = c.httpClient.PutForm(fmt.Sprintf("/api2/json/access/roles/%s", roleID), form)
+	} else {
+		form.Add("roleid", roleID)
+		resp, err = c.httpClient.PostForm("/api2/json/access/roles", form)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to ensure role", nil)
+}
+
+// CreateAPIToken creates a user and a privilege separated API token for it
+func (c *HTTPProxmoxClient) CreateAPIToken(userID string, tokenName string) (*agent.APIToken, error) {
+	form := url.Values{}
+	form.Add("userid", userID)
+	form.Add("comment", "Managed by fulcrum-kube-agent")
+	resp, err := c.httpClient.PostForm("/api2/json/access/users", form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := decodeData(resp, "failed to create user", nil); err != nil {
+		return nil, err
+	}
+
+	form = url.Values{}
+	form.Add("privsep", "1")
+	resp, err = c.httpClient.PostForm(fmt.Sprintf("/api2/json/access/users/%s/token/%s", userID, tokenName), form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token agent.APIToken
+	if err := decodeData(resp, "failed to create API token", &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GrantPermission grants a role to an API token on the given ACL path
+func (c *HTTPProxmoxClient) GrantPermission(path string, tokenID string, roleID string) error {
+	form := url.Values{}
+	form.Add("path", path)
+	form.Add("roles", roleID)
+	form.Add("tokens", tokenID)
+	form.Add("propagate", "1")
+	resp, err := c.httpClient.PutForm("/api2/json/access/acl", form)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to grant permission", nil)
+}
+
+// DeleteUser deletes a user together with its API tokens and permissions
+func (c *HTTPProxmoxClient) DeleteUser(userID string) error {
+	resp, err := c.httpClient.Delete(fmt.Sprintf("/api2/json/access/users/%s", userID))
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to delete user", nil)
+}
+
+// DeleteVolume deletes a storage volume identified as <storage>:<volume> through a host, the configured one if empty.
+// The returned task is nil if the deletion completed synchronously.
+func (c *HTTPProxmoxClient) DeleteVolume(host string, volumeID string) (*agent.TaskResponse, error) {
+	storage, _, ok := strings.Cut(volumeID, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid volume ID %s", volumeID)
+	}
+	if host == "" {
+		host = c.nodeName
+	}
+
+	endpoint := fmt.Sprintf("/api2/json/nodes/%s/storage/%s/content/%s", host, storage, volumeID)
+	resp, err := c.httpClient.Delete(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var upid *string
+	if err := decodeData(resp, "failed to delete volume", &upid); err != nil {
+		return nil, err
+	}
+	if upid == nil || *upid == "" {
+		return nil, nil
+	}
+
+	return parseUPID(*upid)
+}
+
+// decodeData checks the response status and decodes its data field into target, if not nil
+func decodeData(resp *http.Response, errMsg string, target any) error {
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s, status: %d, body: %s", errMsg, resp.StatusCode, string(bodyBytes))
+	}
+	if target == nil {
+		return nil
+	}
+
+	data := struct {
+		Data any `json:"data"`
+	}{Data: target}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
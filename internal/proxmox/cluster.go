@@ -0,0 +1,120 @@
+package proxmox
+
+import (
+	"fmt"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// clusterResource is an entry of the cluster resources, a host or a VM depending on its type
+type clusterResource struct {
+	Type     string  `json:"type"` // Such as 'node' or 'qemu'
+	Node     string  `json:"node"`
+	Status   string  `json:"status"`
+	VMID     int     `json:"vmid"`
+	Name     string  `json:"name"`
+	Template int     `json:"template"` // 1 for templates
+	CPU      float64 `json:"cpu"`
+	MaxCPU   int     `json:"maxcpu"`
+	Mem      int64   `json:"mem"`
+	MaxMem   int64   `json:"maxmem"`
+	MaxDisk  int64   `json:"maxdisk"`
+}
+
+// clusterResources lists the cluster resources of a type, such as 'node' or 'vm'
+func (c *HTTPProxmoxClient) clusterResources(resourceType string) ([]clusterResource, error) {
+	resp, err := c.httpClient.Get("/api2/json/cluster/resources?type=" + resourceType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var resources []clusterResource
+	if err := decodeData(resp, "failed to list cluster resources", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetHosts lists the hosts of the Proxmox cluster with their load and the resources allocated to their VMs
+func (c *HTTPProxmoxClient) GetHosts() ([]agent.HostStatus, error) {
+	resources, err := c.clusterResources("node")
+	if err != nil {
+		return nil, err
+	}
+	vms, err := c.clusterResources("vm")
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]agent.HostStatus, 0, len(resources))
+	for _, r := range resources {
+		host := agent.HostStatus{
+			Name:      r.Node,
+			Online:    r.Status == "online",
+			CPU:       r.CPU,
+			MaxCPU:    r.MaxCPU,
+			Memory:    r.Mem,
+			MaxMemory: r.MaxMem,
+		}
+		for _, vm := range vms {
+			if vm.Node == r.Node && vm.Template != 1 {
+				host.AllocatedCPU += vm.MaxCPU
+				host.AllocatedMemory += vm.MaxMem
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// ListVMs lists the VMs of the Proxmox cluster, templates excluded
+func (c *HTTPProxmoxClient) ListVMs() ([]agent.VMInfo, error) {
+	resources, err := c.clusterResources("vm")
+	if err != nil {
+		return nil, err
+	}
+
+	var vms []agent.VMInfo
+	for _, r := range resources {
+		if r.Template == 1 {
+			continue
+		}
+		vms = append(vms, agent.VMInfo{
+			Name:      r.Name,
+			Status:    agent.VMStatus(r.Status),
+			VMID:      r.VMID,
+			NodeName:  r.Node,
+			CPU:       r.CPU,
+			CPUCount:  r.MaxCPU,
+			Memory:    r.Mem,
+			MaxMemory: r.MaxMem,
+			MaxDisk:   r.MaxDisk,
+		})
+	}
+	return vms, nil
+}
+
+// vmNode returns the host of a VM. The host is looked up on every call, as HA may have moved the VM,
+// and defaults to the configured one when the VM is not found, letting the request fail there.
+func (c *HTTPProxmoxClient) vmNode(vmID int) (string, error) {
+	resources, err := c.clusterResources("vm")
+	if err != nil {
+		return "", err
+	}
+	for _, r := range resources {
+		if r.VMID == vmID && r.Node != "" {
+			return r.Node, nil
+		}
+	}
+	return c.nodeName, nil
+}
+
+// vmEndpoint returns the API path of a VM on its host, followed by the suffix
+func (c *HTTPProxmoxClient) vmEndpoint(vmID int, suffix string) (string, error) {
+	node, err := c.vmNode(vmID)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate VM %d: %w", vmID, err)
+	}
+	return fmt.Sprintf("/api2/json/nodes/%s/qemu/%d%s", node, vmID, suffix), nil
+}
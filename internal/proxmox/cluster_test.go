@@ -0,0 +1,28 @@
+package proxmox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fulcrumproject.org/kube-agent/internal/httpcli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVMEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api2/json/cluster/resources", r.URL.Path)
+		require.Equal(t, "vm", r.URL.Query().Get("type"))
+		w.Write([]byte(`{"data":[{"vmid":1001,"node":"pve2","type":"qemu"}]}`))
+	}))
+	defer server.Close()
+	cli := NewProxmoxClient("pve1", "local-lvm", httpcli.NewHTTPClient(server.URL, "token"))
+
+	// The VMs are reached on their host, the configured one for unknown VMs
+	endpoint, err := cli.vmEndpoint(1001, "/config")
+	require.NoError(t, err)
+	require.Equal(t, "/api2/json/nodes/pve2/qemu/1001/config", endpoint)
+	endpoint, err = cli.vmEndpoint(1002, "/config")
+	require.NoError(t, err)
+	require.Equal(t, "/api2/json/nodes/pve1/qemu/1002/config", endpoint)
+}
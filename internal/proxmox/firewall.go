@@ -0,0 +1,223 @@
+package proxmox
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// EnsureIPSet creates a cluster IP set if it does not exist yet
+func (c *HTTPProxmoxClient) EnsureIPSet(name string, comment string) error {
+	resp, err := c.httpClient.Get("/api2/json/cluster/firewall/ipset")
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var ipSets []struct {
+		Name string `json:"name"`
+	}
+	if err := decodeData(resp, "failed to list IP sets", &ipSets); err != nil {
+		return err
+	}
+	for _, ipSet := range ipSets {
+		if ipSet.Name == name {
+			return nil
+		}
+	}
+
+	form := url.Values{}
+	form.Add("name", name)
+	form.Add("comment", comment)
+	resp, err = c.httpClient.PostForm("/api2/json/cluster/firewall/ipset", form)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to create IP set", nil)
+}
+
+// AddIPSetEntry adds an address to a cluster IP set if it is not there yet
+func (c *HTTPProxmoxClient) AddIPSetEntry(name string, cidr string) error {
+	endpoint := fmt.Sprintf("/api2/json/cluster/firewall/ipset/%s", name)
+	resp, err := c.httpClient.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []struct {
+		CIDR string `json:"cidr"`
+	}
+	if err := decodeData(resp, "failed to list IP set entries", &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.CIDR == cidr {
+			return nil
+		}
+	}
+
+	form := url.Values{}
+	form.Add("cidr", cidr)
+	resp, err = c.httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to add IP set entry", nil)
+}
+
+// RemoveIPSetEntry removes an address from a cluster IP set
+func (c *HTTPProxmoxClient) RemoveIPSetEntry(name string, cidr string) error {
+	resp, err := c.httpClient.Delete(fmt.Sprintf("/api2/json/cluster/firewall/ipset/%s/%s", name, url.PathEscape(cidr)))
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to remove IP set entry", nil)
+}
+
+// DeleteIPSet deletes a cluster IP set together with its entries
+func (c *HTTPProxmoxClient) DeleteIPSet(name string) error {
+	resp, err := c.httpClient.Delete(fmt.Sprintf("/api2/json/cluster/firewall/ipset/%s?force=1", name))
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to delete IP set", nil)
+}
+
+// EnsureSecurityGroup creates a cluster security group, or replaces the rules of an existing one
+func (c *HTTPProxmoxClient) EnsureSecurityGroup(name string, rules []agent.FirewallRule) error {
+	endpoint := fmt.Sprintf("/api2/json/cluster/firewall/groups/%s", name)
+
+	exists, err := c.clearSecurityGroup(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		form := url.Values{}
+		form.Add("group", name)
+		form.Add("comment", "Managed by fulcrum-kube-agent")
+		resp, err := c.httpClient.PostForm("/api2/json/cluster/firewall/groups", form)
+		if err != nil {
+			return fmt.Errorf("failed to execute request: %w", err)
+		}
+		defer resp.Body.Close()
+		if err := decodeData(resp, "failed to create security group", nil); err != nil {
+			return err
+		}
+	}
+
+	// New rules are inserted on top, so they are added last to first
+	for i := len(rules) - 1; i >= 0; i-- {
+		if err := c.addRule(endpoint, rules[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteSecurityGroup deletes a cluster security group together with its rules
+func (c *HTTPProxmoxClient) DeleteSecurityGroup(name string) error {
+	exists, err := c.clearSecurityGroup(name)
+	if err != nil || !exists {
+		return err
+	}
+
+	resp, err := c.httpClient.Delete(fmt.Sprintf("/api2/json/cluster/firewall/groups/%s", name))
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to delete security group", nil)
+}
+
+// EnableVMFirewall enables the firewall of a VM and applies the security group to it
+func (c *HTTPProxmoxClient) EnableVMFirewall(vmID int, group string) error {
+	endpoint, err := c.vmEndpoint(vmID, "/firewall")
+	if err != nil {
+		return err
+	}
+
+	// Proxmox drops the inbound traffic by default, only the traffic dropped by the security group is isolated
+	form := url.Values{}
+	form.Add("enable", "1")
+	form.Add("policy_in", "ACCEPT")
+	resp, err := c.httpClient.PutForm(endpoint+"/options", form)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := decodeData(resp, "failed to enable VM firewall", nil); err != nil {
+		return err
+	}
+
+	return c.addRule(endpoint+"/rules", agent.FirewallRule{Type: "group", Action: group, Comment: "Tenant isolation"})
+}
+
+// clearSecurityGroup deletes all the rules of a security group, reporting whether the group exists
+func (c *HTTPProxmoxClient) clearSecurityGroup(name string) (bool, error) {
+	endpoint := fmt.Sprintf("/api2/json/cluster/firewall/groups/%s", name)
+	resp, err := c.httpClient.Get(endpoint)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Proxmox answers with an internal error for unknown groups
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+
+	var rules []struct {
+		Pos int `json:"pos"`
+	}
+	if err := decodeData(resp, "failed to list security group rules", &rules); err != nil {
+		return false, err
+	}
+
+	// Delete from the bottom so that the positions of the remaining rules do not shift
+	for i := len(rules) - 1; i >= 0; i-- {
+		resp, err := c.httpClient.Delete(fmt.Sprintf("%s/%d", endpoint, rules[i].Pos))
+		if err != nil {
+			return false, fmt.Errorf("failed to execute request: %w", err)
+		}
+		err = decodeData(resp, "failed to delete security group rule", nil)
+		resp.Body.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (c *HTTPProxmoxClient) addRule(endpoint string, rule agent.FirewallRule) error {
+	form := url.Values{}
+	form.Add("type", rule.Type)
+	form.Add("action", rule.Action)
+	form.Add("enable", "1")
+	if rule.Source != "" {
+		form.Add("source", rule.Source)
+	}
+	if rule.Comment != "" {
+		form.Add("comment", rule.Comment)
+	}
+	resp, err := c.httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "failed to add firewall rule", nil)
+}
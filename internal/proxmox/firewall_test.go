@@ -0,0 +1,53 @@
+package proxmox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"fulcrumproject.org/kube-agent/internal/httpcli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableVMFirewall(t *testing.T) {
+	forms := make(map[string]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api2/json/cluster/resources" {
+			w.Write([]byte(`{"data":[{"vmid":1001,"node":"pve2","type":"qemu"}]}`))
+			return
+		}
+		require.NoError(t, r.ParseForm())
+		forms[r.Method+" "+r.URL.Path] = r.PostForm
+		w.Write([]byte(`{"data":null}`))
+	}))
+	defer server.Close()
+
+	cli := NewProxmoxClient("pve1", "local-lvm", httpcli.NewHTTPClient(server.URL, "token"))
+	require.NoError(t, cli.EnableVMFirewall(1001, "fk-tenant"))
+
+	// The inbound traffic not dropped by the security group is accepted
+	options := forms["PUT /api2/json/nodes/pve2/qemu/1001/firewall/options"]
+	require.Equal(t, "1", options.Get("enable"))
+	require.Equal(t, "ACCEPT", options.Get("policy_in"))
+	rule := forms["POST /api2/json/nodes/pve2/qemu/1001/firewall/rules"]
+	require.Equal(t, "group", rule.Get("type"))
+	require.Equal(t, "fk-tenant", rule.Get("action"))
+}
+
+func TestDeleteIPSet(t *testing.T) {
+	var deleted *url.URL
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		deleted = r.URL
+		w.Write([]byte(`{"data":null}`))
+	}))
+	defer server.Close()
+
+	cli := NewProxmoxClient("pve1", "local-lvm", httpcli.NewHTTPClient(server.URL, "token"))
+	require.NoError(t, cli.DeleteIPSet("fk-tenant"))
+
+	// The entries are deleted with the IP set
+	require.Equal(t, "/api2/json/cluster/firewall/ipset/fk-tenant", deleted.Path)
+	require.Equal(t, "1", deleted.Query().Get("force"))
+}
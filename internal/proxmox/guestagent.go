@@ -0,0 +1,100 @@
+package proxmox
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// AgentPing checks that the QEMU guest agent of a VM responds
+func (c *HTTPProxmoxClient) AgentPing(vmID int) error {
+	endpoint, err := c.vmEndpoint(vmID, "/agent/ping")
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.PostForm(endpoint, url.Values{})
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeData(resp, "guest agent not responding", nil)
+}
+
+// AgentNetworkInterfaces lists the network interfaces of a VM as reported by its QEMU guest agent
+func (c *HTTPProxmoxClient) AgentNetworkInterfaces(vmID int) ([]agent.GuestNetworkInterface, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/agent/network-get-interfaces")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Result []agent.GuestNetworkInterface `json:"result"`
+	}
+	if err := decodeData(resp, "failed to get guest network interfaces", &data); err != nil {
+		return nil, err
+	}
+	return data.Result, nil
+}
+
+// AgentExec starts a command in a VM through its QEMU guest agent and returns its PID
+func (c *HTTPProxmoxClient) AgentExec(vmID int, command []string) (int, error) {
+	form := url.Values{}
+	for _, arg := range command {
+		form.Add("command", arg)
+	}
+
+	endpoint, err := c.vmEndpoint(vmID, "/agent/exec")
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		PID int `json:"pid"`
+	}
+	if err := decodeData(resp, "failed to execute guest command", &data); err != nil {
+		return 0, err
+	}
+	return data.PID, nil
+}
+
+// AgentExecStatus retrieves the status and output of a command started through the QEMU guest agent
+func (c *HTTPProxmoxClient) AgentExecStatus(vmID int, pid int) (*agent.GuestExecStatus, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/agent/exec-status?pid="+strconv.Itoa(pid))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Exited   int    `json:"exited"`
+		ExitCode int    `json:"exitcode"`
+		OutData  string `json:"out-data"`
+		ErrData  string `json:"err-data"`
+	}
+	if err := decodeData(resp, "failed to get guest command status", &data); err != nil {
+		return nil, err
+	}
+	return &agent.GuestExecStatus{
+		Exited:   data.Exited == 1,
+		ExitCode: data.ExitCode,
+		OutData:  data.OutData,
+		ErrData:  data.ErrData,
+	}, nil
+}
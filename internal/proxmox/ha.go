@@ -0,0 +1,80 @@
+package proxmox
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// haResource is an HA resource of the cluster
+type haResource struct {
+	SID   string `json:"sid"` // Such as 'vm:100'
+	State string `json:"state"`
+	Group string `json:"group"`
+}
+
+func haSID(vmID int) string {
+	return fmt.Sprintf("vm:%d", vmID)
+}
+
+// findHAResource reports whether a VM is registered as an HA resource
+func (c *HTTPProxmoxClient) findHAResource(vmID int) (bool, error) {
+	resp, err := c.httpClient.Get("/api2/json/cluster/ha/resources")
+	if err != nil {
+		return false, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var resources []haResource
+	if err := decodeData(resp, "failed to list HA resources", &resources); err != nil {
+		return false, err
+	}
+	for _, r := range resources {
+		if r.SID == haSID(vmID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetHAResource registers a VM as an HA resource of the group with the requested state, or updates it
+func (c *HTTPProxmoxClient) SetHAResource(vmID int, group string, state string) error {
+	exists, err := c.findHAResource(vmID)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Add("group", group)
+	form.Add("state", state)
+	if exists {
+		resp, err := c.httpClient.PutForm("/api2/json/cluster/ha/resources/"+haSID(vmID), form)
+		if err != nil {
+			return fmt.Errorf("failed to execute request: %w", err)
+		}
+		defer resp.Body.Close()
+		return decodeData(resp, "failed to update HA resource", nil)
+	}
+
+	form.Add("sid", haSID(vmID))
+	resp, err := c.httpClient.PostForm("/api2/json/cluster/ha/resources", form)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeData(resp, "failed to create HA resource", nil)
+}
+
+// RemoveHAResource unregisters a VM from HA, if it is registered
+func (c *HTTPProxmoxClient) RemoveHAResource(vmID int) error {
+	exists, err := c.findHAResource(vmID)
+	if err != nil || !exists {
+		return err
+	}
+
+	resp, err := c.httpClient.Delete("/api2/json/cluster/ha/resources/" + haSID(vmID))
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeData(resp, "failed to delete HA resource", nil)
+}
@@ -32,15 +32,26 @@ func NewProxmoxClient(nodeName string, storageType string, httpClient *httpcli.C
 	return client
 }
 
-// CloneVM creates a new VM by cloning from a template
-func (c *HTTPProxmoxClient) CloneVM(templateID int, newVMID int, name string) (*agent.TaskResponse, error) {
+// CloneVM creates a new VM by cloning from a template, on the target host if not empty
+func (c *HTTPProxmoxClient) CloneVM(templateID int, newVMID int, name string, target string, linked bool) (*agent.TaskResponse, error) {
 	form := url.Values{}
 	form.Add("newid", strconv.Itoa(newVMID))
-	form.Add("full", "1")
-	form.Add("storage", c.storageType)
+	// Linked clones stay on the storage of the template
+	if linked {
+		form.Add("full", "0")
+	} else {
+		form.Add("full", "1")
+		form.Add("storage", c.storageType)
+	}
 	form.Add("name", name)
+	if target != "" {
+		form.Add("target", target)
+	}
 
-	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d/clone", c.nodeName, templateID)
+	endpoint, err := c.vmEndpoint(templateID, "/clone")
+	if err != nil {
+		return nil, err
+	}
 
 	return c.post(endpoint, form)
 }
@@ -56,28 +67,128 @@ func (c *HTTPProxmoxClient) ConfigureVM(vmID int, cores int, memory int, cloudIn
 		form.Add("cicustom", cloudInitConfig)
 	}
 
-	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d/config", c.nodeName, vmID)
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// RenameVM changes the name of a VM
+func (c *HTTPProxmoxClient) RenameVM(vmID int, name string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add("name", name)
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// ConfigureNIC configures the network device of a VM (net0)
+func (c *HTTPProxmoxClient) ConfigureNIC(vmID int, netConfig string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add("net0", netConfig)
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// ConfigureNetwork configures the cloud-init network of a VM (ipconfig0 and name servers)
+func (c *HTTPProxmoxClient) ConfigureNetwork(vmID int, ipConfig string, nameservers []string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add("ipconfig0", ipConfig)
+	if len(nameservers) > 0 {
+		form.Add("nameserver", strings.Join(nameservers, " "))
+	}
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
 
 	return c.post(endpoint, form)
 }
 
 // StartVM starts a virtual machine
 func (c *HTTPProxmoxClient) StartVM(vmID int) (*agent.TaskResponse, error) {
-	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d/status/start", c.nodeName, vmID)
+	endpoint, err := c.vmEndpoint(vmID, "/status/start")
+	if err != nil {
+		return nil, err
+	}
 
 	return c.post(endpoint, url.Values{})
 }
 
-// StopVM stops a virtual machine
+// StopVM stops a virtual machine right away, like pulling the power cord
 func (c *HTTPProxmoxClient) StopVM(vmID int) (*agent.TaskResponse, error) {
-	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d/status/stop", c.nodeName, vmID)
+	endpoint, err := c.vmEndpoint(vmID, "/status/stop")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, url.Values{})
+}
+
+// ShutdownVM asks the guest of a virtual machine to shut down, stopping it after the timeout if forceStop
+func (c *HTTPProxmoxClient) ShutdownVM(vmID int, timeout time.Duration, forceStop bool) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add("timeout", strconv.Itoa(int(timeout.Seconds())))
+	if forceStop {
+		form.Add("forceStop", "1")
+	}
+
+	endpoint, err := c.vmEndpoint(vmID, "/status/shutdown")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// RebootVM asks the guest of a virtual machine to reboot
+func (c *HTTPProxmoxClient) RebootVM(vmID int) (*agent.TaskResponse, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/status/reboot")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, url.Values{})
+}
+
+// SuspendVM pauses a virtual machine
+func (c *HTTPProxmoxClient) SuspendVM(vmID int) (*agent.TaskResponse, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/status/suspend")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, url.Values{})
+}
+
+// ResumeVM resumes a paused virtual machine
+func (c *HTTPProxmoxClient) ResumeVM(vmID int) (*agent.TaskResponse, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/status/resume")
+	if err != nil {
+		return nil, err
+	}
 
 	return c.post(endpoint, url.Values{})
 }
 
 // DeleteVM deletes a virtual machine
 func (c *HTTPProxmoxClient) DeleteVM(vmID int) (*agent.TaskResponse, error) {
-	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d", c.nodeName, vmID)
+	endpoint, err := c.vmEndpoint(vmID, "")
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := c.httpClient.Delete(endpoint)
 	if err != nil {
@@ -159,7 +270,11 @@ func (c *HTTPProxmoxClient) WaitForTask(taskID string, timeout time.Duration) (*
 
 // GetVMInfo retrieves the current status of a virtual machine
 func (c *HTTPProxmoxClient) GetVMInfo(vmID int) (*agent.VMInfo, error) {
-	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d/status/current", c.nodeName, vmID)
+	node, err := c.vmNode(vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate VM %d: %w", vmID, err)
+	}
+	endpoint := fmt.Sprintf("/api2/json/nodes/%s/qemu/%d/status/current", node, vmID)
 
 	resp, err := c.httpClient.Get(endpoint)
 	if err != nil {
@@ -185,7 +300,7 @@ func (c *HTTPProxmoxClient) GetVMInfo(vmID int) (*agent.VMInfo, error) {
 		Name:      statusResp.Data.Name,
 		Status:    statusResp.Data.Status,
 		VMID:      statusResp.Data.VMID,
-		NodeName:  c.nodeName,
+		NodeName:  node,
 		CPU:       statusResp.Data.CPU,
 		CPUCount:  statusResp.Data.CPUCount,
 		Memory:    statusResp.Data.Memory,
@@ -194,6 +309,7 @@ func (c *HTTPProxmoxClient) GetVMInfo(vmID int) (*agent.VMInfo, error) {
 		MaxDisk:   statusResp.Data.MaxDisk,
 		Uptime:    statusResp.Data.Uptime,
 		QMPStatus: statusResp.Data.QMPStatus,
+		Template:  statusResp.Data.Template,
 	}, nil
 }
 
@@ -55,7 +55,7 @@ func TestVMIntegration(t *testing.T) {
 			cfg.ProxmoxTemplate, testVMID, vmName)
 
 		// 1. Clone the VM
-		cloneResp, err := cli.CloneVM(cfg.ProxmoxTemplate, testVMID, vmName)
+		cloneResp, err := cli.CloneVM(cfg.ProxmoxTemplate, testVMID, vmName, "", false)
 		require.NoError(t, err, "CloneVM should not return an error")
 		require.NotNil(t, cloneResp, "CloneVM should return a response")
 		require.NotEmpty(t, cloneResp.TaskID, "CloneVM should return a task ID")
@@ -76,7 +76,6 @@ func TestVMIntegration(t *testing.T) {
 			Hostname:       vmName,
 			FQDN:           vmName,
 			Username:       "ubuntu",
-			Password:       "ubuntu",
 			SSHKeys:        []string{"ssh-rsa AAAAB3NzaC1yc2EAAAA... test@example.com"},
 			ExpirePassword: false,
 			PackageUpgrade: true,
@@ -209,7 +208,7 @@ func TestVMIntegration(t *testing.T) {
 			nonExistentTemplateID, testVMID, vmName)
 
 		// Attempt to clone the VM from a non-existent template
-		cloneResp, err := cli.CloneVM(nonExistentTemplateID, testVMID, vmName)
+		cloneResp, err := cli.CloneVM(nonExistentTemplateID, testVMID, vmName, "", false)
 
 		// Should return an error
 		require.Error(t, err, "CloneVM with non-existent template should return an error")
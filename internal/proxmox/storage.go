@@ -0,0 +1,93 @@
+package proxmox
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"strings"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// AttachCDROM attaches a volume to a drive of a VM as a CD-ROM
+func (c *HTTPProxmoxClient) AttachCDROM(vmID int, drive string, volume string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add(drive, volume+",media=cdrom")
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// DeleteVMConfig removes options from the configuration of a VM, such as 'cicustom' or a drive
+func (c *HTTPProxmoxClient) DeleteVMConfig(vmID int, options []string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add("delete", strings.Join(options, ","))
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// UploadISO uploads an ISO image to a storage
+func (c *HTTPProxmoxClient) UploadISO(storage string, fileName string, content []byte) (*agent.TaskResponse, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.WriteField("content", "iso"); err != nil {
+		return nil, fmt.Errorf("failed to create upload form: %w", err)
+	}
+	// Proxmox expects the file to be the last part of the form
+	part, err := writer.CreateFormFile("filename", fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload form: %w", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to create upload form: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to create upload form: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("/api2/json/nodes/%s/storage/%s/upload", c.nodeName, storage)
+	resp, err := c.httpClient.PostMultipart(endpoint, writer.FormDataContentType(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var upid string
+	if err := decodeData(resp, "failed to upload ISO image", &upid); err != nil {
+		return nil, err
+	}
+
+	return parseUPID(upid)
+}
+
+// GetStorageStatus retrieves the usage of a storage of a host, the default one if empty
+func (c *HTTPProxmoxClient) GetStorageStatus(host string, storage string) (*agent.StorageStatus, error) {
+	if host == "" {
+		host = c.nodeName
+	}
+	resp, err := c.httpClient.Get(fmt.Sprintf("/api2/json/nodes/%s/storage/%s/status", host, storage))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		agent.StorageStatus
+		Shared int `json:"shared"`
+	}
+	if err := decodeData(resp, "failed to get storage status", &status); err != nil {
+		return nil, err
+	}
+	status.StorageStatus.Shared = status.Shared == 1
+	return &status.StorageStatus, nil
+}
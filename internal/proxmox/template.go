@@ -0,0 +1,95 @@
+package proxmox
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"fulcrumproject.org/kube-agent/internal/agent"
+)
+
+// CreateVM creates an empty VM on the configured host, with a network device and a virtio SCSI controller
+func (c *HTTPProxmoxClient) CreateVM(vmID int, name string, cores int, memory int, netConfig string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add("vmid", strconv.Itoa(vmID))
+	form.Add("name", name)
+	form.Add("cores", strconv.Itoa(cores))
+	form.Add("memory", strconv.Itoa(memory))
+	form.Add("net0", netConfig)
+	form.Add("scsihw", "virtio-scsi-pci")
+	form.Add("ostype", "l26")
+
+	return c.post(fmt.Sprintf("/api2/json/nodes/%s/qemu", c.nodeName), form)
+}
+
+// ImportDisk imports a disk image volume into a new disk of a VM on a storage
+func (c *HTTPProxmoxClient) ImportDisk(vmID int, drive string, storage string, volume string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add(drive, fmt.Sprintf("%s:0,import-from=%s", storage, volume))
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// AttachCloudInitDrive adds a cloud-init drive to a VM on a storage
+func (c *HTTPProxmoxClient) AttachCloudInitDrive(vmID int, drive string, storage string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	form.Add(drive, storage+":cloudinit")
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// SetVMConfig sets options of the configuration of a VM, such as 'serial0' or 'agent'
+func (c *HTTPProxmoxClient) SetVMConfig(vmID int, options map[string]string) (*agent.TaskResponse, error) {
+	form := url.Values{}
+	for option, value := range options {
+		form.Add(option, value)
+	}
+
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, form)
+}
+
+// GetVMDescription retrieves the description of a VM
+func (c *HTTPProxmoxClient) GetVMDescription(vmID int) (string, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/config")
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Get(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var config struct {
+		Description string `json:"description"`
+	}
+	if err := decodeData(resp, "failed to get VM config", &config); err != nil {
+		return "", err
+	}
+	return config.Description, nil
+}
+
+// ConvertToTemplate converts a stopped VM into a template
+func (c *HTTPProxmoxClient) ConvertToTemplate(vmID int) (*agent.TaskResponse, error) {
+	endpoint, err := c.vmEndpoint(vmID, "/template")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.post(endpoint, url.Values{})
+}